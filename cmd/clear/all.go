@@ -4,57 +4,21 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package clear
 
 import (
+	"context"
 	"fmt"
-	"log/slog"
-	"net/http"
 	"os"
-	"time"
 
 	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 )
 
-var (
-	gContext string
-	gc       *gclient.GrafanaClient
-)
-
 var allCmd = &cobra.Command{
 	Use:   "all",
 	Short: "Clears all watcher resources on Grafana.",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		err := configContext.ReadConfigFile(gcf)
-		if err != nil {
-			logger.Error("Failed to read config file", slog.String("error", err.Error()))
-			os.Exit(1)
-		}
-
-		if cmd.Flag("context").Value.String() == "" {
-			gContext = configContext.CurrentContext
-			if gContext == "" {
-				logger.Error("Run config use-context to set the current context or supply the context to use")
-				os.Exit(1)
-			}
-		}
-
-		currentContextConfig, err := configContext.GetContext(gContext)
-		if err != nil {
-			logger.Error("Failed to read current context", slog.String("error", err.Error()))
-			os.Exit(1)
-		}
-
-		gc = &gclient.GrafanaClient{
-			Url:      "https://grafana.o11y.web3factory.consensys.net",
-			TenantId: currentContextConfig.Context.Dashboards.GrafanaTenant,
-			ApiKey:   currentContextConfig.Authentication.Grafana.Token,
-			Logger:   logger,
-			HttpClient: &http.Client{
-				Timeout: 60 * time.Second,
-			},
-		}
-	},
 	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
 		watcherDashboards := configContext.GetWatchedDashboards()
 
 		if len(watcherDashboards) > 0 {
@@ -65,7 +29,7 @@ var allCmd = &cobra.Command{
 				eg.Go(func() error {
 					dbClient := &gclient.GrafanaDashboardClient{}
 					dbClient.Uid = val.Uid
-					if err := gc.DeleteWatcherDashboard(dbClient); err != nil {
+					if err := gc.DeleteWatcherDashboard(ctx, dbClient); err != nil {
 						return fmt.Errorf("dashboard delete error, uid=%s, error=%v", val.Uid, err)
 					}
 					return nil
@@ -90,9 +54,78 @@ var allCmd = &cobra.Command{
 		} else {
 			logger.Info("No watcher dashboards, aborting operation")
 		}
+
+		clearAlertRuleWatchers(ctx)
+		clearAlertingWatchers(ctx)
 	},
 }
 
-func init() {
-	allCmd.Flags().StringVarP(&gContext, "context", "c", "", "Override current context")
+// Removes the alert rule watchers recorded in the current context
+func clearAlertRuleWatchers(ctx context.Context) {
+	watcherAlertRules := configContext.GetWatchedKindResources(gcontext.AlertRuleResource)
+	if len(watcherAlertRules) == 0 {
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Clearing %d watcher alert rules from Grafana", len(watcherAlertRules)))
+	hasFailed := false
+	for _, val := range watcherAlertRules {
+		arClient := &gclient.GrafanaAlertRuleClient{}
+		arClient.Uid = val.Uid
+		if err := gc.DeleteWatcherAlertRule(ctx, arClient); err != nil && err != gclient.ErrAlertRuleNotFound {
+			hasFailed = true
+			logger.Error(fmt.Sprintf("alert rule delete error, uid=%s, error=%v", val.Uid, err))
+			continue
+		}
+		if err := configContext.ClearKindResourceByPath(gcontext.AlertRuleResource, val.Path); err != nil {
+			hasFailed = true
+			logger.Error(fmt.Sprintf("clear alert rule config error, uid=%s, error=%v", val.Uid, err))
+		}
+	}
+
+	if !hasFailed {
+		logger.Info("Successfully removed watcher alert rules from Grafana")
+	}
+}
+
+// Removes the contact point copies and restores the notification policy
+// backups recorded in the current context
+func clearAlertingWatchers(ctx context.Context) {
+	hasFailed := false
+	watcherContactPoints := configContext.GetWatchedKindResources(gcontext.ContactPointResource)
+	if len(watcherContactPoints) > 0 {
+		logger.Info(fmt.Sprintf("Clearing %d watcher contact points from Grafana", len(watcherContactPoints)))
+	}
+	for _, val := range watcherContactPoints {
+		cpClient := &gclient.GrafanaContactPointClient{Name: val.Uid, FilePath: val.Path}
+		if err := gc.DeleteWatcherContactPoint(ctx, cpClient); err != nil {
+			hasFailed = true
+			logger.Error(fmt.Sprintf("contact point delete error, name=%s, error=%v", val.Uid, err))
+			continue
+		}
+		if err := configContext.ClearKindResourceByPath(gcontext.ContactPointResource, val.Path); err != nil {
+			hasFailed = true
+			logger.Error(fmt.Sprintf("clear contact point config error, name=%s, error=%v", val.Uid, err))
+		}
+	}
+
+	for _, val := range configContext.GetWatchedKindResources(gcontext.PolicyResource) {
+		if val.Backup != "" {
+			pClient := &gclient.GrafanaPolicyClient{FilePath: val.Path, BackupPath: val.Backup}
+			if err := gc.RestorePolicyBackup(ctx, pClient); err != nil && !os.IsNotExist(err) {
+				hasFailed = true
+				logger.Error(fmt.Sprintf("notification policy restore error, backup=%s, error=%v", val.Backup, err))
+				continue
+			}
+			logger.Info(fmt.Sprintf("Restored notification policy from backup %s", val.Backup))
+		}
+		if err := configContext.ClearKindResourceByPath(gcontext.PolicyResource, val.Path); err != nil {
+			hasFailed = true
+			logger.Error(fmt.Sprintf("clear notification policy config error, error=%v", err))
+		}
+	}
+
+	if len(watcherContactPoints) > 0 && !hasFailed {
+		logger.Info("Successfully removed watcher contact points from Grafana")
+	}
 }
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/alex067/gsync/internal/pkg/gclient"
 	"github.com/alex067/gsync/internal/pkg/gcontext"
 	"github.com/spf13/cobra"
 )
@@ -15,12 +16,39 @@ var (
 	gcf           gcontext.GConfigFile
 	logger        *slog.Logger
 	configContext gcontext.GConfigContext
+	gContext      string
+	gc            *gclient.GrafanaClient
 )
 
 // configCmd represents the config command
 var ClearCmd = &cobra.Command{
 	Use:   "clear",
 	Short: "Clears watcher resources on Grafana.",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		err := configContext.ReadConfigFile(gcf)
+		if err != nil {
+			logger.Error("Failed to read config file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if cmd.Flag("context").Value.String() == "" {
+			gContext = configContext.CurrentContext
+			if gContext == "" {
+				logger.Error("Run config use-context to set the current context or supply the context to use")
+				os.Exit(1)
+			}
+		} else {
+			configContext.SetCurrentContext(gContext, true)
+		}
+
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		gc = gclient.NewGrafanaClient(currentContextConfig, logger)
+	},
 }
 
 func init() {
@@ -30,5 +58,9 @@ func init() {
 	gcf.Directory = ".gsync"
 	gcf.Name = "config.yaml"
 
+	ClearCmd.PersistentFlags().StringVarP(&gContext, "context", "c", "", "Override current context")
+
 	ClearCmd.AddCommand(allCmd)
+	ClearCmd.AddCommand(dashboardCmd)
+	ClearCmd.AddCommand(orphansCmd)
 }
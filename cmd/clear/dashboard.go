@@ -0,0 +1,204 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package clear
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/prompt"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dashboardTargets []string
+	saveChanges      bool
+	force            bool
+)
+
+var dashboardCmd = &cobra.Command{
+	Use:   "dashboard",
+	Short: "Clears selected watcher resources on Grafana.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		watcherDashboards := configContext.GetWatchedDashboards()
+		if len(watcherDashboards) == 0 {
+			logger.Info("No watcher dashboards, aborting operation")
+			return
+		}
+
+		var selected []gcontext.GContextGrafanaResource
+		if len(dashboardTargets) == 0 {
+			// Display multi select menu
+			var mSelector prompt.MultiSelector
+			selectedPaths, err := mSelector.RunWatcherMultiSelectMenu(
+				currentContextConfig.Context.Dashboards.Path,
+				watcherDashboards,
+			)
+			if err != nil {
+				logger.Error("Failed to select dashboard", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			for _, resource := range watcherDashboards {
+				for _, selectedPath := range selectedPaths {
+					if resource.Path == selectedPath {
+						selected = append(selected, resource)
+					}
+				}
+			}
+		} else {
+			selected, err = matchWatchers(currentContextConfig, watcherDashboards, dashboardTargets)
+			if err != nil {
+				logger.Error("Failed to find watcher dashboard", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+
+		if len(selected) == 0 {
+			logger.Info("No dashboards selected, aborting operation")
+			return
+		}
+
+		// Watchers of a running session are cleared by stopping the session
+		var dbClients []*gclient.GrafanaDashboardClient
+		for _, resource := range selected {
+			if resource.IsOwnerAlive() && !force {
+				logger.Warn(
+					"Watcher is in use by a running gsync session, skipping",
+					slog.String("path", resource.Path),
+					slog.Int("pid", resource.Pid))
+				continue
+			}
+			dbClients = append(dbClients, gclient.NewWatcherClient(resource, ""))
+		}
+
+		clearWatchers(ctx, dbClients)
+	},
+}
+
+// Finds the watchers for the given uids, paths or globs relative to the dashboards path
+func matchWatchers(
+	currentContext gcontext.GContext,
+	watcherDashboards []gcontext.GContextGrafanaResource,
+	targets []string,
+) ([]gcontext.GContextGrafanaResource, error) {
+	var matched []gcontext.GContextGrafanaResource
+	seen := make(map[string]bool)
+
+	for _, target := range targets {
+		pattern := filepath.Join(currentContext.Context.Dashboards.Path, target)
+		isFound := false
+		for _, resource := range watcherDashboards {
+			isMatch, err := filepath.Match(pattern, resource.Path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dashboard pattern %s: %v", target, err)
+			}
+			if !isMatch && resource.Uid != target && resource.Path != target {
+				continue
+			}
+			isFound = true
+			if !seen[resource.Uid] {
+				seen[resource.Uid] = true
+				matched = append(matched, resource)
+			}
+		}
+		if !isFound {
+			return nil, fmt.Errorf("no watcher dashboard found for %s", target)
+		}
+	}
+	return matched, nil
+}
+
+// Optionally saves, then removes the watchers from Grafana and the config
+func clearWatchers(ctx context.Context, dbClients []*gclient.GrafanaDashboardClient) {
+	type watcherResult struct {
+		isSaved   bool
+		saveErr   error
+		deleteErr error
+	}
+	results := make([]watcherResult, len(dbClients))
+
+	var wg sync.WaitGroup
+	for i, dbClient := range dbClients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if saveChanges {
+				results[i].isSaved, results[i].saveErr = gc.SaveWatcherToDisk(ctx, dbClient)
+				if results[i].saveErr == gclient.ErrDashboardNotFound {
+					results[i].saveErr = nil
+				} else if results[i].saveErr != nil {
+					// Keep the watcher so the changes are not lost
+					return
+				}
+			}
+			results[i].deleteErr = gc.DeleteWatcherDashboard(ctx, dbClient)
+			// Watchers deleted in Grafana only need their config entry cleared
+			if results[i].deleteErr == gclient.ErrDashboardNotFound {
+				results[i].deleteErr = nil
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Config entries are cleared one at a time since each one writes the config file
+	hasFailed := false
+	for i, dbClient := range dbClients {
+		result := results[i]
+		switch {
+		case result.saveErr != nil:
+			hasFailed = true
+			logger.Error(
+				"failed saving dashboard, watcher kept",
+				slog.String("path", dbClient.FilePath),
+				slog.String("error", result.saveErr.Error()))
+			continue
+		case result.deleteErr != nil:
+			hasFailed = true
+			logger.Error(
+				"failed deleting dashboard",
+				slog.String("path", dbClient.FilePath),
+				slog.String("uid", dbClient.Uid),
+				slog.String("error", result.deleteErr.Error()))
+			continue
+		}
+
+		if err := configContext.ClearResourceDashboardByPath(dbClient.FilePath); err != nil {
+			hasFailed = true
+			logger.Error(
+				"failed clearing resource from config",
+				slog.String("path", dbClient.FilePath),
+				slog.String("error", err.Error()))
+			continue
+		}
+
+		if result.isSaved {
+			logger.Info("Saved and removed watcher", slog.String("path", dbClient.FilePath))
+		} else {
+			logger.Info("Removed watcher", slog.String("path", dbClient.FilePath))
+		}
+	}
+
+	if hasFailed {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	dashboardCmd.Flags().StringArrayVarP(&dashboardTargets, "dashboard", "d", nil, "Dashboard file relative path, glob or watcher uid to clear, repeatable (ex: example/foobar.json, example/*.json)")
+	dashboardCmd.Flags().BoolVar(&saveChanges, "save", false, "Save the latest watcher state to the local file before clearing")
+	dashboardCmd.Flags().BoolVar(&force, "force", false, "Clear watchers in use by a running gsync session")
+}
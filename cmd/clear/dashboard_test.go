@@ -0,0 +1,54 @@
+package clear
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+)
+
+func TestMatchWatchers(t *testing.T) {
+	currentContext := gcontext.GContext{}
+	currentContext.Context.Dashboards.Path = "dashboards"
+	watchers := []gcontext.GContextGrafanaResource{
+		{Uid: "w-cpu", Path: filepath.Join("dashboards", "infra", "cpu.json")},
+		{Uid: "w-mem", Path: filepath.Join("dashboards", "infra", "memory.json")},
+		{Uid: "w-api", Path: filepath.Join("dashboards", "api.json")},
+	}
+	matchedUids := func(matched []gcontext.GContextGrafanaResource) []string {
+		var uids []string
+		for _, resource := range matched {
+			uids = append(uids, resource.Uid)
+		}
+		return uids
+	}
+
+	tests := []struct {
+		name     string
+		targets  []string
+		expected []string
+	}{
+		{"test relative paths", []string{filepath.Join("infra", "cpu.json")}, []string{"w-cpu"}},
+		{"test globs", []string{filepath.Join("infra", "*.json")}, []string{"w-cpu", "w-mem"}},
+		{"test watcher uids", []string{"w-api"}, []string{"w-api"}},
+		{"test watchers matched twice are cleared once", []string{"w-cpu", filepath.Join("infra", "*.json")}, []string{"w-cpu", "w-mem"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matched, err := matchWatchers(currentContext, watchers, test.targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchedUids(matched); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %v, want %v", got, test.expected)
+			}
+		})
+	}
+
+	t.Run("test unknown targets are rejected", func(t *testing.T) {
+		if _, err := matchWatchers(currentContext, watchers, []string{"w-api", "missing.json"}); err == nil {
+			t.Fatal("expected error for a target without watcher")
+		}
+	})
+}
@@ -0,0 +1,119 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package clear
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gtable"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	dryRun          bool
+	ttl             time.Duration
+	includeUntagged bool
+)
+
+var orphansCmd = &cobra.Command{
+	Use:   "orphans",
+	Short: "Clears watcher dashboards on Grafana no running gsync session owns.",
+	Long: `Searches Grafana for watcher dashboards left behind by crashed sessions or a
+lost config file. A watcher is orphaned when it has no config entry and the
+process that created it on this host is gone, or it is older than the ttl.
+
+Watchers still recorded in the config are never cleared, their owner may have
+stopped before saving. They are listed so they can be recovered with
+gsync start dashboard --resume.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		// Watchers from every context may live in the same Grafana
+		recorded := make(map[string]gcontext.GContextGrafanaResource)
+		for _, context := range configContext.Contexts {
+			for _, resource := range context.Context.Dashboards.GrafanResources.Resources {
+				recorded[resource.Uid] = resource
+			}
+		}
+
+		found, err := gc.FindOrphanWatchers(ctx, recorded, ttl, includeUntagged)
+		if err != nil {
+			logger.Error("Failed to search watcher dashboards", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		var orphans, unsaved []gclient.OrphanWatcher
+		for _, orphan := range found {
+			if orphan.IsRecorded {
+				unsaved = append(unsaved, orphan)
+			} else {
+				orphans = append(orphans, orphan)
+			}
+		}
+		if len(unsaved) > 0 {
+			logger.Warn(fmt.Sprintf("Kept %d watchers recorded in the config whose owner is gone, run gsync start dashboard --resume to save them", len(unsaved)))
+			printOrphans(unsaved)
+		}
+
+		if len(orphans) == 0 {
+			logger.Info("No orphaned watcher dashboards, aborting operation")
+			return
+		}
+
+		if dryRun {
+			printOrphans(orphans)
+			return
+		}
+
+		logger.Info(fmt.Sprintf("Clearing %d orphaned watcher dashboards from Grafana", len(orphans)))
+		eg := errgroup.Group{}
+		for _, orphan := range orphans {
+			eg.Go(func() error {
+				dbClient := &gclient.GrafanaDashboardClient{}
+				dbClient.Uid = orphan.Uid
+				if err := gc.DeleteWatcherDashboard(ctx, dbClient); err != nil {
+					return fmt.Errorf("dashboard delete error, uid=%s, error=%v", orphan.Uid, err)
+				}
+				return nil
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("Successfully removed orphaned watcher dashboards from Grafana")
+	},
+}
+
+// Lists the orphans that would be cleared, or the recorded watchers kept
+func printOrphans(orphans []gclient.OrphanWatcher) {
+	headers := []string{"UID", "TITLE", "OWNER", "HOST", "SOURCE", "CREATED", "REASON"}
+	var rows [][]string
+	for _, orphan := range orphans {
+		row := []string{orphan.Uid, orphan.Title, "-", "-", "-", "-", orphan.Reason}
+		if orphan.Metadata != nil {
+			row[2] = orphan.Metadata.Owner
+			row[3] = orphan.Metadata.Host
+			row[4] = orphan.Metadata.SourcePath
+			row[5] = orphan.Metadata.CreatedAt.Local().Format(time.DateTime)
+		}
+		if orphan.Path != "" {
+			row[4] = orphan.Path
+		}
+		rows = append(rows, row)
+	}
+
+	gtable.Print(headers, rows)
+}
+
+func init() {
+	orphansCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List orphaned watchers without deleting them")
+	orphansCmd.Flags().DurationVar(&ttl, "ttl", 0, "Also clear watchers older than the ttl, ex: 72h")
+	orphansCmd.Flags().BoolVar(&includeUntagged, "include-untagged", false, "Include watchers created before gsync tagged them, matched by title")
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package diff
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/spf13/cobra"
+)
+
+var contactPointFile string
+
+var contactPointsCmd = &cobra.Command{
+	Use:   "contact-points",
+	Short: "Show the changes between a local contact point file and Grafana.",
+	Long: `Shows the changes between a local contact point file and Grafana. Secure
+settings are redacted by Grafana, changes to secret values are not shown.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if contactPointFile == "" {
+			logger.Error("Provide the contact point file to compare with -f")
+			os.Exit(1)
+		}
+
+		filePath := filepath.Join(currentContextConfig.GetAlertingPath(), contactPointFile)
+		changes, err := gc.DiffContactPoint(ctx, filePath)
+		if err != nil {
+			logger.Error("Failed to compare contact point", slog.String("path", filePath), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		printChanges(changes)
+	},
+}
+
+var policiesCmd = &cobra.Command{
+	Use:   "policies",
+	Short: "Show the changes between the local notification policy tree and Grafana.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		filePath := gclient.PolicyFilePath(currentContextConfig.GetAlertingPath())
+		changes, err := gc.DiffNotificationPolicy(ctx, filePath)
+		if err != nil {
+			logger.Error("Failed to compare notification policy", slog.String("path", filePath), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		printChanges(changes)
+	},
+}
+
+func init() {
+	contactPointsCmd.Flags().StringVarP(&contactPointFile, "file", "f", "", "Contact point file relative to the alerting path (ex: contact-points/on-call.yaml)")
+
+	DiffCmd.AddCommand(contactPointsCmd)
+	DiffCmd.AddCommand(policiesCmd)
+}
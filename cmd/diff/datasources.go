@@ -0,0 +1,61 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package diff
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+	"github.com/spf13/cobra"
+)
+
+var datasourcesCmd = &cobra.Command{
+	Use:   "datasources",
+	Short: "Show the drift between the datasource provisioning file and Grafana.",
+	Long: `Compares each datasource of the provisioning file with Grafana by uid.
+Secure values are not returned by Grafana, only the secret keys are compared.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		filePath := currentContextConfig.GetDatasourcesFile()
+		results, err := gc.DiffDatasources(ctx, filePath)
+		if err != nil {
+			logger.Error("Failed to read datasource file", slog.String("path", filePath), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		failed := 0
+		var changes []gdiff.Change
+		for _, result := range results {
+			if result.Err != nil {
+				failed += 1
+				logger.Error("Failed to compare datasource", slog.String("uid", result.Uid), slog.String("error", result.Err.Error()))
+				continue
+			}
+			// Paths are prefixed with the uid so changes of every datasource fit one list
+			for _, change := range result.Changes {
+				change.Path = fmt.Sprintf("/%s%s", result.Uid, change.Path)
+				change.Summary = fmt.Sprintf("datasource '%s' %s", result.Name, strings.TrimPrefix(change.Summary, "dashboard "))
+				changes = append(changes, change)
+			}
+		}
+		printChanges(changes)
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	DiffCmd.AddCommand(datasourcesCmd)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package diff
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+	"github.com/alex067/gsync/internal/pkg/prompt"
+	"github.com/spf13/cobra"
+)
+
+var (
+	gcf           gcontext.GConfigFile
+	logger        *slog.Logger
+	configContext gcontext.GConfigContext
+	gContext      string
+	dashboardFile string
+	source        string
+	output        string
+	gc            *gclient.GrafanaClient
+)
+
+// DiffCmd represents the diff command
+var DiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Show the changes between a local dashboard file and Grafana.",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		err := configContext.ReadConfigFile(gcf)
+		if err != nil {
+			logger.Error("Failed to read config file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if cmd.Flag("context").Value.String() == "" {
+			gContext = configContext.CurrentContext
+			if gContext == "" {
+				logger.Error("Run config use-context to set the current context or supply the context to use")
+				os.Exit(1)
+			}
+		} else {
+			configContext.SetCurrentContext(gContext, true)
+		}
+
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		gc = gclient.NewGrafanaClient(currentContextConfig, logger)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		var dashboardFilePath string
+		if dashboardFile == "" {
+			var mSelector prompt.MultiSelector
+			dashboardFilePath, err = mSelector.RunDashboardSelectMenu(
+				currentContextConfig.Context.Dashboards.Path,
+				configContext.GetWatchedDashboards(),
+			)
+			if err != nil {
+				logger.Error("Failed to select dashboard", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			if dashboardFilePath == "" {
+				return
+			}
+		} else {
+			dashboardFilePath = filepath.Join(currentContextConfig.Context.Dashboards.Path, dashboardFile)
+		}
+
+		localDashboard, format, err := gclient.ReadDashboardFileFormat(dashboardFilePath)
+		if err != nil {
+			logger.Error("Failed to read dashboard file", slog.String("path", dashboardFilePath), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		remoteDashboard, err := fetchRemoteDashboard(ctx, dashboardFilePath, localDashboard, format)
+		if err != nil {
+			logger.Error("Failed to fetch dashboard from Grafana", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		changes := gdiff.Compare(localDashboard, remoteDashboard)
+
+		printChanges(changes)
+	},
+}
+
+// Fetches the watcher recorded for the file, or the real dashboard by the file uid
+// Attributes that always differ between the two are taken from the local file
+// Both are fetched in the schema of the file
+func fetchRemoteDashboard(
+	ctx context.Context,
+	dashboardFilePath string,
+	localDashboard map[string]interface{},
+	format gclient.DashboardFormat,
+) (map[string]interface{}, error) {
+	watcherUid := configContext.GetResourceByPath(dashboardFilePath)
+
+	switch source {
+	case "auto":
+		if watcherUid == "" {
+			return fetchRealDashboard(ctx, localDashboard, format)
+		}
+	case "watcher":
+		if watcherUid == "" {
+			return nil, fmt.Errorf("no watcher recorded for %s", dashboardFilePath)
+		}
+	case "dashboard":
+		return fetchRealDashboard(ctx, localDashboard, format)
+	default:
+		return nil, fmt.Errorf("unknown source %s, expected auto, watcher or dashboard", source)
+	}
+
+	watcherDashboard, err := gc.GetDashboardInFormat(ctx, watcherUid, format)
+	if err != nil {
+		return nil, fmt.Errorf("uid=%s: %w", watcherUid, err)
+	}
+	return gclient.RestoreLocalAttributes(localDashboard, watcherDashboard.Dashboard), nil
+}
+
+func fetchRealDashboard(ctx context.Context, localDashboard map[string]interface{}, format gclient.DashboardFormat) (map[string]interface{}, error) {
+	uid, _ := localDashboard["uid"].(string)
+	if uid == "" {
+		return nil, fmt.Errorf("dashboard uid attribute not found in given config file")
+	}
+
+	dashboard, err := gc.GetDashboardInFormat(ctx, uid, format)
+	if err != nil {
+		return nil, fmt.Errorf("uid=%s: %w", uid, err)
+	}
+
+	// Instance specific attributes are not edits
+	remoteDashboard := dashboard.Dashboard
+	for _, key := range []string{"id", "version"} {
+		if value, ok := localDashboard[key]; ok {
+			remoteDashboard[key] = value
+		} else {
+			delete(remoteDashboard, key)
+		}
+	}
+	return remoteDashboard, nil
+}
+
+// Prints the changes in the selected output format
+func printChanges(changes []gdiff.Change) {
+	switch output {
+	case "text":
+		if len(changes) == 0 {
+			fmt.Println("No changes")
+			return
+		}
+		fmt.Print(gdiff.FormatText(changes))
+	case "patch":
+		printJson(gdiff.JSONPatch(changes))
+	case "json":
+		printJson(changes)
+	default:
+		logger.Error("Unknown output format, expected text, patch or json", slog.String("output", output))
+		os.Exit(1)
+	}
+}
+
+func printJson(value interface{}) {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		logger.Error("Failed to render changes", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	fmt.Println(string(content))
+}
+
+func init() {
+	logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	gcf.Directory = ".gsync"
+	gcf.Name = "config.yaml"
+
+	DiffCmd.PersistentFlags().StringVarP(&gContext, "context", "c", "", "Override current context")
+	DiffCmd.Flags().StringVarP(&dashboardFile, "dashboard", "d", "", "Grafana dashboard file relative path to compare (ex: example/foobar.json)")
+	DiffCmd.Flags().StringVar(&source, "source", "auto", "Grafana dashboard to compare against (auto, watcher, dashboard)")
+	DiffCmd.PersistentFlags().StringVarP(&output, "output", "o", "text", "Output format (text, patch, json)")
+}
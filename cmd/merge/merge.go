@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package merge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/alex067/gsync/internal/pkg/gmerge"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logger     *slog.Logger
+	outputFile string
+	reportOnly bool
+)
+
+// MergeCmd represents the merge command
+var MergeCmd = &cobra.Command{
+	Use:   "merge base.json ours.json theirs.json",
+	Short: "Three-way merge of dashboard json files.",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		var dashboards [3]map[string]interface{}
+		for i, dashboardFilePath := range args {
+			dashboardFileData, err := os.ReadFile(dashboardFilePath)
+			if err != nil {
+				logger.Error("Failed to read dashboard file", slog.String("path", dashboardFilePath), slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			if err := json.Unmarshal(dashboardFileData, &dashboards[i]); err != nil {
+				logger.Error("Failed to parse dashboard file", slog.String("path", dashboardFilePath), slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+
+		result := gmerge.Merge(dashboards[0], dashboards[1], dashboards[2])
+
+		if reportOnly {
+			fmt.Print(result.Report())
+		} else {
+			content, err := result.MarshalWithConflicts(args[1], args[2])
+			if err != nil {
+				logger.Error("Failed to render merged dashboard", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+
+			if outputFile == "" {
+				fmt.Println(string(content))
+			} else if err := os.WriteFile(outputFile, content, 0644); err != nil {
+				logger.Error("Failed to write merged dashboard", slog.String("path", outputFile), slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+
+		// Non zero exit lets scripts detect unresolved conflicts, like git merge-file
+		if len(result.Conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "%d merge conflicts\n", len(result.Conflicts))
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	MergeCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Write the merged dashboard to a file instead of stdout")
+	MergeCmd.Flags().BoolVar(&reportOnly, "report", false, "Only print a conflict report")
+}
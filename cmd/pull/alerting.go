@@ -0,0 +1,84 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package pull
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/spf13/cobra"
+)
+
+var contactPointNames []string
+
+var contactPointsCmd = &cobra.Command{
+	Use:   "contact-points",
+	Short: "Download contact points into the alerting path.",
+	Long: `Downloads contact points into <alerting path>/contact-points, one file per
+contact point. Secure settings are replaced with environment placeholders such
+as ${GSYNC_SECRET_ON_CALL_SLACK_URL}, set them before pushing.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		results, err := gc.PullContactPoints(ctx, currentContextConfig.GetAlertingPath(), contactPointNames)
+		if err != nil {
+			logger.Error("Failed to read contact points", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		if len(results) == 0 {
+			logger.Info("No contact points found, aborting operation")
+			return
+		}
+
+		if failed := printAlertingResults(results); failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var policiesCmd = &cobra.Command{
+	Use:   "policies",
+	Short: "Download the notification policy tree into the alerting path.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		result := gc.PullNotificationPolicy(ctx, currentContextConfig.GetAlertingPath())
+		if failed := printAlertingResults([]gclient.AlertingResult{result}); failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// Prints one line per file and returns the number of failed pulls
+func printAlertingResults(results []gclient.AlertingResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed += 1
+			logger.Error("Failed to pull", slog.String("name", result.Name), slog.String("error", result.Err.Error()))
+			continue
+		}
+		fmt.Printf("%-10s %s\n", result.Status, result.FilePath)
+	}
+	return failed
+}
+
+func init() {
+	contactPointsCmd.Flags().StringArrayVarP(&contactPointNames, "name", "n", nil, "Contact point name to pull, repeatable (default all)")
+
+	PullCmd.AddCommand(contactPointsCmd)
+	PullCmd.AddCommand(policiesCmd)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package pull
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var datasourceUids []string
+
+var datasourcesCmd = &cobra.Command{
+	Use:   "datasources",
+	Short: "Download datasources into the datasource provisioning file.",
+	Long: `Downloads datasources into a Grafana provisioning file, matching entries by
+uid. Secure values are replaced with environment placeholders such as
+${GSYNC_SECRET_PROMETHEUS_PASSWORD}, set them before pushing.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		filePath := currentContextConfig.GetDatasourcesFile()
+		results, err := gc.PullDatasources(ctx, filePath, datasourceUids)
+		if err != nil {
+			logger.Error("Failed to pull datasources", slog.String("path", filePath), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		failed := 0
+		for _, result := range results {
+			if result.Err != nil {
+				failed += 1
+				logger.Error("Failed to pull datasource", slog.String("uid", result.Uid), slog.String("error", result.Err.Error()))
+				continue
+			}
+			fmt.Printf("%-10s %s (%s)\n", result.Status, result.Name, result.Uid)
+		}
+		logger.Info("Datasources saved", slog.String("path", filePath))
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	datasourcesCmd.Flags().StringArrayVarP(&datasourceUids, "uid", "u", nil, "Datasource uid to pull, repeatable (default all)")
+
+	PullCmd.AddCommand(datasourcesCmd)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package pull
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	libraryPanelUids       []string
+	libraryPanelFolderUids []string
+)
+
+var libraryPanelsCmd = &cobra.Command{
+	Use:   "library-panels",
+	Short: "Download library panels into the dashboards path.",
+	Long: `Downloads library panels into <dashboards path>/library-panels, one file per
+library panel holding its uid, name, folder and panel model. Every library
+panel is pulled when no uid or folder is given.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		// Explicit uids skip the search
+		uids := libraryPanelUids
+		if len(uids) == 0 {
+			libraryPanels, err := gc.SearchLibraryPanels(ctx, libraryPanelFolderUids)
+			if err != nil {
+				logger.Error("Failed to search library panels", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			for _, libraryPanel := range libraryPanels {
+				uids = append(uids, libraryPanel.Uid)
+			}
+		}
+
+		if len(uids) == 0 {
+			logger.Info("No library panels found, aborting operation")
+			return
+		}
+
+		logger.Info(fmt.Sprintf("Pulling %d library panels from Grafana", len(uids)))
+		failed := 0
+		for _, uid := range uids {
+			result := gc.PullLibraryPanel(ctx, uid, currentContextConfig.Context.Dashboards.Path)
+			if result.Err != nil {
+				failed += 1
+				logger.Error("Failed to pull library panel", slog.String("uid", uid), slog.String("error", result.Err.Error()))
+				continue
+			}
+			fmt.Printf("%-10s %s\n", result.Status, result.FilePath)
+		}
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	libraryPanelsCmd.Flags().StringArrayVarP(&libraryPanelUids, "uid", "u", nil, "Library panel uid to pull, repeatable")
+	libraryPanelsCmd.Flags().StringArrayVarP(&libraryPanelFolderUids, "folder", "f", nil, "Pull every library panel in the Grafana folder uid, repeatable")
+
+	PullCmd.AddCommand(libraryPanelsCmd)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package pull
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/spf13/cobra"
+)
+
+var (
+	gcf           gcontext.GConfigFile
+	logger        *slog.Logger
+	configContext gcontext.GConfigContext
+	gContext      string
+	gc            *gclient.GrafanaClient
+	uids          []string
+	folderUids    []string
+	tags          []string
+	query         string
+	namingScheme  string
+	libraryPanels string
+)
+
+// PullCmd represents the pull command
+var PullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Download dashboards from Grafana into the dashboards path.",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		err := configContext.ReadConfigFile(gcf)
+		if err != nil {
+			logger.Error("Failed to read config file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if cmd.Flag("context").Value.String() == "" {
+			gContext = configContext.CurrentContext
+			if gContext == "" {
+				logger.Error("Run config use-context to set the current context or supply the context to use")
+				os.Exit(1)
+			}
+		} else {
+			configContext.SetCurrentContext(gContext, true)
+		}
+
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		gc = gclient.NewGrafanaClient(currentContextConfig, logger)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if libraryPanels == "" {
+			libraryPanels = currentContextConfig.Context.Dashboards.LibraryPanels
+		}
+		gc.LibraryPanels, err = gclient.ParseLibraryPanelMode(libraryPanels)
+		if err != nil {
+			logger.Error("Invalid library-panels value", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if len(uids) == 0 && len(folderUids) == 0 && len(tags) == 0 && query == "" {
+			logger.Error("Provide a uid, folder, tag or query to pull")
+			os.Exit(1)
+		}
+
+		// Explicit uids skip the search
+		dashboardUids := uids
+		if len(folderUids) > 0 || len(tags) > 0 || query != "" {
+			results, err := gc.SearchDashboards(ctx, gclient.GrafanaSearchQuery{
+				Query:      query,
+				Tags:       tags,
+				FolderUids: folderUids,
+				Uids:       uids,
+			})
+			if err != nil {
+				logger.Error("Failed to search dashboards", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			dashboardUids = nil
+			for _, result := range results {
+				dashboardUids = append(dashboardUids, result.Uid)
+			}
+		}
+
+		if len(dashboardUids) == 0 {
+			logger.Info("No dashboards found, aborting operation")
+			return
+		}
+
+		dashboardFiles, err := gclient.IndexDashboardFiles(currentContextConfig.Context.Dashboards.Path)
+		if err != nil {
+			logger.Error("Failed to read dashboards path", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		options := gclient.PullOptions{
+			DashboardsPath: currentContextConfig.Context.Dashboards.Path,
+			NamingScheme:   currentContextConfig.Context.Dashboards.NamingScheme,
+		}
+		if namingScheme != "" {
+			options.NamingScheme = namingScheme
+		}
+		if currentContextConfig.Context.Dashboards.Folders.Mirror {
+			options.Mirror, err = gc.NewFolderMirror(ctx, currentContextConfig.Context.Dashboards.Folders.Mapping)
+			if err != nil {
+				logger.Error("Failed to read Grafana folders", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+
+		logger.Info(fmt.Sprintf("Pulling %d dashboards from Grafana", len(dashboardUids)))
+		failed := 0
+		for _, uid := range dashboardUids {
+			result := gc.PullDashboard(ctx, uid, options, dashboardFiles)
+			if result.Err != nil {
+				failed += 1
+				logger.Error("Failed to pull dashboard", slog.String("uid", uid), slog.String("error", result.Err.Error()))
+				continue
+			}
+			fmt.Printf("%-10s %s\n", result.Status, result.FilePath)
+			// Lets push tell versions saved in Grafana after this pull apart
+			if err := configContext.SetSyncedDashboard(uid, result.FilePath, result.Version); err != nil {
+				logger.Warn("Failed to record pulled version", slog.String("path", result.FilePath), slog.String("error", err.Error()))
+			}
+		}
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	gcf.Directory = ".gsync"
+	gcf.Name = "config.yaml"
+
+	PullCmd.PersistentFlags().StringVarP(&gContext, "context", "c", "", "Override current context")
+	PullCmd.Flags().StringArrayVarP(&uids, "uid", "u", nil, "Dashboard uid to pull, repeatable")
+	PullCmd.Flags().StringArrayVarP(&folderUids, "folder", "f", nil, "Pull every dashboard in the Grafana folder uid, repeatable")
+	PullCmd.Flags().StringArrayVarP(&tags, "tag", "t", nil, "Pull dashboards with the tag, repeatable")
+	PullCmd.Flags().StringVarP(&query, "query", "q", "", "Pull dashboards matching the search query")
+	PullCmd.Flags().StringVar(&libraryPanels, "library-panels", "", "How pulled dashboards keep library panels, overrides the context setting (reference, inline, files)")
+	PullCmd.Flags().StringVar(&namingScheme, "naming", "", "File path of new dashboards relative to the dashboards path (default \"{folder}/{slug}.json\")")
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package push
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/spf13/cobra"
+)
+
+var alertingDryRun bool
+
+var contactPointsCmd = &cobra.Command{
+	Use:   "contact-points [files...]",
+	Short: "Deploy local contact points to Grafana.",
+	Long: `Deploys contact point files relative to the alerting path, every file under
+contact-points when none are given. Environment placeholders such as
+${GSYNC_SECRET_ON_CALL_SLACK_URL} are filled in before pushing, existing
+integrations keep their stored secret when the variable is not set.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		alertingPath := currentContextConfig.GetAlertingPath()
+		filePaths, err := gclient.ResolveContactPointFiles(alertingPath, args)
+		if err != nil {
+			logger.Error("Failed to read contact point files", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		if len(filePaths) == 0 {
+			logger.Info("No contact point files found, aborting operation")
+			return
+		}
+
+		if alertingDryRun {
+			logger.Info("Dry run, no contact points are pushed")
+		}
+
+		var results []gclient.AlertingResult
+		for _, filePath := range filePaths {
+			results = append(results, gc.PushContactPoint(ctx, filePath, alertingDryRun))
+		}
+		if failed := printAlertingSummary(alertingPath, results); failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var policiesCmd = &cobra.Command{
+	Use:   "policies",
+	Short: "Replace the notification policy tree in Grafana with the local file.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if alertingDryRun {
+			logger.Info("Dry run, the notification policy is not pushed")
+		}
+
+		alertingPath := currentContextConfig.GetAlertingPath()
+		result := gc.PushNotificationPolicy(ctx, gclient.PolicyFilePath(alertingPath), alertingDryRun)
+		if failed := printAlertingSummary(alertingPath, []gclient.AlertingResult{result}); failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// Prints one line per file and returns the number of failed pushes
+func printAlertingSummary(alertingPath string, results []gclient.AlertingResult) int {
+	failed := 0
+	fmt.Printf("%-10s%-30s%-10s%s\n", "STATUS", "NAME", "CHANGES", "FILE")
+	for _, result := range results {
+		relativePath, err := filepath.Rel(alertingPath, result.FilePath)
+		if err != nil {
+			relativePath = result.FilePath
+		}
+
+		status := result.Status
+		if result.Err != nil {
+			failed += 1
+			if status == "" {
+				status = "failed"
+			}
+		}
+
+		fmt.Printf("%-10s%-30s%-10d%s\n", status, result.Name, len(result.Changes), relativePath)
+		if result.Err != nil {
+			fmt.Printf("%10s%s\n", "", result.Err.Error())
+		}
+	}
+	return failed
+}
+
+func init() {
+	contactPointsCmd.Flags().BoolVar(&alertingDryRun, "dry-run", false, "Show what would be pushed without changing Grafana")
+	policiesCmd.Flags().BoolVar(&alertingDryRun, "dry-run", false, "Show what would be pushed without changing Grafana")
+
+	PushCmd.AddCommand(contactPointsCmd)
+	PushCmd.AddCommand(policiesCmd)
+}
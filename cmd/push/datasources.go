@@ -0,0 +1,73 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package push
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	datasourceUids   []string
+	datasourceDryRun bool
+)
+
+var datasourcesCmd = &cobra.Command{
+	Use:   "datasources",
+	Short: "Deploy the datasource provisioning file to Grafana.",
+	Long: `Deploys the datasources of the provisioning file by uid. Environment
+placeholders in secureJsonData are filled in before pushing, existing
+datasources keep their stored secret when the variable is not set. Secret
+values cannot be compared, a datasource is only pushed when its other
+attributes changed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if datasourceDryRun {
+			logger.Info("Dry run, no datasources are pushed")
+		}
+
+		filePath := currentContextConfig.GetDatasourcesFile()
+		results, err := gc.PushDatasources(ctx, filePath, datasourceUids, datasourceDryRun)
+		if err != nil {
+			logger.Error("Failed to read datasource file", slog.String("path", filePath), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		failed := 0
+		fmt.Printf("%-10s%-30s%-10s%s\n", "STATUS", "UID", "CHANGES", "NAME")
+		for _, result := range results {
+			status := result.Status
+			if result.Err != nil {
+				failed += 1
+				if status == "" {
+					status = "failed"
+				}
+			}
+			fmt.Printf("%-10s%-30s%-10d%s\n", status, result.Uid, len(result.Changes), result.Name)
+			if result.Err != nil {
+				fmt.Printf("%10s%s\n", "", result.Err.Error())
+			}
+		}
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	datasourcesCmd.Flags().StringArrayVarP(&datasourceUids, "uid", "u", nil, "Datasource uid to push, repeatable (default all)")
+	datasourcesCmd.Flags().BoolVar(&datasourceDryRun, "dry-run", false, "Show what would be pushed without changing Grafana")
+
+	PushCmd.AddCommand(datasourcesCmd)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package push
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/spf13/cobra"
+)
+
+var libraryPanelsDryRun bool
+
+var libraryPanelsCmd = &cobra.Command{
+	Use:   "library-panels [files...]",
+	Short: "Deploy local library panels to Grafana.",
+	Long: `Deploys library panel files relative to the dashboards path, every file in a
+library-panels directory when none are given. Dashboards linking to a library
+panel pick up the change right away.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		dashboardsPath := currentContextConfig.Context.Dashboards.Path
+		var filePaths []string
+		if len(args) == 0 {
+			filePaths, err = gclient.IndexLibraryPanelFiles(dashboardsPath)
+		} else {
+			filePaths, err = currentContextConfig.ResolveDashboardFiles(args)
+		}
+		if err != nil {
+			logger.Error("Failed to read library panel files", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		if len(filePaths) == 0 {
+			logger.Info("No library panel files found, aborting operation")
+			return
+		}
+
+		if libraryPanelsDryRun {
+			logger.Info("Dry run, no library panels are pushed")
+		}
+
+		failed := 0
+		fmt.Printf("%-10s%-30s%-10s%s\n", "STATUS", "UID", "CHANGES", "FILE")
+		for _, filePath := range filePaths {
+			result := gc.PushLibraryPanel(ctx, filePath, libraryPanelsDryRun)
+
+			relativePath, err := filepath.Rel(dashboardsPath, result.FilePath)
+			if err != nil {
+				relativePath = result.FilePath
+			}
+			status := result.Status
+			if result.Err != nil {
+				failed += 1
+				if status == "" {
+					status = "failed"
+				}
+			}
+
+			fmt.Printf("%-10s%-30s%-10d%s\n", status, result.Uid, len(result.Changes), relativePath)
+			if result.Err != nil {
+				fmt.Printf("%10s%s\n", "", result.Err.Error())
+			}
+		}
+
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	libraryPanelsCmd.Flags().BoolVar(&libraryPanelsDryRun, "dry-run", false, "Show what would be pushed without changing Grafana")
+
+	PushCmd.AddCommand(libraryPanelsCmd)
+}
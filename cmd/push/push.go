@@ -0,0 +1,193 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/prompt"
+	"github.com/spf13/cobra"
+)
+
+var (
+	gcf           gcontext.GConfigFile
+	logger        *slog.Logger
+	configContext gcontext.GConfigContext
+	gContext      string
+	gc            *gclient.GrafanaClient
+	pushOptions   gclient.PushOptions
+)
+
+// PushCmd represents the push command
+var PushCmd = &cobra.Command{
+	Use:   "push [files...]",
+	Short: "Deploy local dashboards to Grafana under their real uid.",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		err := configContext.ReadConfigFile(gcf)
+		if err != nil {
+			logger.Error("Failed to read config file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if cmd.Flag("context").Value.String() == "" {
+			gContext = configContext.CurrentContext
+			if gContext == "" {
+				logger.Error("Run config use-context to set the current context or supply the context to use")
+				os.Exit(1)
+			}
+		} else {
+			configContext.SetCurrentContext(gContext, true)
+		}
+
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		gc = gclient.NewGrafanaClient(currentContextConfig, logger)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		var dashboardFilePaths []string
+		if len(args) == 0 {
+			var mSelector prompt.MultiSelector
+			dashboardFilePaths, err = mSelector.RunDashboardMultiSelectMenu(
+				currentContextConfig.Context.Dashboards.Path,
+				configContext.GetWatchedDashboards(),
+			)
+		} else {
+			dashboardFilePaths, err = currentContextConfig.ResolveDashboardFiles(args)
+		}
+		if err != nil {
+			logger.Error("Failed to read dashboard file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if len(dashboardFilePaths) == 0 {
+			logger.Info("No dashboards selected, aborting operation")
+			return
+		}
+
+		if pushOptions.DryRun {
+			logger.Info("Dry run, no dashboards are pushed")
+		}
+
+		// Directories mirror folders unless a folder is given
+		var mirror *gclient.FolderMirror
+		if currentContextConfig.Context.Dashboards.Folders.Mirror && pushOptions.FolderUid == "" {
+			mirror, err = gc.NewFolderMirror(ctx, currentContextConfig.Context.Dashboards.Folders.Mapping)
+			if err != nil {
+				logger.Error("Failed to read Grafana folders", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+		}
+
+		var results []gclient.PushResult
+		for _, dashboardFilePath := range dashboardFilePaths {
+			options := pushOptions
+			options.Synced, _ = configContext.GetKindResource(gcontext.SyncedDashboardResource, dashboardFilePath)
+			if mirror != nil {
+				options.FolderUid, options.NewFolder, err = mirroredFolderUid(ctx, mirror, currentContextConfig.Context.Dashboards.Path, dashboardFilePath)
+				if err != nil {
+					results = append(results, gclient.PushResult{FilePath: dashboardFilePath, Err: err})
+					continue
+				}
+			}
+			result := gc.PushDashboard(ctx, dashboardFilePath, options)
+			results = append(results, result)
+			if result.Err != nil || options.DryRun {
+				continue
+			}
+			// Versions saved in Grafana after this push are refused by the next one
+			if err := configContext.SetSyncedDashboard(result.Uid, dashboardFilePath, result.RemoteVersion); err != nil {
+				logger.Warn("Failed to record pushed version", slog.String("path", dashboardFilePath), slog.String("error", err.Error()))
+			}
+		}
+
+		failed := printSummary(currentContextConfig.Context.Dashboards.Path, results)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// Finds the folder mirroring the directory of the dashboard file
+// Folders are only created outside of dry runs, which return the directory
+// of the missing folder instead, dashboards at the root of the dashboards path
+// keep their current folder
+func mirroredFolderUid(ctx context.Context, mirror *gclient.FolderMirror, dashboardsPath, dashboardFilePath string) (string, string, error) {
+	relativeDir, err := filepath.Rel(dashboardsPath, filepath.Dir(dashboardFilePath))
+	if err != nil {
+		return "", "", err
+	}
+	folderUid, err := mirror.FolderUidForDir(ctx, relativeDir, !pushOptions.DryRun)
+	if errors.Is(err, gclient.ErrFolderNotFound) && pushOptions.DryRun {
+		return "", relativeDir, nil
+	}
+	return folderUid, "", err
+}
+
+// Prints one line per dashboard and returns the number of failed pushes
+func printSummary(dashboardsPath string, results []gclient.PushResult) int {
+	failed := 0
+	fmt.Printf("%-10s%-30s%-12s%-10s%s\n", "STATUS", "UID", "VERSION", "CHANGES", "FILE")
+	for _, result := range results {
+		relativePath, err := filepath.Rel(dashboardsPath, result.FilePath)
+		if err != nil {
+			relativePath = result.FilePath
+		}
+
+		status := result.Status
+		if result.Err != nil {
+			failed += 1
+			if status == "" {
+				status = "failed"
+			}
+		}
+
+		fmt.Printf(
+			"%-10s%-30s%-12s%-10d%s\n",
+			status,
+			result.Uid,
+			fmt.Sprintf("%d -> %d", result.LocalVersion, result.RemoteVersion),
+			len(result.Changes),
+			relativePath,
+		)
+		if result.NewFolder != "" {
+			fmt.Printf("%10sfolder for %s would be created\n", "", result.NewFolder)
+		}
+		if result.Err != nil {
+			fmt.Printf("%10s%s\n", "", result.Err.Error())
+		}
+	}
+	return failed
+}
+
+func init() {
+	logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	gcf.Directory = ".gsync"
+	gcf.Name = "config.yaml"
+
+	PushCmd.PersistentFlags().StringVarP(&gContext, "context", "c", "", "Override current context")
+	PushCmd.Flags().StringVarP(&pushOptions.FolderUid, "folder", "f", "", "Grafana folder uid to push into, existing dashboards keep their folder by default")
+	PushCmd.Flags().StringVarP(&pushOptions.Message, "message", "m", "", "Dashboard version commit message")
+	PushCmd.Flags().BoolVar(&pushOptions.DryRun, "dry-run", false, "Show what would be pushed without changing Grafana")
+	PushCmd.Flags().BoolVar(&pushOptions.Force, "force", false, "Overwrite newer dashboard versions in Grafana")
+}
@@ -4,17 +4,29 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 
 	"github.com/alex067/gsync/cmd/clear"
 	"github.com/alex067/gsync/cmd/config"
+	"github.com/alex067/gsync/cmd/diff"
+	"github.com/alex067/gsync/cmd/merge"
+	"github.com/alex067/gsync/cmd/pull"
+	"github.com/alex067/gsync/cmd/push"
 	"github.com/alex067/gsync/cmd/start"
+	"github.com/alex067/gsync/cmd/status"
 	"github.com/alex067/gsync/cmd/version"
+	"github.com/alex067/gsync/internal/pkg/gchaos"
+	"github.com/alex067/gsync/internal/pkg/gclient"
 	"github.com/spf13/cobra"
 )
 
-var logger *slog.Logger
+var (
+	logger *slog.Logger
+	chaos  string
+)
 
 var RootCmd = &cobra.Command{
 	Use:   "gsync",
@@ -28,7 +40,27 @@ func Execute() {
 	}
 }
 
+// Routes the requests of the Grafana clients through the chaos transport
+// Runs before the command hooks, which create the clients
+// Other HTTP traffic and the Grafana Live websocket are left alone
+func initChaos() {
+	if chaos == "" {
+		return
+	}
+	config, err := gchaos.ParseConfig(chaos)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid chaos value: %v\n", err)
+		os.Exit(1)
+	}
+	chaosLogger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	chaosLogger.Warn("Chaos mode enabled, Grafana requests will fail on purpose", slog.String("faults", chaos))
+	gclient.Transport = gchaos.NewTransport(http.DefaultTransport, config, chaosLogger)
+}
+
 func init() {
+	cobra.OnInitialize(initChaos)
+	RootCmd.PersistentFlags().StringVar(&chaos, "chaos", "", "Inject Grafana API failures, ex: latency=200ms,5xx=0.1,burst=3,429=0.05,truncate=0.05,reset=0.05,auth-expiry=100")
+	RootCmd.PersistentFlags().MarkHidden("chaos")
 	RootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
 		logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
 		slog.SetDefault(logger)
@@ -36,5 +68,10 @@ func init() {
 	RootCmd.AddCommand(config.ConfigCmd)
 	RootCmd.AddCommand(start.StartCmd)
 	RootCmd.AddCommand(clear.ClearCmd)
+	RootCmd.AddCommand(merge.MergeCmd)
+	RootCmd.AddCommand(diff.DiffCmd)
+	RootCmd.AddCommand(pull.PullCmd)
+	RootCmd.AddCommand(push.PushCmd)
+	RootCmd.AddCommand(status.StatusCmd)
 	RootCmd.AddCommand(version.VersionCmd)
 }
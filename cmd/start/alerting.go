@@ -0,0 +1,287 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package start
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/spf13/cobra"
+)
+
+var (
+	contactPointFiles []string
+	alertingInterval  int
+)
+
+var contactPointsCmd = &cobra.Command{
+	Use:   "contact-points",
+	Short: "Watch and sync contact point changes.",
+	Long: `Creates a copy of each local contact point named "<name> (Gsync <id>)" and
+writes edits made to the copy back to the contact point file. Copies hold the
+real secrets, every environment placeholder in the file must be set.`,
+	PreRun: alertingPreRun,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Info("Starting contact point watcher process")
+
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if len(contactPointFiles) == 0 {
+			logger.Error("Provide the contact point files to watch with -f")
+			os.Exit(1)
+		}
+
+		filePaths, err := gclient.ResolveContactPointFiles(currentContextConfig.GetAlertingPath(), contactPointFiles)
+		if err != nil || len(filePaths) == 0 {
+			logger.Error("Failed to read contact point files", slog.Any("files", contactPointFiles), slog.Any("error", err))
+			os.Exit(1)
+		}
+
+		var cpClients []*gclient.GrafanaContactPointClient
+		for _, filePath := range filePaths {
+			if _, err := gclient.ReadContactPointFile(filePath); err != nil {
+				logger.Error(
+					"Failed to parse contact point file",
+					slog.String("path", filePath),
+					slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			cpClients = append(cpClients, &gclient.GrafanaContactPointClient{FilePath: filePath})
+		}
+
+		ctx, cancel := watchContext()
+		defer cancel()
+
+		logger.Info("Starting watcher process", slog.Int("contactPoints", len(cpClients)))
+		logger.Info("Interrupt the process to save current changes to local contact point files")
+
+		exitErr := gc.StartWatchingContactPoints(ctx, configContext, cpClients)
+		if errors.Is(exitErr, context.Canceled) {
+			logger.Info("Saving final changes to disk")
+			shutdownCtx, cancelShutdown := shutdownContext()
+			isKept := shutdownContactPointWatchers(shutdownCtx, cpClients)
+			cancelShutdown()
+			if isKept {
+				fmt.Fprintln(os.Stderr, "Contact point watchers that failed to save were kept in Grafana")
+				os.Exit(1)
+			}
+		} else if exitErr != nil {
+			fmt.Fprintf(os.Stderr, "shutting down process: %v\n", exitErr)
+		}
+	},
+}
+
+var policiesCmd = &cobra.Command{
+	Use:   "policies",
+	Short: "Watch and sync notification policy changes.",
+	Long: `The notification policy tree is a singleton, it is edited in place. The tree
+Grafana had before watching is backed up under the gsync config directory,
+replaced with the local file, and restored when the process exits. A backup
+left by an interrupted session is reused by the next start.`,
+	PreRun: alertingPreRun,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Info("Starting notification policy watcher process")
+
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		configPath, _, err := gcf.GetAbsolutePath()
+		if err != nil {
+			logger.Error("Failed to read config directory", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		pClient := &gclient.GrafanaPolicyClient{
+			FilePath: gclient.PolicyFilePath(currentContextConfig.GetAlertingPath()),
+			BackupPath: filepath.Join(
+				configPath,
+				"backups",
+				fmt.Sprintf("policies-%s-%d.json", gContext, time.Now().Unix())),
+		}
+		if _, err := gclient.ReadPolicyFile(pClient.FilePath); err != nil {
+			logger.Error(
+				"Failed to parse notification policy file, run pull policies first",
+				slog.String("path", pClient.FilePath),
+				slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		ctx, cancel := watchContext()
+		defer cancel()
+
+		logger.Info("Interrupt the process to save current changes and restore the notification policy")
+
+		exitErr := gc.StartWatchingPolicy(ctx, configContext, pClient)
+		if errors.Is(exitErr, context.Canceled) {
+			logger.Info("Saving final changes to disk")
+			shutdownCtx, cancelShutdown := shutdownContext()
+			isKept := shutdownPolicyWatcher(shutdownCtx, pClient)
+			cancelShutdown()
+			if isKept {
+				fmt.Fprintf(os.Stderr, "notification policy backup kept at %s, run start policies again to restore it\n", pClient.BackupPath)
+				os.Exit(1)
+			}
+		} else if exitErr != nil {
+			fmt.Fprintf(os.Stderr, "shutting down process: %v\n", exitErr)
+			if _, err := os.Stat(pClient.BackupPath); err == nil {
+				fmt.Fprintf(os.Stderr, "notification policy backup kept at %s, run start policies again to restore it\n", pClient.BackupPath)
+			}
+		}
+	},
+}
+
+func alertingPreRun(cmd *cobra.Command, args []string) {
+	err := configContext.ReadConfigFile(gcf)
+	if err != nil {
+		logger.Error("Failed to read config file", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	if cmd.Flag("context").Value.String() == "" {
+		gContext = configContext.CurrentContext
+		if gContext == "" {
+			logger.Error("Run config use-context to set the current context or supply the context to use")
+			os.Exit(1)
+		}
+	} else {
+		configContext.SetCurrentContext(gContext, true)
+	}
+
+	currentContextConfig, err := configContext.GetContext(gContext)
+	if err != nil {
+		logger.Error("Failed to read current context", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	gc = gclient.NewGrafanaClient(currentContextConfig, logger)
+	gc.Interval = time.Duration(alertingInterval) * time.Second
+}
+
+// Saves the final state of each contact point watcher, then removes it from
+// Grafana and the config. Watchers that failed to save are kept, reports
+// whether any watcher was kept
+func shutdownContactPointWatchers(ctx context.Context, cpClients []*gclient.GrafanaContactPointClient) bool {
+	type watcherResult struct {
+		saveErr   error
+		deleteErr error
+	}
+	results := make([]watcherResult, len(cpClients))
+
+	var wg sync.WaitGroup
+	for i, cpClient := range cpClients {
+		if cpClient.Name == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if integrations, err := gc.GetContactPoints(ctx, cpClient.Name); err != nil {
+				results[i].saveErr = err
+			} else {
+				cpClient.Integrations = integrations
+				results[i].saveErr = gc.SaveContactPointToDisk(cpClient)
+			}
+			if results[i].saveErr == nil {
+				results[i].deleteErr = gc.DeleteWatcherContactPoint(ctx, cpClient)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Config entries are cleared one at a time since each one writes the config file
+	for i, cpClient := range cpClients {
+		if cpClient.Name == "" || results[i].saveErr != nil || results[i].deleteErr != nil {
+			continue
+		}
+		if err := configContext.ClearKindResourceByPath(gcontext.ContactPointResource, cpClient.FilePath); err != nil {
+			logger.Error(
+				"failed clearing resource from config",
+				slog.String("path", cpClient.FilePath),
+				slog.String("error", err.Error()))
+		}
+	}
+
+	isKept := false
+	for i, cpClient := range cpClients {
+		switch {
+		case cpClient.Name == "":
+			logger.Error("Watcher was never created", slog.String("path", cpClient.FilePath))
+		case results[i].saveErr != nil:
+			isKept = true
+			logger.Error(
+				"failed saving contact point, watcher kept",
+				slog.String("path", cpClient.FilePath),
+				slog.String("name", cpClient.Name),
+				slog.String("error", results[i].saveErr.Error()))
+		case results[i].deleteErr != nil:
+			logger.Error(
+				"failed deleting contact point",
+				slog.String("path", cpClient.FilePath),
+				slog.String("name", cpClient.Name),
+				slog.String("error", results[i].deleteErr.Error()))
+		default:
+			logger.Info("Saved and removed watcher", slog.String("path", cpClient.FilePath))
+		}
+	}
+	return isKept
+}
+
+// Saves the final notification policy tree, then restores the backup and
+// clears the config entry. The watched tree and backup are kept when saving
+// or restoring fails, reports whether they were kept
+func shutdownPolicyWatcher(ctx context.Context, pClient *gclient.GrafanaPolicyClient) bool {
+	tree, err := gc.GetNotificationPolicy(ctx)
+	if err == nil {
+		pClient.Tree = tree
+		err = gc.SavePolicyToDisk(pClient)
+	}
+	if err != nil {
+		logger.Error(
+			"failed saving notification policy, watched tree kept",
+			slog.String("path", pClient.FilePath),
+			slog.String("backup", pClient.BackupPath),
+			slog.String("error", err.Error()))
+		return true
+	}
+
+	if err := gc.RestorePolicyBackup(ctx, pClient); err != nil {
+		logger.Error(
+			"failed restoring notification policy",
+			slog.String("backup", pClient.BackupPath),
+			slog.String("error", err.Error()))
+		return true
+	}
+
+	if err := configContext.ClearKindResourceByPath(gcontext.PolicyResource, pClient.FilePath); err != nil {
+		logger.Error(
+			"failed clearing resource from config",
+			slog.String("path", pClient.FilePath),
+			slog.String("error", err.Error()))
+	}
+	logger.Info("Saved and restored notification policy", slog.String("path", pClient.FilePath))
+	return false
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{contactPointsCmd, policiesCmd} {
+		cmd.Flags().IntVar(&alertingInterval, "interval", 10, "Grafana polling interval")
+		cmd.Flags().StringVarP(&gContext, "context", "c", "", "Override current context")
+	}
+	contactPointsCmd.Flags().StringArrayVarP(&contactPointFiles, "file", "f", nil, "Contact point file relative path or glob to watch from the alerting path, repeatable (ex: contact-points/*.yaml)")
+}
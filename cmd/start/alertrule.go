@@ -0,0 +1,182 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package start
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/spf13/cobra"
+)
+
+var (
+	alertRuleFiles    []string
+	alertRuleInterval int
+)
+
+var alertRuleCmd = &cobra.Command{
+	Use:   "alert-rule",
+	Short: "Watch and sync alert rule changes.",
+	Long: `Creates a paused copy of each local alert rule through the Grafana provisioning
+API and writes edits made to the copy back to the rule file. Rule files hold a
+single rule in the provisioning API format, as YAML or JSON.`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		err := configContext.ReadConfigFile(gcf)
+		if err != nil {
+			logger.Error("Failed to read config file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if cmd.Flag("context").Value.String() == "" {
+			gContext = configContext.CurrentContext
+			if gContext == "" {
+				logger.Error("Run config use-context to set the current context or supply the context to use")
+				os.Exit(1)
+			}
+		} else {
+			configContext.SetCurrentContext(gContext, true)
+		}
+
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		gc = gclient.NewGrafanaClient(currentContextConfig, logger)
+		gc.Interval = time.Duration(alertRuleInterval) * time.Second
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Info("Starting alert rule watcher process")
+
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if len(alertRuleFiles) == 0 {
+			logger.Error("Provide the alert rule files to watch with -r")
+			os.Exit(1)
+		}
+
+		// Rule files live under the dashboards path like the dashboards
+		ruleFilePaths, err := currentContextConfig.ResolveDashboardFiles(alertRuleFiles)
+		if err != nil {
+			logger.Error("Failed to read alert rule file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		var arClients []*gclient.GrafanaAlertRuleClient
+		for _, ruleFilePath := range ruleFilePaths {
+			rule, err := gclient.ReadAlertRuleFile(ruleFilePath)
+			if err != nil {
+				logger.Error(
+					"Failed to parse alert rule file",
+					slog.String("rule", ruleFilePath),
+					slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			if title, _ := rule["title"].(string); title == "" {
+				logger.Error("Alert rule title attribute not found in given file", slog.String("rule", ruleFilePath))
+				os.Exit(1)
+			}
+
+			arClient := &gclient.GrafanaAlertRuleClient{}
+			arClient.FilePath = ruleFilePath
+			arClient.FolderUid = currentContextConfig.Context.Dashboards.GrafanResources.FolderUid
+			arClients = append(arClients, arClient)
+		}
+
+		ctx, cancel := watchContext()
+		defer cancel()
+
+		logger.Info("Starting watcher process", slog.Int("alertRules", len(arClients)))
+		logger.Info("Interrupt the process to save current changes to local alert rule files")
+
+		exitErr := gc.StartWatchingAlertRules(ctx, configContext, arClients)
+		if errors.Is(exitErr, context.Canceled) {
+			logger.Info("Saving final changes to disk")
+			shutdownCtx, cancelShutdown := shutdownContext()
+			defer cancelShutdown()
+			shutdownAlertRuleWatchers(shutdownCtx, arClients)
+		} else if exitErr != nil {
+			fmt.Fprintf(os.Stderr, "shutting down process: %v\n", exitErr)
+		}
+	},
+}
+
+// Saves the final state of each alert rule watcher, then removes it from
+// Grafana and the config
+func shutdownAlertRuleWatchers(ctx context.Context, arClients []*gclient.GrafanaAlertRuleClient) {
+	type watcherResult struct {
+		saveErr   error
+		deleteErr error
+	}
+	results := make([]watcherResult, len(arClients))
+
+	var wg sync.WaitGroup
+	for i, arClient := range arClients {
+		if arClient.Uid == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := gc.GetAlertRuleChanges(ctx, arClient); err != nil {
+				results[i].saveErr = err
+			} else {
+				results[i].saveErr = gc.SaveAlertRuleToDisk(arClient)
+			}
+			results[i].deleteErr = gc.DeleteWatcherAlertRule(ctx, arClient)
+		}()
+	}
+	wg.Wait()
+
+	// Config entries are cleared one at a time since each one writes the config file
+	for i, arClient := range arClients {
+		if arClient.Uid == "" || results[i].deleteErr != nil {
+			continue
+		}
+		if err := configContext.ClearKindResourceByPath(gcontext.AlertRuleResource, arClient.FilePath); err != nil {
+			logger.Error(
+				"failed clearing resource from config",
+				slog.String("path", arClient.FilePath),
+				slog.String("error", err.Error()))
+		}
+	}
+
+	for i, arClient := range arClients {
+		switch {
+		case arClient.Uid == "":
+			logger.Error("Watcher was never created", slog.String("path", arClient.FilePath))
+		case results[i].saveErr != nil:
+			logger.Error(
+				"failed saving alert rule",
+				slog.String("path", arClient.FilePath),
+				slog.String("error", results[i].saveErr.Error()))
+		case results[i].deleteErr != nil:
+			logger.Error(
+				"failed deleting alert rule",
+				slog.String("path", arClient.FilePath),
+				slog.String("uid", arClient.Uid),
+				slog.String("error", results[i].deleteErr.Error()))
+		default:
+			logger.Info("Saved and removed watcher", slog.String("path", arClient.FilePath))
+		}
+	}
+}
+
+func init() {
+	alertRuleCmd.Flags().IntVar(&alertRuleInterval, "interval", 10, "Grafana polling interval")
+	alertRuleCmd.Flags().StringVarP(&gContext, "context", "c", "", "Override current context")
+	alertRuleCmd.Flags().StringArrayVarP(&alertRuleFiles, "rule", "r", nil, "Alert rule file relative path or glob to watch, repeatable (ex: alerts/cpu.yaml, alerts/*.yaml)")
+}
@@ -5,12 +5,10 @@ package start
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -21,16 +19,12 @@ import (
 )
 
 var (
-	dashboardFile string
-	gContext      string
-	gc            *gclient.GrafanaClient
+	dashboardFiles []string
+	gContext       string
+	gc             *gclient.GrafanaClient
+	resume         bool
 )
 
-type GrafanaDashboardJson struct {
-	Uid     string `json:"uid"`
-	Version int    `json:"version"`
-}
-
 // startCmd represents the start command
 var dashboardCmd = &cobra.Command{
 	Use:   "dashboard",
@@ -64,32 +58,62 @@ var dashboardCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		gc = &gclient.GrafanaClient{
-			Url:      currentContextConfig.Url,
-			TenantId: currentContextConfig.Context.Dashboards.GrafanaTenant,
-			ApiKey:   currentContextConfig.Authentication.Grafana.Token,
-			Interval: time.Duration(interval) * time.Second,
-			Logger:   logger,
-			HttpClient: &http.Client{
-				Timeout: 60 * time.Second,
-			},
+		syncLocal, err := cmd.Flags().GetBool("sync-local")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid sync-local value: %v", err)
+			os.Exit(1)
+		}
+
+		conflictResolver, err := newConflictResolver(cmd.Flag("on-conflict").Value.String())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid on-conflict value: %v", err)
+			os.Exit(1)
+		}
+
+		libraryPanels := cmd.Flag("library-panels").Value.String()
+		if libraryPanels == "" {
+			libraryPanels = currentContextConfig.Context.Dashboards.LibraryPanels
+		}
+		libraryPanelMode, err := gclient.ParseLibraryPanelMode(libraryPanels)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid library-panels value: %v", err)
+			os.Exit(1)
 		}
+
+		gc = gclient.NewGrafanaClient(currentContextConfig, logger)
+		gc.Interval = time.Duration(interval) * time.Second
+		gc.SyncLocalChanges = syncLocal
+		gc.ConflictResolver = conflictResolver
+		gc.LibraryPanels = libraryPanelMode
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.Info("Starting dashboard watcher process")
 
+		ctx, cancel := watchContext()
+		defer cancel()
+
 		currentContextConfig, err := configContext.GetContext(gContext)
 		if err != nil {
 			logger.Error("Failed to read current context", slog.String("error", err.Error()))
 			os.Exit(1)
 		}
 
-		var dashboardFilePath string
+		var dashboardFilePaths []string
 
-		if dashboardFile == "" {
+		if resume {
+			dashboardFilePaths, err = resumeWatchers(ctx, currentContextConfig)
+			if err != nil {
+				logger.Error("Failed to resume watchers", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			if len(dashboardFilePaths) == 0 {
+				logger.Info("No leftover watchers to resume, aborting operation")
+				return
+			}
+		} else if len(dashboardFiles) == 0 {
 			// Display multi select menu
 			var mSelector prompt.MultiSelector
-			dashboardFilePath, err = mSelector.RunDashboardSelectMenu(
+			dashboardFilePaths, err = mSelector.RunDashboardMultiSelectMenu(
 				currentContextConfig.Context.Dashboards.Path,
 				configContext.GetWatchedDashboards(),
 			)
@@ -98,89 +122,177 @@ var dashboardCmd = &cobra.Command{
 				os.Exit(1)
 			}
 		} else {
-			// Search for dashboard based on filename
-			dashboardFilePath = filepath.Join(currentContextConfig.Context.Dashboards.Path, dashboardFile)
-			_, err := os.ReadFile(dashboardFilePath)
+			// Search for dashboards based on filenames and glob patterns
+			dashboardFilePaths, err = currentContextConfig.ResolveDashboardFiles(dashboardFiles)
 			if err != nil {
-				logger.Error(
-					"Failed to read dashboard file",
-					slog.String("path", dashboardFilePath),
-					slog.String("error", err.Error()),
-				)
+				logger.Error("Failed to read dashboard file", slog.String("error", err.Error()))
 				os.Exit(1)
 			}
 		}
 
-		var grafanaDashboard GrafanaDashboardJson
-		// Ignore error since file is validated
-		dashboardFileData, _ := os.ReadFile(dashboardFilePath)
-		if err := json.Unmarshal(dashboardFileData, &grafanaDashboard); err != nil {
-			logger.Error("Failed to parse dashboard file", slog.String("error", err.Error()))
-			os.Exit(1)
+		if len(dashboardFilePaths) == 0 {
+			logger.Info("No dashboards selected, aborting operation")
+			return
 		}
 
-		if grafanaDashboard.Uid == "" {
-			logger.Error(
-				"Dashboard uid attribute not found in given config file",
-				slog.String("dashboard", dashboardFilePath),
-			)
-			os.Exit(1)
+		var dbClients []*gclient.GrafanaDashboardClient
+		for _, dashboardFilePath := range dashboardFilePaths {
+			// v2 files hold the uid in their resource metadata
+			grafanaDashboard, err := gclient.ReadDashboardFile(dashboardFilePath)
+			if err != nil {
+				logger.Error(
+					"Failed to parse dashboard file",
+					slog.String("dashboard", dashboardFilePath),
+					slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+
+			if uid, _ := grafanaDashboard["uid"].(string); uid == "" {
+				logger.Error(
+					"Dashboard uid attribute not found in given config file",
+					slog.String("dashboard", dashboardFilePath),
+				)
+				os.Exit(1)
+			}
+
+			dbClient := &gclient.GrafanaDashboardClient{}
+			dbClient.FilePath = dashboardFilePath
+			dbClient.FolderUid = currentContextConfig.Context.Dashboards.GrafanResources.FolderUid
+			dbClients = append(dbClients, dbClient)
 		}
 
 		// Begin watch process
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
 		done := make(chan error, 1)
-		logger.Info("Starting watcher process")
-		logger.Info("Interrupt the process to save current changes to local dashboard config file")
-
-		dbClient := &gclient.GrafanaDashboardClient{}
-		dbClient.FilePath = dashboardFilePath
-		dbClient.FolderUid = currentContextConfig.Context.Dashboards.GrafanResources.FolderUid
+		logger.Info("Starting watcher process", slog.Int("dashboards", len(dbClients)))
+		logger.Info("Interrupt the process to save current changes to local dashboard config files")
 
 		go func() {
-			done <- gc.StartWatchingDashboard(ctx, configContext, dbClient)
+			done <- gc.StartWatchingDashboards(ctx, configContext, dbClients)
 		}()
 
 		exitErr := <-done
 		if exitErr != nil {
-			if exitErr == context.Canceled || exitErr == gclient.ErrCleanShutdown {
+			if errors.Is(exitErr, context.Canceled) {
 				logger.Info("Saving final changes to disk")
-				gc.GetDashboardChanges(dbClient)
-				gc.SaveChangesToDisk(dbClient)
-				// Clear state from config and remove dashboard from Grafana
-				var wg sync.WaitGroup
-				wg.Add(2)
-				go func() {
-					defer wg.Done()
-					err := configContext.ClearResourceDashboardByPath(dbClient.FilePath)
-					if err != nil {
-						logger.Error(
-							"failed clearing resource from config",
-							slog.String("error", err.Error()))
-					}
-				}()
-				go func() {
-					defer wg.Done()
-					err := gc.DeleteWatcherDashboard(dbClient)
-					if err != nil {
-						logger.Error(
-							"failed  deleting dashboard",
-							slog.String("error", err.Error()))
+				shutdownCtx, cancelShutdown := shutdownContext()
+				isKept := shutdownWatchers(shutdownCtx, dbClients)
+				cancelShutdown()
+				if isKept {
+					fmt.Fprintln(os.Stderr, "Watchers that failed to save were kept in Grafana, run gsync start dashboard --resume to recover them")
+					os.Exit(1)
+				}
+			} else {
+				fmt.Fprintf(os.Stderr, "shutting down process: %v\n", exitErr)
+				// Watchers created by a session that failed to start are removed
+				for _, dbClient := range dbClients {
+					if dbClient.Uid != "" {
+						fmt.Fprintln(os.Stderr, "Watchers were left in Grafana, run gsync start --resume to recover them")
+						break
 					}
-				}()
+				}
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// Maps the on-conflict flag to a resolver, prompt asks the user per conflict
+// and stop leaves the resolver unset so the watcher stops
+func newConflictResolver(strategy string) (func(string) (gclient.ConflictResolution, error), error) {
+	switch strategy {
+	case "prompt":
+		return func(filePath string) (gclient.ConflictResolution, error) {
+			var mSelector prompt.MultiSelector
+			resolution, err := mSelector.RunConflictSelectMenu(filePath)
+			if err != nil {
+				return "", err
+			}
+			return gclient.ConflictResolution(resolution), nil
+		}, nil
+	case string(gclient.KeepLocal), string(gclient.KeepRemote), string(gclient.MergeBoth):
+		return func(string) (gclient.ConflictResolution, error) {
+			return gclient.ConflictResolution(strategy), nil
+		}, nil
+	case "stop":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("expected one of prompt, local, remote, merge, stop, got %s", strategy)
+}
+
+// Saves, cleans up and deletes every watcher, then reports per file results
+// Watchers that failed to save keep their dashboard and config entry so no
+// changes are lost, reports whether any watcher was kept
+func shutdownWatchers(ctx context.Context, dbClients []*gclient.GrafanaDashboardClient) bool {
+	type watcherResult struct {
+		saveErr   error
+		deleteErr error
+	}
+	results := make([]watcherResult, len(dbClients))
 
-				wg.Wait()
+	// Save final state and remove dashboards from Grafana
+	var wg sync.WaitGroup
+	for i, dbClient := range dbClients {
+		if dbClient.Uid == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := gc.GetDashboardChanges(ctx, dbClient); err != nil {
+				results[i].saveErr = err
 			} else {
-				fmt.Fprintf(os.Stderr, "shutting down process: %v", exitErr)
+				results[i].saveErr = gc.SaveChangesToDisk(ctx, dbClient)
 			}
+			if results[i].saveErr == nil {
+				results[i].deleteErr = gc.DeleteWatcherDashboard(ctx, dbClient)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Config entries are cleared one at a time since each one writes the config file
+	for i, dbClient := range dbClients {
+		if dbClient.Uid == "" || results[i].saveErr != nil || results[i].deleteErr != nil {
+			continue
 		}
-	},
+		if err := configContext.ClearResourceDashboardByPath(dbClient.FilePath); err != nil {
+			logger.Error(
+				"failed clearing resource from config",
+				slog.String("path", dbClient.FilePath),
+				slog.String("error", err.Error()))
+		}
+	}
+
+	isKept := false
+	for i, dbClient := range dbClients {
+		switch {
+		case dbClient.Uid == "":
+			logger.Error("Watcher was never created", slog.String("path", dbClient.FilePath))
+		case results[i].saveErr != nil:
+			isKept = true
+			logger.Error(
+				"failed saving dashboard, watcher kept",
+				slog.String("path", dbClient.FilePath),
+				slog.String("url", fmt.Sprintf("%s/d/%s", gc.Url, dbClient.Uid)),
+				slog.String("error", results[i].saveErr.Error()))
+		case results[i].deleteErr != nil:
+			logger.Error(
+				"failed deleting dashboard",
+				slog.String("path", dbClient.FilePath),
+				slog.String("uid", dbClient.Uid),
+				slog.String("error", results[i].deleteErr.Error()))
+		default:
+			logger.Info("Saved and removed watcher", slog.String("path", dbClient.FilePath))
+		}
+	}
+	return isKept
 }
 
 func init() {
 	dashboardCmd.Flags().Int("interval", 10, "Grafana polling interval")
+	dashboardCmd.Flags().Bool("sync-local", true, "Upload local dashboard file edits to the watcher dashboards")
+	dashboardCmd.Flags().String("on-conflict", "prompt", "How to settle local and Grafana changes made at the same time (prompt, local, remote, merge, stop)")
+	dashboardCmd.Flags().String("library-panels", "", "How saved dashboards keep library panels, overrides the context setting (reference, inline, files)")
 	dashboardCmd.Flags().StringVarP(&gContext, "context", "c", "", "Override current context")
-	dashboardCmd.Flags().StringVarP(&dashboardFile, "dashboard", "d", "", "Grafana dashboard file relative path to watch (ex: example/foobar.json)")
+	dashboardCmd.Flags().StringArrayVarP(&dashboardFiles, "dashboard", "d", nil, "Grafana dashboard file relative path or glob to watch, repeatable (ex: example/foobar.json, example/*.json)")
 }
@@ -0,0 +1,161 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package start
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/spf13/cobra"
+)
+
+var (
+	folderDir        string
+	folderInterval   int
+	folderOnConflict string
+)
+
+var folderCmd = &cobra.Command{
+	Use:   "folder <folderUid>",
+	Short: "Watch and sync every dashboard in a Grafana folder.",
+	Long: `Watches the dashboards of a Grafana folder directly, no watcher copies are
+made. Each dashboard is saved to a file in a local directory, dashboards
+created in the folder during the session are pulled as new files and
+dashboards deleted or moved out of the folder are reported. Local file edits
+are not uploaded, use push for that.`,
+	Args: cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		err := configContext.ReadConfigFile(gcf)
+		if err != nil {
+			logger.Error("Failed to read config file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		if cmd.Flag("context").Value.String() == "" {
+			gContext = configContext.CurrentContext
+			if gContext == "" {
+				logger.Error("Run config use-context to set the current context or supply the context to use")
+				os.Exit(1)
+			}
+		} else {
+			configContext.SetCurrentContext(gContext, true)
+		}
+
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		gc = gclient.NewGrafanaClient(currentContextConfig, logger)
+		gc.Interval = time.Duration(folderInterval) * time.Second
+		gc.LibraryPanels, err = gclient.ParseLibraryPanelMode(currentContextConfig.Context.Dashboards.LibraryPanels)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid libraryPanels value: %v", err)
+			os.Exit(1)
+		}
+
+		// Local edits are never uploaded to the real dashboards, a conflict
+		// either stops the dashboard or keeps the Grafana version
+		switch folderOnConflict {
+		case "stop":
+		case "remote":
+			gc.ConflictResolver = func(string) (gclient.ConflictResolution, error) {
+				return gclient.KeepRemote, nil
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "invalid on-conflict value: %s, expected stop or remote", folderOnConflict)
+			os.Exit(1)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		currentContextConfig, err := configContext.GetContext(gContext)
+		if err != nil {
+			logger.Error("Failed to read current context", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		ctx, cancel := watchContext()
+		defer cancel()
+
+		folder, err := gc.GetFolder(ctx, args[0])
+		if err != nil {
+			logger.Error("Failed to read Grafana folder", slog.String("folder", args[0]), slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		dir := folderDir
+		if dir == "" {
+			dir = gclient.Slugify(folder.Title)
+		}
+		fw := &gclient.GrafanaFolderWatcher{
+			FolderUid: folder.Uid,
+			Options: gclient.PullOptions{
+				DashboardsPath: filepath.Join(currentContextConfig.Context.Dashboards.Path, dir),
+				NamingScheme:   "{slug}.json",
+			},
+		}
+
+		logger.Info(
+			"Starting folder watcher process",
+			slog.String("folder", folder.Title),
+			slog.String("path", fw.Options.DashboardsPath))
+		logger.Info("Interrupt the process to save current changes to local dashboard files")
+
+		exitErr := gc.StartWatchingFolder(ctx, fw)
+		if errors.Is(exitErr, context.Canceled) {
+			logger.Info("Saving final changes to disk")
+			shutdownCtx, cancelShutdown := shutdownContext()
+			defer cancelShutdown()
+			if err := gc.SyncFolder(shutdownCtx, fw); err != nil {
+				logger.Error("failed saving folder", slog.String("folder", folder.Uid), slog.String("error", err.Error()))
+			}
+			recordSyncedVersions(fw)
+			printFolderSummary(fw)
+		} else if exitErr != nil {
+			fmt.Fprintf(os.Stderr, "shutting down process: %v\n", exitErr)
+		}
+	},
+}
+
+// Records the Grafana version each saved file holds so push can tell later edits apart
+func recordSyncedVersions(fw *gclient.GrafanaFolderWatcher) {
+	for uid, dbClient := range fw.Dashboards {
+		if dbClient.Err != nil {
+			continue
+		}
+		if err := configContext.SetSyncedDashboard(uid, dbClient.FilePath, dbClient.LastVersion); err != nil {
+			logger.Warn("Failed to record synced version", slog.String("path", dbClient.FilePath), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// Reports the dashboards that stopped syncing or left the folder
+func printFolderSummary(fw *gclient.GrafanaFolderWatcher) {
+	for _, dbClient := range fw.Dashboards {
+		if dbClient.Err != nil {
+			logger.Error(
+				"Dashboard stopped syncing",
+				slog.String("path", dbClient.FilePath),
+				slog.String("error", dbClient.Err.Error()))
+		}
+	}
+	for _, uid := range fw.Removed {
+		logger.Warn("Dashboard left the folder during the session", slog.String("uid", uid))
+	}
+	logger.Info("Stopped watching folder", slog.Int("dashboards", len(fw.Dashboards)))
+}
+
+func init() {
+	folderCmd.Flags().IntVar(&folderInterval, "interval", 10, "Grafana polling interval")
+	folderCmd.Flags().StringVarP(&gContext, "context", "c", "", "Override current context")
+	folderCmd.Flags().StringVar(&folderDir, "dir", "", "Directory relative to the dashboards path holding the folder dashboards (default slug of the folder title)")
+	folderCmd.Flags().StringVar(&folderOnConflict, "on-conflict", "stop", "How to settle local and Grafana changes made at the same time (stop, remote)")
+}
@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package start
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+	"github.com/alex067/gsync/internal/pkg/prompt"
+)
+
+// Finds the watchers left behind by interrupted sessions in the current context
+// Watchers out of sync with their local file are shown with the side that
+// changed, only an explicit choice pulls, resets or skips them
+func resumeWatchers(ctx context.Context, currentContextConfig gcontext.GContext) ([]string, error) {
+	var dashboardFilePaths []string
+	var mSelector prompt.MultiSelector
+
+	for _, resource := range configContext.GetWatchedDashboards() {
+		if resource.IsOwnerAlive() {
+			logger.Warn(
+				"Watcher is in use by a running gsync session, skipping",
+				slog.String("path", resource.Path),
+				slog.Int("pid", resource.Pid))
+			continue
+		}
+
+		if _, err := os.Stat(resource.Path); err != nil {
+			logger.Warn(
+				"Dashboard file of leftover watcher not found, skipping",
+				slog.String("path", resource.Path),
+				slog.String("uid", resource.Uid))
+			continue
+		}
+
+		status := gc.GetWatcherStatus(ctx, resource)
+		switch {
+		case status.Error != "":
+			return nil, fmt.Errorf("checking watcher for %s: %s", resource.Path, status.Error)
+		case !status.Exists:
+			logger.Info("Leftover watcher no longer in Grafana, creating a new one", slog.String("path", resource.Path))
+		case len(status.Drift) == 0:
+			logger.Info("Leftover watcher in sync with local file", slog.String("path", resource.Path))
+		default:
+			folderUid := currentContextConfig.Context.Dashboards.GrafanResources.FolderUid
+			isResumed, err := resumeDrift(ctx, mSelector, resource, status, folderUid)
+			if err != nil {
+				return nil, err
+			}
+			if !isResumed {
+				logger.Info("Leftover watcher skipped, left as is", slog.String("path", resource.Path))
+				continue
+			}
+		}
+		dashboardFilePaths = append(dashboardFilePaths, resource.Path)
+	}
+	return dashboardFilePaths, nil
+}
+
+// Describes the side of a drifted watcher that changed since the last sync
+func describeDrift(status gclient.WatcherStatus) string {
+	if !status.SyncRecorded {
+		return "no sync recorded, either side may have changed"
+	}
+	var sides []string
+	if status.LocalChanged {
+		sides = append(sides, "local file edited")
+	}
+	if status.RemoteChanged {
+		sides = append(sides, "saved in Grafana")
+	}
+	if len(sides) == 0 {
+		return "neither side changed since the last sync"
+	}
+	return strings.Join(sides, " and ") + " since the last sync"
+}
+
+// Pulls the watcher changes into the local file, resets the watcher to the
+// local file or leaves both untouched, as chosen by the user
+// Returns false when the watcher is skipped
+func resumeDrift(
+	ctx context.Context,
+	mSelector prompt.MultiSelector,
+	resource gcontext.GContextGrafanaResource,
+	status gclient.WatcherStatus,
+	folderUid string,
+) (bool, error) {
+	fmt.Printf(
+		"\n%s differs from its watcher in %d places, %s (watcher version %d, file version %d)\n",
+		resource.Path,
+		len(status.Drift),
+		describeDrift(status),
+		status.Version,
+		status.LocalVersion,
+	)
+	fmt.Print(gdiff.FormatText(status.Drift))
+
+	// Choices losing edits say so, the side that changed alone comes first
+	isLocalKept := !status.SyncRecorded || status.LocalChanged
+	isRemoteKept := !status.SyncRecorded || status.RemoteChanged
+	local := prompt.ConflictSelectItem{Name: "local", Description: "Reset the watcher to the local file"}
+	if isRemoteKept {
+		local.Description += ", the Grafana changes are lost"
+	}
+	remote := prompt.ConflictSelectItem{Name: "remote", Description: "Pull the watcher changes into the local file"}
+	if isLocalKept {
+		remote.Description += ", the local edits are lost"
+	}
+	skip := prompt.ConflictSelectItem{Name: "skip", Description: "Leave the watcher and the file as is and do not watch it"}
+
+	selectItems := []prompt.ConflictSelectItem{skip, local, remote}
+	switch {
+	case isLocalKept && !isRemoteKept:
+		selectItems = []prompt.ConflictSelectItem{local, remote, skip}
+	case isRemoteKept && !isLocalKept:
+		selectItems = []prompt.ConflictSelectItem{remote, local, skip}
+	}
+
+	choice, err := mSelector.RunResumeSelectMenu(resource.Path, selectItems)
+	if err != nil {
+		return false, fmt.Errorf("resuming watcher for %s: %w", resource.Path, err)
+	}
+
+	dbClient := gclient.NewWatcherClient(resource, folderUid)
+	switch choice {
+	case "remote":
+		if _, err := gc.OverwriteWithWatcher(ctx, dbClient); err != nil {
+			return false, fmt.Errorf("pulling watcher changes for %s: %v", resource.Path, err)
+		}
+		logger.Info("Pulled watcher changes", slog.String("path", resource.Path))
+	case "local":
+		if err := gc.ResetWatcherToLocal(ctx, dbClient); err != nil {
+			return false, fmt.Errorf("resetting watcher for %s: %v", resource.Path, err)
+		}
+		logger.Info("Kept local file, watcher reset", slog.String("path", resource.Path))
+	default:
+		return false, nil
+	}
+	return true, nil
+}
@@ -4,8 +4,13 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package start
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/alex067/gsync/internal/pkg/gcontext"
 	"github.com/spf13/cobra"
@@ -15,12 +20,47 @@ var (
 	gcf           gcontext.GConfigFile
 	logger        *slog.Logger
 	configContext gcontext.GConfigContext
+	// Bounds the final save and delete, a hung Grafana must not block the exit
+	shutdownTimeout time.Duration
 )
 
 // configCmd represents the config command
 var StartCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Start watching for resource changes on Grafana.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !resume {
+			cmd.Help()
+			return
+		}
+		// Leftover watchers are all dashboards, resume them with the dashboard defaults
+		dashboardCmd.PreRun(dashboardCmd, args)
+		dashboardCmd.Run(dashboardCmd, args)
+	},
+}
+
+// Context of a watch session, cancelled by the first interrupt or term signal
+// Later signals are left to the default handler so a second Ctrl-C exits
+// right away, ex: while the final save hangs
+func watchContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			logger.Info(fmt.Sprintf("Received signal: %v", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Context of the final save and delete once the watch session ended
+func shutdownContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), shutdownTimeout)
 }
 
 func init() {
@@ -30,5 +70,12 @@ func init() {
 	gcf.Directory = ".gsync"
 	gcf.Name = "config.yaml"
 
+	StartCmd.PersistentFlags().DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time allowed to save and remove the watchers once interrupted")
+	StartCmd.PersistentFlags().BoolVar(&resume, "resume", false, "Resume the watchers left behind by interrupted sessions in the current context")
+
 	StartCmd.AddCommand(dashboardCmd)
+	StartCmd.AddCommand(alertRuleCmd)
+	StartCmd.AddCommand(contactPointsCmd)
+	StartCmd.AddCommand(policiesCmd)
+	StartCmd.AddCommand(folderCmd)
 }
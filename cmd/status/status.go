@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/alex067/gsync/internal/pkg/gclient"
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gtable"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	gcf           gcontext.GConfigFile
+	logger        *slog.Logger
+	configContext gcontext.GConfigContext
+	output        string
+)
+
+// StatusCmd represents the status command
+var StatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "List watcher resources across all contexts and their health.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		err := configContext.ReadConfigFile(gcf)
+		if err != nil {
+			logger.Error("Failed to read config file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+
+		// Request errors are logged to stderr to keep the json output clean
+		clientLogger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+		var statuses []*gclient.WatcherStatus
+		eg := errgroup.Group{}
+		for _, context := range configContext.Contexts {
+			gc := gclient.NewGrafanaClient(context, clientLogger)
+			for _, resource := range context.Context.Dashboards.GrafanResources.Resources {
+				status := &gclient.WatcherStatus{}
+				statuses = append(statuses, status)
+				eg.Go(func() error {
+					*status = gc.GetWatcherStatus(ctx, resource)
+					status.Context = context.Name
+					return nil
+				})
+			}
+		}
+		eg.Wait()
+
+		switch output {
+		case "table":
+			printTable(statuses)
+		case "json":
+			content, err := json.MarshalIndent(statuses, "", "  ")
+			if err != nil {
+				logger.Error("Failed to render status", slog.String("error", err.Error()))
+				os.Exit(1)
+			}
+			fmt.Println(string(content))
+		default:
+			logger.Error("Unknown output format, expected table or json", slog.String("output", output))
+			os.Exit(1)
+		}
+	},
+}
+
+func printTable(statuses []*gclient.WatcherStatus) {
+	if len(statuses) == 0 {
+		fmt.Println("No watcher resources recorded")
+		return
+	}
+
+	headers := []string{"CONTEXT", "PATH", "UID", "GRAFANA", "LOCAL", "OWNER", "URL"}
+	var rows [][]string
+	for _, status := range statuses {
+		grafanaState := "missing"
+		if status.Exists {
+			grafanaState = "ok"
+		}
+		if status.Error != "" {
+			grafanaState = "error"
+		}
+
+		localState := "-"
+		if status.Exists && status.Error == "" {
+			localState = "in sync"
+			if len(status.Drift) > 0 {
+				localState = fmt.Sprintf("%d changes", len(status.Drift))
+			}
+		}
+
+		owner := "none"
+		if status.Owner != "" {
+			owner = status.Owner + " (gone)"
+			if status.OwnerAlive {
+				owner = status.Owner + " (running)"
+			}
+		}
+
+		rows = append(rows, []string{status.Context, status.Path, status.Uid, grafanaState, localState, owner, status.Url})
+	}
+
+	gtable.Print(headers, rows)
+
+	for _, status := range statuses {
+		if status.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", status.Path, status.Error)
+		}
+	}
+}
+
+func init() {
+	logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	gcf.Directory = ".gsync"
+	gcf.Name = "config.yaml"
+
+	StatusCmd.Flags().StringVarP(&output, "output", "o", "table", "Output format (table, json)")
+}
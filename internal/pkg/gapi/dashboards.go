@@ -0,0 +1,125 @@
+package gapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Meta data Grafana returns next to a dashboard model
+type DashboardMeta struct {
+	Type        string    `json:"type"`
+	Slug        string    `json:"slug"`
+	Url         string    `json:"url"`
+	Version     int       `json:"version"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	CreatedBy   string    `json:"createdBy"`
+	UpdatedBy   string    `json:"updatedBy"`
+	FolderUid   string    `json:"folderUid"`
+	FolderTitle string    `json:"folderTitle"`
+	FolderUrl   string    `json:"folderUrl"`
+	Provisioned bool      `json:"provisioned"`
+	CanSave     bool      `json:"canSave"`
+}
+
+type Dashboard struct {
+	Meta DashboardMeta `json:"meta"`
+	// Dashboard JSON model
+	Dashboard map[string]interface{} `json:"dashboard"`
+}
+
+type SaveDashboardRequest struct {
+	Dashboard map[string]interface{} `json:"dashboard"`
+	FolderUid string                 `json:"folderUid,omitempty"`
+	Message   string                 `json:"message,omitempty"`
+	// Without overwrite Grafana rejects the save when the version is outdated
+	Overwrite bool `json:"overwrite"`
+}
+
+type SaveDashboardResponse struct {
+	Id      int    `json:"id"`
+	Uid     string `json:"uid"`
+	Url     string `json:"url"`
+	Status  string `json:"status"`
+	Version int    `json:"version"`
+}
+
+// Entry of the version history of a dashboard
+type DashboardVersion struct {
+	Id            int       `json:"id"`
+	DashboardUid  string    `json:"dashboardUid"`
+	ParentVersion int       `json:"parentVersion"`
+	Version       int       `json:"version"`
+	Created       time.Time `json:"created"`
+	CreatedBy     string    `json:"createdBy"`
+	Message       string    `json:"message"`
+}
+
+// Fetches the dashboard and its meta data by uid
+func (c *Client) GetDashboard(ctx context.Context, uid string) (*Dashboard, error) {
+	var dashboard Dashboard
+	if err := c.Do(ctx, "GET", Path("api", "dashboards", "uid", uid), nil, nil, &dashboard); err != nil {
+		return nil, err
+	}
+	return &dashboard, nil
+}
+
+// Creates or updates a dashboard
+func (c *Client) SaveDashboard(ctx context.Context, request SaveDashboardRequest) (*SaveDashboardResponse, error) {
+	var response SaveDashboardResponse
+	if err := c.Do(ctx, "POST", Path("api", "dashboards", "db"), nil, request, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (c *Client) DeleteDashboard(ctx context.Context, uid string) error {
+	return c.Do(ctx, "DELETE", Path("api", "dashboards", "uid", uid), nil, nil, nil)
+}
+
+// Lists the latest versions of a dashboard, newest first
+// Zero limit uses the Grafana default
+func (c *Client) GetDashboardVersions(ctx context.Context, uid string, limit int) ([]DashboardVersion, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var content json.RawMessage
+	if err := c.Do(ctx, "GET", Path("api", "dashboards", "uid", uid, "versions"), query, nil, &content); err != nil {
+		return nil, err
+	}
+
+	// Newer Grafana versions wrap the list to page through it
+	var versions []DashboardVersion
+	if err := json.Unmarshal(content, &versions); err == nil {
+		return versions, nil
+	}
+	var response struct {
+		Versions []DashboardVersion `json:"versions"`
+	}
+	if err := json.Unmarshal(content, &response); err != nil {
+		return nil, fmt.Errorf("decoding dashboard versions: %w", err)
+	}
+	return response.Versions, nil
+}
+
+// Version of a dashboard along with the model saved in that version
+type DashboardVersionModel struct {
+	DashboardVersion
+	Data map[string]interface{} `json:"data"`
+}
+
+// Fetches a single version of a dashboard
+func (c *Client) GetDashboardVersion(ctx context.Context, uid string, version int) (*DashboardVersionModel, error) {
+	var model DashboardVersionModel
+	path := Path("api", "dashboards", "uid", uid, "versions", strconv.Itoa(version))
+	if err := c.Do(ctx, "GET", path, nil, nil, &model); err != nil {
+		return nil, err
+	}
+	return &model, nil
+}
@@ -0,0 +1,64 @@
+package gapi
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+type Folder struct {
+	Uid       string `json:"uid"`
+	Title     string `json:"title"`
+	ParentUid string `json:"parentUid"`
+}
+
+type FolderQuery struct {
+	// Lists the children of the folder, top level folders when empty
+	// Grafana versions without nested folders ignore it
+	ParentUid string
+	// Zero uses the Grafana default
+	Limit int
+	Page  int
+}
+
+type CreateFolderRequest struct {
+	Uid       string `json:"uid,omitempty"`
+	Title     string `json:"title"`
+	ParentUid string `json:"parentUid,omitempty"`
+}
+
+func (c *Client) GetFolders(ctx context.Context, query FolderQuery) ([]Folder, error) {
+	params := url.Values{}
+	if query.ParentUid != "" {
+		params.Set("parentUid", query.ParentUid)
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Page > 0 {
+		params.Set("page", strconv.Itoa(query.Page))
+	}
+
+	var folders []Folder
+	if err := c.Do(ctx, "GET", Path("api", "folders"), params, nil, &folders); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+func (c *Client) GetFolder(ctx context.Context, uid string) (*Folder, error) {
+	var folder Folder
+	if err := c.Do(ctx, "GET", Path("api", "folders", uid), nil, nil, &folder); err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// Creates a folder, nested under the parent when given
+func (c *Client) CreateFolder(ctx context.Context, request CreateFolderRequest) (*Folder, error) {
+	var folder Folder
+	if err := c.Do(ctx, "POST", Path("api", "folders"), nil, request, &folder); err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
@@ -0,0 +1,132 @@
+// Package gapi is a typed client for the Grafana HTTP API
+// Requests and responses are plain structs, dashboard models stay generic
+// maps since gsync round trips every attribute of a dashboard as is
+package gapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Sends requests, ex: *http.Client or a client retrying failed requests
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+type Client struct {
+	// Base url of the Grafana instance, ex: http://localhost:3000
+	Url   string
+	Token string
+	// Organization the requests act on, empty uses the default of the token
+	OrgId string
+	// Nil uses http.DefaultClient
+	Doer Doer
+}
+
+// Error response of the Grafana API
+type APIError struct {
+	StatusCode int
+	// Message reported by Grafana, the raw body when it is not JSON
+	Message string
+	Body    []byte
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("status=%d, message=%s", e.StatusCode, e.Message)
+}
+
+// Reports whether the request failed with the given status
+func IsStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+func IsNotFound(err error) bool {
+	return IsStatus(err, http.StatusNotFound)
+}
+
+// Escapes the segments of an API path, ex: uids holding slashes
+func Path(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// Sends a JSON request to the API path and decodes the response into result
+// Responses outside of 2xx are returned as *APIError, a nil body or result is skipped
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	apiUrl := strings.TrimSuffix(c.Url, "/") + path
+	if len(query) > 0 {
+		apiUrl += "?" + query.Encode()
+	}
+
+	var payload io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiUrl, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.OrgId != "" {
+		req.Header.Set("X-Grafana-Org-Id", c.OrgId)
+	}
+
+	doer := c.Doer
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	resp, err := doer.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(resp.StatusCode, content)
+	}
+
+	if result == nil || len(content) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(content, result); err != nil {
+		return fmt.Errorf("decoding %s %s response: %w", method, path, err)
+	}
+	return nil
+}
+
+func newAPIError(status int, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: status, Body: body}
+	var response struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil && response.Message != "" {
+		apiErr.Message = response.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
@@ -0,0 +1,143 @@
+package gapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &Client{Url: server.URL, Token: "token", OrgId: "2"}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("test dashboard meta without version", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Grafana-Org-Id") != "2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"meta": {"slug": "cpu", "folderUid": "infra"}, "dashboard": {"uid": "cpu"}}`))
+		})
+		dashboard, err := client.GetDashboard(ctx, "cpu")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dashboard.Meta.Version != 0 || dashboard.Meta.FolderUid != "infra" || dashboard.Dashboard["uid"] != "cpu" {
+			t.Fatalf("unexpected dashboard %+v", dashboard)
+		}
+	})
+
+	t.Run("test path segments are escaped", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.EscapedPath() != "/api/dashboards/uid/a%2Fb" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(`{"meta": {"version": 3}, "dashboard": {}}`))
+		})
+		dashboard, err := client.GetDashboard(ctx, "a/b")
+		if err != nil || dashboard.Meta.Version != 3 {
+			t.Fatalf("expected version 3, got %+v, %v", dashboard, err)
+		}
+	})
+
+	t.Run("test api errors carry status and message", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				w.Write([]byte(`{"message": "version-mismatch", "status": "version-mismatch"}`))
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("bad gateway"))
+		})
+
+		_, err := client.SaveDashboard(ctx, SaveDashboardRequest{Dashboard: map[string]interface{}{"uid": "cpu"}})
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusPreconditionFailed || apiErr.Message != "version-mismatch" {
+			t.Fatalf("expected a 412 api error, got %v", err)
+		}
+
+		_, err = client.GetFolder(ctx, "infra")
+		if !IsStatus(err, http.StatusBadGateway) || !errors.As(err, &apiErr) || apiErr.Message != "bad gateway" {
+			t.Fatalf("expected a 502 api error with the raw body, got %v", err)
+		}
+		if IsNotFound(err) {
+			t.Fatal("expected a 502 not to be reported as not found")
+		}
+	})
+
+	t.Run("test search query", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if query.Get("type") != SearchTypeDashboard || len(query["tag"]) != 2 || query.Get("folderUIDs") != "infra" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`[{"uid": "cpu", "title": "CPU", "tags": ["a", "b"]}]`))
+		})
+		results, err := client.Search(ctx, SearchQuery{Type: SearchTypeDashboard, Tags: []string{"a", "b"}, FolderUids: []string{"infra"}})
+		if err != nil || len(results) != 1 || results[0].Uid != "cpu" {
+			t.Fatalf("expected the cpu dashboard, got %v, %v", results, err)
+		}
+	})
+
+	t.Run("test dashboard versions in either shape", func(t *testing.T) {
+		for _, body := range []string{
+			`[{"version": 2}, {"version": 1}]`,
+			`{"versions": [{"version": 2}, {"version": 1}], "continueToken": ""}`,
+		} {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("limit") != "2" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte(body))
+			})
+			versions, err := client.GetDashboardVersions(ctx, "cpu", 2)
+			if err != nil || len(versions) != 2 || versions[0].Version != 2 {
+				t.Fatalf("expected 2 versions from %s, got %v, %v", body, versions, err)
+			}
+		}
+	})
+
+	t.Run("test library element updates are patched", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			var request SaveLibraryElementRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			if r.Method != "PATCH" || r.URL.Path != "/api/library-elements/cpu" || request.Version != 4 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"result": {"uid": "cpu", "kind": 1, "version": 5}}`))
+		})
+		element, err := client.SaveLibraryElement(ctx, SaveLibraryElementRequest{Uid: "cpu", Kind: LibraryPanelKind, Version: 4})
+		if err != nil || element.Version != 5 {
+			t.Fatalf("expected version 5, got %+v, %v", element, err)
+		}
+	})
+
+	t.Run("test cancelled context", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"database": "ok", "version": "11.0.0"}`))
+		})
+		health, err := client.Health(ctx)
+		if err != nil || health.Database != "ok" {
+			t.Fatalf("expected healthy Grafana, got %+v, %v", health, err)
+		}
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := client.Health(cancelled); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context canceled, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package gapi
+
+import "context"
+
+// Health of the Grafana instance, served without authentication
+type Health struct {
+	Database string `json:"database"`
+	Version  string `json:"version"`
+	Commit   string `json:"commit"`
+}
+
+// Checks whether Grafana and its database are up
+// Grafana answers 503 when the database is unreachable
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	if err := c.Do(ctx, "GET", Path("api", "health"), nil, nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
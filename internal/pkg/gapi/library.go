@@ -0,0 +1,104 @@
+package gapi
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// Library elements of kind 1 are library panels
+const LibraryPanelKind = 1
+
+type LibraryElement struct {
+	Uid         string                 `json:"uid"`
+	Name        string                 `json:"name"`
+	Kind        int                    `json:"kind"`
+	Type        string                 `json:"type"`
+	Description string                 `json:"description"`
+	FolderUid   string                 `json:"folderUid"`
+	Model       map[string]interface{} `json:"model"`
+	Version     int                    `json:"version"`
+}
+
+type LibraryElementQuery struct {
+	// Zero returns every kind
+	Kind       int
+	FolderUids []string
+	SearchText string
+	// Zero uses the Grafana default
+	PerPage int
+	Page    int
+}
+
+// Page of library elements, TotalCount counts every page
+type LibraryElementPage struct {
+	TotalCount int              `json:"totalCount"`
+	Page       int              `json:"page"`
+	PerPage    int              `json:"perPage"`
+	Elements   []LibraryElement `json:"elements"`
+}
+
+// Creates a library element, or updates it when Version is set
+type SaveLibraryElementRequest struct {
+	Uid       string                 `json:"uid,omitempty"`
+	Name      string                 `json:"name"`
+	Kind      int                    `json:"kind"`
+	FolderUid string                 `json:"folderUid"`
+	Model     map[string]interface{} `json:"model"`
+	// Version the update applies to, Grafana rejects outdated versions
+	Version int `json:"version,omitempty"`
+}
+
+func (c *Client) GetLibraryElement(ctx context.Context, uid string) (*LibraryElement, error) {
+	var response struct {
+		Result LibraryElement `json:"result"`
+	}
+	if err := c.Do(ctx, "GET", Path("api", "library-elements", uid), nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.Result, nil
+}
+
+// Fetches a single page of library elements
+func (c *Client) SearchLibraryElements(ctx context.Context, query LibraryElementQuery) (*LibraryElementPage, error) {
+	params := url.Values{}
+	if query.Kind != 0 {
+		params.Set("kind", strconv.Itoa(query.Kind))
+	}
+	for _, folderUid := range query.FolderUids {
+		params.Add("folderFilterUIDs", folderUid)
+	}
+	if query.SearchText != "" {
+		params.Set("searchString", query.SearchText)
+	}
+	if query.PerPage > 0 {
+		params.Set("perPage", strconv.Itoa(query.PerPage))
+	}
+	if query.Page > 0 {
+		params.Set("page", strconv.Itoa(query.Page))
+	}
+
+	var response struct {
+		Result LibraryElementPage `json:"result"`
+	}
+	if err := c.Do(ctx, "GET", Path("api", "library-elements"), params, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response.Result, nil
+}
+
+// Creates the library element, or updates it over the version of the request
+func (c *Client) SaveLibraryElement(ctx context.Context, request SaveLibraryElementRequest) (*LibraryElement, error) {
+	method, path := "POST", Path("api", "library-elements")
+	if request.Version != 0 {
+		method, path = "PATCH", Path("api", "library-elements", request.Uid)
+	}
+
+	var response struct {
+		Result LibraryElement `json:"result"`
+	}
+	if err := c.Do(ctx, method, path, nil, request, &response); err != nil {
+		return nil, err
+	}
+	return &response.Result, nil
+}
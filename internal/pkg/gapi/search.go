@@ -0,0 +1,67 @@
+package gapi
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// Search result types
+const (
+	SearchTypeDashboard = "dash-db"
+	SearchTypeFolder    = "dash-folder"
+)
+
+type SearchQuery struct {
+	Query string
+	// dash-db or dash-folder, empty returns both
+	Type          string
+	Tags          []string
+	FolderUids    []string
+	DashboardUids []string
+	// Zero uses the Grafana default
+	Limit int
+	Page  int
+}
+
+type SearchResult struct {
+	Uid         string   `json:"uid"`
+	Title       string   `json:"title"`
+	Type        string   `json:"type"`
+	Url         string   `json:"url"`
+	Tags        []string `json:"tags"`
+	FolderUid   string   `json:"folderUid"`
+	FolderTitle string   `json:"folderTitle"`
+}
+
+// Searches Grafana for dashboards and folders
+func (c *Client) Search(ctx context.Context, query SearchQuery) ([]SearchResult, error) {
+	params := url.Values{}
+	if query.Type != "" {
+		params.Set("type", query.Type)
+	}
+	if query.Query != "" {
+		params.Set("query", query.Query)
+	}
+	for _, tag := range query.Tags {
+		params.Add("tag", tag)
+	}
+	for _, folderUid := range query.FolderUids {
+		params.Add("folderUIDs", folderUid)
+	}
+	for _, uid := range query.DashboardUids {
+		params.Add("dashboardUIDs", uid)
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Page > 0 {
+		params.Set("page", strconv.Itoa(query.Page))
+	}
+
+	var results []SearchResult
+	if err := c.Do(ctx, "GET", Path("api", "search"), params, nil, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,278 @@
+// Package gchaos injects Grafana API failures into HTTP clients
+// Used by the scenario tests and the hidden --chaos flag to check how the
+// watchers behave against failing networks and Grafana instances
+package gchaos
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Failures to inject, rates are probabilities per request between 0 and 1
+type Config struct {
+	// Seeds the fault sequence, zero uses the current time
+	Seed int64
+	// Delays every request by up to this duration
+	Latency time.Duration
+	// Starts a burst of 503 responses
+	ServerErrorRate float64
+	// Requests failing in a row once a burst starts
+	BurstLength int
+	// Rejects the request with 429 and a Retry-After header
+	RateLimitRate float64
+	RetryAfter    time.Duration
+	// Cuts the response body short
+	TruncateRate float64
+	// Resets the connection after Grafana handled the request
+	ResetRate float64
+	// Answers 401 to every request after this many requests, zero disables
+	AuthExpiry int
+}
+
+// Parses a comma separated fault spec
+// ex: latency=200ms,5xx=0.1,burst=3,429=0.05,retry-after=1s,truncate=0.05,reset=0.05,auth-expiry=100,seed=1
+func ParseConfig(spec string) (Config, error) {
+	config := Config{BurstLength: 1, RetryAfter: time.Second}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return config, fmt.Errorf("expected key=value, got %s", field)
+		}
+
+		var err error
+		switch key {
+		case "seed":
+			config.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "latency":
+			config.Latency, err = time.ParseDuration(value)
+		case "5xx":
+			config.ServerErrorRate, err = parseRate(value)
+		case "burst":
+			config.BurstLength, err = strconv.Atoi(value)
+		case "429":
+			config.RateLimitRate, err = parseRate(value)
+		case "retry-after":
+			config.RetryAfter, err = time.ParseDuration(value)
+		case "truncate":
+			config.TruncateRate, err = parseRate(value)
+		case "reset":
+			config.ResetRate, err = parseRate(value)
+		case "auth-expiry":
+			config.AuthExpiry, err = strconv.Atoi(value)
+		default:
+			return config, fmt.Errorf("unknown chaos fault %s", key)
+		}
+		if err != nil {
+			return config, fmt.Errorf("invalid %s value: %w", key, err)
+		}
+	}
+	return config, nil
+}
+
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate must be between 0 and 1, got %s", value)
+	}
+	return rate, nil
+}
+
+// Round tripper injecting the configured failures in front of Base
+type Transport struct {
+	Base   http.RoundTripper
+	Config Config
+	Logger *slog.Logger
+
+	mutex    sync.Mutex
+	random   *rand.Rand
+	requests int
+	burst    int
+	// Faults injected so far by name, ex: 5xx, reset
+	injected map[string]int
+}
+
+// Wraps the base round tripper, nil uses http.DefaultTransport
+func NewTransport(base http.RoundTripper, config Config, logger *slog.Logger) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Transport{
+		Base:     base,
+		Config:   config,
+		Logger:   logger,
+		random:   rand.New(rand.NewSource(seed)),
+		injected: make(map[string]int),
+	}
+}
+
+// Replaces the faults to inject, ex: to refresh an expired token
+func (t *Transport) SetConfig(config Config) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.Config = config
+	t.burst = 0
+}
+
+// Number of injected faults per name
+func (t *Transport) Injected() map[string]int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	injected := make(map[string]int, len(t.injected))
+	for name, count := range t.injected {
+		injected[name] = count
+	}
+	return injected
+}
+
+// Faults picked for a single request
+type plan struct {
+	latency time.Duration
+	status  int
+	// Retry-After of 429 responses
+	retryAfter time.Duration
+	truncate   bool
+	reset      bool
+}
+
+// Picks the faults of the next request, the random source is not safe for
+// concurrent use so the whole plan is drawn under the lock
+func (t *Transport) nextPlan() plan {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var p plan
+	t.requests += 1
+	if t.Config.Latency > 0 {
+		p.latency = time.Duration(t.random.Int63n(int64(t.Config.Latency)))
+	}
+
+	switch {
+	case t.Config.AuthExpiry > 0 && t.requests > t.Config.AuthExpiry:
+		p.status = http.StatusUnauthorized
+	case t.burst > 0:
+		t.burst -= 1
+		p.status = http.StatusServiceUnavailable
+	case t.random.Float64() < t.Config.ServerErrorRate:
+		t.burst = max(t.Config.BurstLength, 1) - 1
+		p.status = http.StatusServiceUnavailable
+	case t.random.Float64() < t.Config.RateLimitRate:
+		p.status = http.StatusTooManyRequests
+		p.retryAfter = t.Config.RetryAfter
+	case t.random.Float64() < t.Config.ResetRate:
+		p.reset = true
+	case t.random.Float64() < t.Config.TruncateRate:
+		p.truncate = true
+	}
+
+	switch {
+	case p.status != 0:
+		t.injected[strconv.Itoa(p.status)] += 1
+	case p.reset:
+		t.injected["reset"] += 1
+	case p.truncate:
+		t.injected["truncate"] += 1
+	}
+	return p
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.nextPlan()
+
+	if p.latency > 0 {
+		select {
+		case <-time.After(p.latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if p.status != 0 {
+		t.log("injecting status", req, slog.Int("status", p.status))
+		return errorResponse(req, p), nil
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.reset {
+		// Grafana handled the request but the response is lost
+		t.log("injecting connection reset", req)
+		resp.Body.Close()
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	if p.truncate {
+		t.log("injecting truncated body", req)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = &truncatedBody{Reader: bytes.NewReader(body[:len(body)/2])}
+	}
+	return resp, nil
+}
+
+func errorResponse(req *http.Request, p plan) *http.Response {
+	status := p.status
+	body := fmt.Sprintf(`{"message":"chaos: %s"}`, http.StatusText(status))
+	header := http.Header{"Content-Type": {"application/json"}}
+	if status == http.StatusTooManyRequests {
+		header.Set("Retry-After", strconv.Itoa(int(p.retryAfter.Seconds())))
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func (t *Transport) log(message string, req *http.Request, attrs ...any) {
+	if t.Logger == nil {
+		return
+	}
+	attrs = append(attrs, slog.String("method", req.Method), slog.String("path", req.URL.Path))
+	t.Logger.Warn("chaos: "+message, attrs...)
+}
+
+// Body ending with an unexpected EOF, as if the connection dropped mid response
+type truncatedBody struct {
+	*bytes.Reader
+}
+
+func (tb *truncatedBody) Read(p []byte) (int, error) {
+	n, err := tb.Reader.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (tb *truncatedBody) Close() error {
+	return nil
+}
@@ -0,0 +1,105 @@
+package gchaos
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig("latency=200ms, 5xx=0.1,burst=3,429=0.05,retry-after=2s,truncate=0.2,reset=0.3,auth-expiry=100,seed=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Config{
+		Seed:            7,
+		Latency:         200 * time.Millisecond,
+		ServerErrorRate: 0.1,
+		BurstLength:     3,
+		RateLimitRate:   0.05,
+		RetryAfter:      2 * time.Second,
+		TruncateRate:    0.2,
+		ResetRate:       0.3,
+		AuthExpiry:      100,
+	}
+	if config != expected {
+		t.Fatalf("expected %+v, got %+v", expected, config)
+	}
+
+	for _, spec := range []string{"5xx=2", "latency", "unknown=1", "burst=many"} {
+		if _, err := ParseConfig(spec); err == nil {
+			t.Fatalf("expected error for %s", spec)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"uid": "abc", "title": "CPU"}`))
+	}))
+	defer server.Close()
+
+	get := func(transport *Transport) (*http.Response, error) {
+		client := &http.Client{Transport: transport}
+		return client.Get(server.URL)
+	}
+
+	t.Run("test 5xx bursts", func(t *testing.T) {
+		transport := NewTransport(nil, Config{Seed: 1, ServerErrorRate: 1, BurstLength: 3}, nil)
+		for i := 0; i < 3; i++ {
+			resp, err := get(transport)
+			if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+				t.Fatalf("expected 503, got %v, %v", resp, err)
+			}
+		}
+		transport.SetConfig(Config{})
+		if resp, err := get(transport); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected burst to end, got %v, %v", resp, err)
+		}
+	})
+
+	t.Run("test rate limits carry retry after", func(t *testing.T) {
+		transport := NewTransport(nil, Config{Seed: 1, RateLimitRate: 1, RetryAfter: 3 * time.Second}, nil)
+		resp, err := get(transport)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "3" {
+			t.Fatalf("expected 429 with Retry-After 3, got %v, %v", resp, err)
+		}
+	})
+
+	t.Run("test truncated bodies", func(t *testing.T) {
+		transport := NewTransport(nil, Config{Seed: 1, TruncateRate: 1}, nil)
+		resp, err := get(transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(resp.Body); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("expected unexpected EOF, got %v", err)
+		}
+	})
+
+	t.Run("test connection resets", func(t *testing.T) {
+		transport := NewTransport(nil, Config{Seed: 1, ResetRate: 1}, nil)
+		if _, err := get(transport); !errors.Is(err, syscall.ECONNRESET) {
+			t.Fatalf("expected connection reset, got %v", err)
+		}
+	})
+
+	t.Run("test auth expiry", func(t *testing.T) {
+		transport := NewTransport(nil, Config{Seed: 1, AuthExpiry: 2}, nil)
+		for i := 0; i < 2; i++ {
+			if resp, err := get(transport); err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200 before expiry, got %v, %v", resp, err)
+			}
+		}
+		if resp, err := get(transport); err != nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401 after expiry, got %v, %v", resp, err)
+		}
+		if injected := transport.Injected(); injected["401"] != 1 {
+			t.Fatalf("expected one injected 401, got %v", injected)
+		}
+	})
+}
@@ -0,0 +1,313 @@
+package gclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+)
+
+type GrafanaContactPointClient struct {
+	FilePath string
+	// Name of the watcher copy, recorded as the resource uid
+	Name         string
+	Integrations []GrafanaContactPoint
+	// Set when the watcher stops, ex: after running out of retries
+	Err   error
+	retry retryBudget
+}
+
+// The notification policy tree is a singleton, it is edited in place and the
+// tree Grafana had before watching is restored from the backup on shutdown
+type GrafanaPolicyClient struct {
+	FilePath   string
+	BackupPath string
+	Tree       map[string]interface{}
+	// Set when the watcher stops, ex: after running out of retries
+	Err   error
+	retry retryBudget
+}
+
+// Creates the watcher copy of a contact point, or reuses the copy recorded in
+// the config if it still exists in Grafana
+// Copies hold real secrets, every placeholder must be set in the environment
+func (gc *GrafanaClient) initWatcherContactPoint(
+	ctx context.Context,
+	configContext gcontext.GConfigContext,
+	cpClient *GrafanaContactPointClient,
+) error {
+	watcherName := configContext.GetKindResourceByPath(gcontext.ContactPointResource, cpClient.FilePath)
+	if watcherName != "" {
+		integrations, err := gc.GetContactPoints(ctx, watcherName)
+		if err != nil {
+			return err
+		}
+		if len(integrations) > 0 {
+			cpClient.Name = watcherName
+			cpClient.Integrations = integrations
+			gc.Logger.Info("Watcher contact point found", slog.String("path", cpClient.FilePath), slog.String("name", watcherName))
+			gc.claimWatcherKind(configContext, gcontext.ContactPointResource, cpClient.FilePath)
+			return nil
+		}
+	}
+
+	local, err := ReadContactPointFile(cpClient.FilePath)
+	if err != nil {
+		return err
+	}
+
+	gc.Logger.Info("Creating watcher contact point...", slog.String("path", cpClient.FilePath))
+	cpClient.Name = fmt.Sprintf("%s (Gsync %s)", local.Name, gc.generateRandomUid())
+	for _, integration := range local.Integrations {
+		integration.Uid = ""
+		integration.Name = cpClient.Name
+		integration.Settings, err = expandSettings(integration.Settings, false)
+		if err == nil {
+			_, err = gc.saveContactPoint(ctx, integration, false)
+		}
+		if err != nil {
+			// Leave no partial copy behind
+			gc.DeleteWatcherContactPoint(ctx, cpClient)
+			return fmt.Errorf("integration %s: %w", integration.Type, err)
+		}
+	}
+
+	configContext.SetNewKindResource(gcontext.ContactPointResource, cpClient.Name, cpClient.FilePath)
+	gc.claimWatcherKind(configContext, gcontext.ContactPointResource, cpClient.FilePath)
+	gc.Logger.Info("Watcher contact point created", slog.String("path", cpClient.FilePath), slog.String("name", cpClient.Name))
+
+	cpClient.Integrations, err = gc.GetContactPoints(ctx, cpClient.Name)
+	return err
+}
+
+// Records this process as the watcher owner, failures are only logged
+func (gc *GrafanaClient) claimWatcherKind(configContext gcontext.GConfigContext, kind gcontext.ResourceKind, filePath string) {
+	if err := configContext.ClaimKindResource(kind, filePath); err != nil {
+		gc.Logger.Warn(
+			"error recording watcher owner",
+			slog.String("path", filePath),
+			slog.String("error", err.Error()))
+	}
+}
+
+// Saves the watcher copy to the local contact point file
+// Copy integrations are paired with local ones of the same type in order, new
+// integrations are written without a uid so the next push creates them
+func (gc *GrafanaClient) SaveContactPointToDisk(cpClient *GrafanaContactPointClient) error {
+	local, err := ReadContactPointFile(cpClient.FilePath)
+	if err != nil {
+		return err
+	}
+
+	localByType := make(map[string][]GrafanaContactPoint)
+	for _, integration := range local.Integrations {
+		localByType[integration.Type] = append(localByType[integration.Type], integration)
+	}
+
+	saved := &ContactPointFile{Name: local.Name, Integrations: []GrafanaContactPoint{}}
+	seenByType := make(map[string]int)
+	for _, integration := range cpClient.Integrations {
+		var paired GrafanaContactPoint
+		if index := seenByType[integration.Type]; index < len(localByType[integration.Type]) {
+			paired = localByType[integration.Type][index]
+		}
+		seenByType[integration.Type] += 1
+
+		integration.Uid = paired.Uid
+		integration.Name = local.Name
+		integration.Provenance = ""
+		integration.Settings = redactSettings(integration.Settings, paired.Settings, local.Name, integration.Type)
+		saved.Integrations = append(saved.Integrations, integration)
+	}
+
+	if reflect.DeepEqual(contactPointModel(local), contactPointModel(saved)) {
+		return nil
+	}
+	return writeResourceFile(cpClient.FilePath, contactPointModel(saved))
+}
+
+// Polls a contact point copy and saves detected changes to disk
+func (gc *GrafanaClient) pollWatcherContactPoint(ctx context.Context, cpClient *GrafanaContactPointClient) {
+	integrations, err := gc.GetContactPoints(ctx, cpClient.Name)
+	if err != nil {
+		cpClient.Err = gc.pollFailed(&cpClient.retry, cpClient.FilePath, err)
+		return
+	}
+	cpClient.retry.reset()
+
+	previous := &ContactPointFile{Name: cpClient.Name, Integrations: cpClient.Integrations}
+	current := &ContactPointFile{Name: cpClient.Name, Integrations: integrations}
+	changes := gdiff.Compare(contactPointModel(previous), contactPointModel(current))
+	if len(changes) == 0 {
+		return
+	}
+
+	gc.Logger.Info("Contact point change detected, saving changes...", slog.String("path", cpClient.FilePath))
+	for _, change := range changes {
+		gc.Logger.Info(change.Summary, slog.String("path", cpClient.FilePath), slog.String("change", string(change.Type)))
+	}
+	cpClient.Integrations = integrations
+	if err := gc.SaveContactPointToDisk(cpClient); err != nil {
+		gc.Logger.Error(err.Error(), slog.String("path", cpClient.FilePath))
+	}
+}
+
+// Watches contact point copies on a shared ticker until ctx ends
+func (gc *GrafanaClient) StartWatchingContactPoints(
+	ctx context.Context,
+	configContext gcontext.GConfigContext,
+	cpClients []*GrafanaContactPointClient,
+) error {
+	// Watchers are created one at a time since each one writes to the config file
+	for _, cpClient := range cpClients {
+		if err := gc.initWatcherContactPoint(ctx, configContext, cpClient); err != nil {
+			return err
+		}
+	}
+
+	polls := make([]func() error, len(cpClients))
+	for i, cpClient := range cpClients {
+		polls[i] = func() error {
+			gc.pollWatcherContactPoint(ctx, cpClient)
+			return cpClient.Err
+		}
+	}
+	gc.Logger.Info("Watching...", slog.Int("contactPoints", len(cpClients)))
+	return gc.runWatchLoop(ctx, polls)
+}
+
+// Removes every integration of the contact point copy
+func (gc *GrafanaClient) DeleteWatcherContactPoint(ctx context.Context, cpClient *GrafanaContactPointClient) error {
+	integrations, err := gc.GetContactPoints(ctx, cpClient.Name)
+	if err != nil {
+		return err
+	}
+	for _, integration := range integrations {
+		if err := gc.deleteContactPoint(ctx, integration.Uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backs up the live notification policy tree and replaces it with the local
+// file. A backup left by an interrupted session is reused, the live tree then
+// holds that session's edits and is saved to the local file instead
+func (gc *GrafanaClient) initWatcherPolicy(
+	ctx context.Context,
+	configContext gcontext.GConfigContext,
+	pClient *GrafanaPolicyClient,
+) error {
+	resource, ok := configContext.GetKindResource(gcontext.PolicyResource, pClient.FilePath)
+	if ok && resource.Backup != "" {
+		if resource.IsOwnerAlive() {
+			return fmt.Errorf("notification policy already watched by process %d on %s", resource.Pid, resource.Host)
+		}
+		if _, err := os.Stat(resource.Backup); err == nil {
+			pClient.BackupPath = resource.Backup
+			gc.Logger.Warn(
+				"Notification policy was not restored by the last session, reusing its backup",
+				slog.String("backup", pClient.BackupPath))
+			gc.claimWatcherKind(configContext, gcontext.PolicyResource, pClient.FilePath)
+
+			tree, err := gc.GetNotificationPolicy(ctx)
+			if err != nil {
+				return err
+			}
+			pClient.Tree = tree
+			return gc.SavePolicyToDisk(pClient)
+		}
+	}
+
+	local, err := ReadPolicyFile(pClient.FilePath)
+	if err != nil {
+		return err
+	}
+	live, err := gc.GetNotificationPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	if err := writeResourceFile(pClient.BackupPath, live); err != nil {
+		return err
+	}
+
+	configContext.SetNewKindResource(gcontext.PolicyResource, "policies", pClient.FilePath)
+	if err := configContext.SetKindResourceBackup(gcontext.PolicyResource, pClient.FilePath, pClient.BackupPath); err != nil {
+		gc.Logger.Warn("error recording policy backup", slog.String("error", err.Error()))
+	}
+	gc.claimWatcherKind(configContext, gcontext.PolicyResource, pClient.FilePath)
+	gc.Logger.Info("Notification policy backed up", slog.String("backup", pClient.BackupPath))
+
+	if err := gc.putNotificationPolicy(ctx, local); err != nil {
+		return err
+	}
+	pClient.Tree, err = gc.GetNotificationPolicy(ctx)
+	return err
+}
+
+// Saves the live notification policy tree to the local file
+func (gc *GrafanaClient) SavePolicyToDisk(pClient *GrafanaPolicyClient) error {
+	local, err := ReadPolicyFile(pClient.FilePath)
+	if err == nil && reflect.DeepEqual(local, pClient.Tree) {
+		return nil
+	}
+	return writeResourceFile(pClient.FilePath, pClient.Tree)
+}
+
+// Polls the live notification policy tree and saves detected changes to disk
+func (gc *GrafanaClient) pollWatcherPolicy(ctx context.Context, pClient *GrafanaPolicyClient) {
+	tree, err := gc.GetNotificationPolicy(ctx)
+	if err != nil {
+		pClient.Err = gc.pollFailed(&pClient.retry, pClient.FilePath, err)
+		return
+	}
+	pClient.retry.reset()
+
+	changes := gdiff.Compare(pClient.Tree, tree)
+	if len(changes) == 0 {
+		return
+	}
+
+	gc.Logger.Info("Notification policy change detected, saving changes...", slog.String("path", pClient.FilePath))
+	for _, change := range changes {
+		gc.Logger.Info(change.Summary, slog.String("path", pClient.FilePath), slog.String("change", string(change.Type)))
+	}
+	pClient.Tree = tree
+	if err := gc.SavePolicyToDisk(pClient); err != nil {
+		gc.Logger.Error(err.Error(), slog.String("path", pClient.FilePath))
+	}
+}
+
+// Watches the notification policy tree in place
+func (gc *GrafanaClient) StartWatchingPolicy(
+	ctx context.Context,
+	configContext gcontext.GConfigContext,
+	pClient *GrafanaPolicyClient,
+) error {
+	if err := gc.initWatcherPolicy(ctx, configContext, pClient); err != nil {
+		return err
+	}
+
+	gc.Logger.Info("Watching...", slog.String("policy", pClient.FilePath))
+	return gc.runWatchLoop(ctx, []func() error{func() error {
+		gc.pollWatcherPolicy(ctx, pClient)
+		return pClient.Err
+	}})
+}
+
+// Puts the notification policy tree from before watching back in Grafana
+// The backup is removed once restored
+func (gc *GrafanaClient) RestorePolicyBackup(ctx context.Context, pClient *GrafanaPolicyClient) error {
+	backup, err := ReadPolicyFile(pClient.BackupPath)
+	if err != nil {
+		return err
+	}
+	if err := gc.putNotificationPolicy(ctx, backup); err != nil {
+		return err
+	}
+	return os.Remove(pClient.BackupPath)
+}
@@ -0,0 +1,370 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrAlertRuleNotFound = fmt.Errorf("alert rule not found")
+
+// Rule group holding the alert rule watchers, kept apart from real groups so
+// their evaluation interval is never changed
+const watcherRuleGroup = "gsync-watchers"
+
+// Attributes owned by the local rule file, the watcher copy overrides them
+var localAlertRuleAttributes = []string{"id", "uid", "orgID", "title", "folderUID", "ruleGroup", "isPaused", "updated", "provenance"}
+
+type GrafanaAlertRuleClient struct {
+	Mutex    sync.Mutex
+	FilePath string
+	// Folder for the watcher copy, the rule file folder when empty
+	FolderUid   string
+	Uid         string
+	Rule        map[string]interface{}
+	LastUpdated string
+	IsChanged   bool
+	Changes     []gdiff.Change
+	// Set when the watcher stops, ex: after running out of retries
+	Err   error
+	retry retryBudget
+}
+
+// Reads a single alert rule from a YAML or JSON file
+func ReadAlertRuleFile(filePath string) (map[string]interface{}, error) {
+	var rule map[string]interface{}
+	if err := readResourceFile(filePath, &rule); err != nil {
+		return nil, fmt.Errorf("alert rule file must hold a single rule: %v", err)
+	}
+	return rule, nil
+}
+
+// Reads a YAML or JSON resource file by its extension
+// YAML is normalized to the JSON types Grafana returns
+func readResourceFile(filePath string, value interface{}) error {
+	resourceFileData, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	if isYamlFile(filePath) {
+		var yamlValue interface{}
+		if err := yaml.Unmarshal(resourceFileData, &yamlValue); err != nil {
+			return err
+		}
+		resourceFileData, err = json.Marshal(yamlValue)
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(resourceFileData, value)
+}
+
+// Writes the resource in the format of the file extension, keeping JSON field names
+func writeResourceFile(filePath string, value interface{}) error {
+	resourceFileData, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if isYamlFile(filePath) {
+		var yamlValue interface{}
+		if err := json.Unmarshal(resourceFileData, &yamlValue); err != nil {
+			return err
+		}
+		resourceFileData, err = yaml.Marshal(yamlValue)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, resourceFileData, 0644)
+}
+
+func isYamlFile(filePath string) bool {
+	extension := filepath.Ext(filePath)
+	return extension == ".yaml" || extension == ".yml"
+}
+
+// Copies the watcher rule with the attributes owned by the local file
+func restoreLocalRuleAttributes(local, remote map[string]interface{}) map[string]interface{} {
+	remote = copyDashboard(remote)
+	for _, key := range localAlertRuleAttributes {
+		if value, ok := local[key]; ok {
+			remote[key] = value
+		} else {
+			delete(remote, key)
+		}
+	}
+	return remote
+}
+
+// Fetches an alert rule through the provisioning API
+func (gc *GrafanaClient) GetAlertRule(ctx context.Context, uid string) (map[string]interface{}, error) {
+	apiUrl := gc.endpoint("api", "v1", "provisioning", "alert-rules", uid)
+
+	resp, err := gc.createRequest(ctx, apiUrl, "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrAlertRuleNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		gc.Logger.Error(
+			"error fetching alert rule",
+			slog.Int("status", resp.StatusCode),
+			slog.String("error", string(body)),
+		)
+		return nil, statusError(resp.StatusCode, body)
+	}
+
+	return unmarshalDashboard(body)
+}
+
+// Creates an alert rule that stays editable in the Grafana UI
+func (gc *GrafanaClient) createAlertRule(ctx context.Context, rule map[string]interface{}) error {
+	apiUrl := gc.endpoint("api", "v1", "provisioning", "alert-rules")
+	payload, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	// Provisioned rules are locked in the UI unless provenance is disabled
+	resp, err := gc.createRequestWithHeaders(ctx, apiUrl, "POST", payload, map[string]string{"X-Disable-Provenance": "true"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status=%d, body=%s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// Creates the paused watcher copy of a local alert rule
+func (gc *GrafanaClient) generateTempAlertRule(ctx context.Context, ruleFilePath, folderUid string) (string, error) {
+	rule, err := ReadAlertRuleFile(ruleFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	newUid := gc.generateRandomUid()
+	rule["uid"] = newUid
+	rule["title"] = fmt.Sprintf("%s (Gsync %s)", rule["title"], newUid)
+	rule["ruleGroup"] = watcherRuleGroup
+	// Watchers must never notify anyone
+	rule["isPaused"] = true
+	if folderUid != "" {
+		rule["folderUID"] = folderUid
+	}
+	delete(rule, "id")
+	delete(rule, "updated")
+	delete(rule, "provenance")
+
+	if err := gc.createAlertRule(ctx, rule); err != nil {
+		return "", err
+	}
+	return newUid, nil
+}
+
+// Creates the watcher copy for the given rule file, or reuses the watcher
+// recorded in the config if it still exists in Grafana
+func (gc *GrafanaClient) initWatcherAlertRule(
+	ctx context.Context,
+	configContext gcontext.GConfigContext,
+	arClient *GrafanaAlertRuleClient,
+) error {
+	watcherUid := configContext.GetKindResourceByPath(gcontext.AlertRuleResource, arClient.FilePath)
+	if watcherUid != "" {
+		_, err := gc.GetAlertRule(ctx, watcherUid)
+		if err == nil {
+			arClient.Uid = watcherUid
+			gc.Logger.Info(
+				"Watcher alert rule found",
+				slog.String("path", arClient.FilePath),
+				slog.String("url", fmt.Sprintf("%s/alerting/grafana/%s/view", gc.Url, watcherUid)))
+			gc.claimWatcherAlertRule(configContext, arClient)
+			return nil
+		} else if err != ErrAlertRuleNotFound {
+			gc.Logger.Error(
+				"error checking for existing alert rule",
+				slog.String("uid", watcherUid),
+				slog.String("path", arClient.FilePath),
+				slog.String("error", err.Error()))
+			return err
+		}
+		gc.Logger.Info("Error fetching watcher alert rule from Grafana", slog.String("path", arClient.FilePath))
+	}
+
+	gc.Logger.Info("Creating watcher alert rule...", slog.String("path", arClient.FilePath))
+	watcherUid, err := gc.generateTempAlertRule(ctx, arClient.FilePath, arClient.FolderUid)
+	if err != nil {
+		gc.Logger.Error(
+			"error creating alert rule",
+			slog.String("path", arClient.FilePath),
+			slog.String("error", err.Error()))
+		return err
+	}
+	// Record new alert rule UID in local config file
+	configContext.SetNewKindResource(gcontext.AlertRuleResource, watcherUid, arClient.FilePath)
+	arClient.Uid = watcherUid
+	gc.claimWatcherAlertRule(configContext, arClient)
+	gc.Logger.Info(
+		"Watcher alert rule created",
+		slog.String("path", arClient.FilePath),
+		slog.String("url", fmt.Sprintf("%s/alerting/grafana/%s/view", gc.Url, watcherUid)))
+	return nil
+}
+
+// Records this process as the watcher owner, failures are only logged
+func (gc *GrafanaClient) claimWatcherAlertRule(configContext gcontext.GConfigContext, arClient *GrafanaAlertRuleClient) {
+	if err := configContext.ClaimKindResource(gcontext.AlertRuleResource, arClient.FilePath); err != nil {
+		gc.Logger.Warn(
+			"error recording watcher owner",
+			slog.String("path", arClient.FilePath),
+			slog.String("error", err.Error()))
+	}
+}
+
+// Fetches the watcher copy and compares its updated timestamp
+func (gc *GrafanaClient) GetAlertRuleChanges(ctx context.Context, arClient *GrafanaAlertRuleClient) error {
+	rule, err := gc.GetAlertRule(ctx, arClient.Uid)
+	if err != nil {
+		// Watchers can briefly go missing while Grafana restarts
+		if err == ErrAlertRuleNotFound {
+			return retryable(err)
+		}
+		return err
+	}
+
+	updated, _ := rule["updated"].(string)
+
+	arClient.Mutex.Lock()
+	defer arClient.Mutex.Unlock()
+	previous := arClient.Rule
+	arClient.Rule = rule
+	if arClient.LastUpdated != "" {
+		arClient.IsChanged = arClient.LastUpdated != updated
+	}
+	arClient.LastUpdated = updated
+
+	if arClient.IsChanged && previous != nil {
+		arClient.Changes = gdiff.Compare(previous, restoreLocalRuleAttributes(previous, rule))
+	}
+	return nil
+}
+
+// Saves the current state of the watcher copy to the local rule file
+func (gc *GrafanaClient) SaveAlertRuleToDisk(arClient *GrafanaAlertRuleClient) error {
+	localRule, err := ReadAlertRuleFile(arClient.FilePath)
+	if err != nil {
+		return err
+	}
+
+	fileRule := restoreLocalRuleAttributes(localRule, arClient.Rule)
+	if reflect.DeepEqual(fileRule, localRule) {
+		arClient.IsChanged = false
+		return nil
+	}
+
+	if err := writeResourceFile(arClient.FilePath, fileRule); err != nil {
+		return err
+	}
+	arClient.IsChanged = false
+	return nil
+}
+
+// Polls a single alert rule watcher and saves detected changes to disk
+func (gc *GrafanaClient) pollWatcherAlertRule(ctx context.Context, arClient *GrafanaAlertRuleClient) {
+	if err := gc.GetAlertRuleChanges(ctx, arClient); err != nil {
+		arClient.Err = gc.pollFailed(&arClient.retry, arClient.FilePath, err)
+		return
+	}
+	arClient.retry.reset()
+	if arClient.IsChanged {
+		gc.Logger.Info("Alert rule change detected, saving changes...", slog.String("path", arClient.FilePath))
+		for _, change := range arClient.Changes {
+			gc.Logger.Info(change.Summary, slog.String("path", arClient.FilePath), slog.String("change", string(change.Type)))
+		}
+		arClient.Changes = nil
+		if err := gc.SaveAlertRuleToDisk(arClient); err != nil {
+			gc.Logger.Error(err.Error(), slog.String("path", arClient.FilePath))
+		}
+	}
+}
+
+// Watches alert rule copies on a shared ticker until ctx ends
+// A failing watcher is dropped while the remaining watchers keep running
+func (gc *GrafanaClient) StartWatchingAlertRules(
+	ctx context.Context,
+	configContext gcontext.GConfigContext,
+	arClients []*GrafanaAlertRuleClient,
+) error {
+	// Watchers are created one at a time since each one writes to the config file
+	for _, arClient := range arClients {
+		if err := gc.initWatcherAlertRule(ctx, configContext, arClient); err != nil {
+			return err
+		}
+	}
+
+	polls := make([]func() error, len(arClients))
+	for i, arClient := range arClients {
+		polls[i] = func() error {
+			gc.pollWatcherAlertRule(ctx, arClient)
+			return arClient.Err
+		}
+	}
+	gc.Logger.Info("Watching...", slog.Int("alertRules", len(arClients)))
+	return gc.runWatchLoop(ctx, polls)
+}
+
+func (gc *GrafanaClient) DeleteWatcherAlertRule(ctx context.Context, arClient *GrafanaAlertRuleClient) error {
+	apiUrl := gc.endpoint("api", "v1", "provisioning", "alert-rules", arClient.Uid)
+	resp, err := gc.createRequestWithHeaders(ctx, apiUrl, "DELETE", nil, map[string]string{"X-Disable-Provenance": "true"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrAlertRuleNotFound
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", string(body))
+	}
+	return nil
+}
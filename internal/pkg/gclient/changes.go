@@ -0,0 +1,307 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Polls between events still run this many intervals apart, so a missed
+// event is picked up eventually
+const resyncIntervals = 10
+
+// Tells the watch loop which watchers may have changed
+type ChangeEvent struct {
+	// Uid of the changed dashboard, empty when every watcher should be polled
+	Uid string
+}
+
+// Backend the watch loop learns about dashboard changes from
+// Events only trigger a poll, the poll still decides whether the version changed
+type ChangeSource interface {
+	Name() string
+	// Starts listening for changes of the given dashboards
+	// The channel is closed once the source stops, ex: connection lost
+	Start(ctx context.Context, uids []string) (<-chan ChangeEvent, error)
+}
+
+// Change sources in order of preference, the ticker always starts
+func (gc *GrafanaClient) changeSources() []ChangeSource {
+	if gc.ChangeSources != nil {
+		return gc.ChangeSources
+	}
+	return []ChangeSource{
+		&resourceWatchSource{gc: gc},
+		&liveSource{gc: gc},
+		&tickerSource{interval: gc.Interval},
+	}
+}
+
+// Starts the first change source that is available
+func (gc *GrafanaClient) startChangeSource(ctx context.Context, uids []string) (<-chan ChangeEvent, string, error) {
+	for _, source := range gc.changeSources() {
+		events, err := source.Start(ctx, uids)
+		if err != nil {
+			gc.Logger.Debug(
+				"change source not available",
+				slog.String("source", source.Name()),
+				slog.String("error", err.Error()))
+			continue
+		}
+		gc.Logger.Info("Listening for dashboard changes", slog.String("source", source.Name()))
+		return events, source.Name(), nil
+	}
+	return nil, "", fmt.Errorf("no change source available")
+}
+
+// Polls every watcher at a fixed interval, available everywhere
+type tickerSource struct {
+	interval time.Duration
+}
+
+func (ts *tickerSource) Name() string {
+	return "poll"
+}
+
+func (ts *tickerSource) Start(ctx context.Context, uids []string) (<-chan ChangeEvent, error) {
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(ts.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case events <- ChangeEvent{}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Streams changes from the watch endpoint of the dashboard resource API
+type resourceWatchSource struct {
+	gc *GrafanaClient
+}
+
+func (rs *resourceWatchSource) Name() string {
+	return "resource-watch"
+}
+
+// Event of a Kubernetes style watch stream
+type resourceWatchEvent struct {
+	Type   string            `json:"type"`
+	Object DashboardResource `json:"object"`
+}
+
+func (rs *resourceWatchSource) Start(ctx context.Context, uids []string) (<-chan ChangeEvent, error) {
+	api := rs.gc.resourceApi(ctx)
+	if api == nil {
+		return nil, fmt.Errorf("dashboard resource API not served")
+	}
+
+	query := url.Values{"watch": {"true"}}
+	// Field selectors only match a single name
+	if len(uids) == 1 {
+		query.Set("fieldSelector", fmt.Sprintf("metadata.name=%s", uids[0]))
+	}
+	apiUrl := fmt.Sprintf("%s%s?%s", rs.gc.Url, api.path(DashboardV1, ""), query.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", apiUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	rs.gc.setRequestHeaders(req)
+
+	// The stream stays open, only the context ends it
+	streamClient := *rs.gc.HttpClient
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var event resourceWatchEvent
+			if err := decoder.Decode(&event); err != nil {
+				if ctx.Err() == nil {
+					rs.gc.Logger.Debug("dashboard watch stream closed", slog.String("error", err.Error()))
+				}
+				return
+			}
+			if event.Type == "BOOKMARK" || event.Type == "ERROR" || !slices.Contains(uids, event.Object.Metadata.Name) {
+				continue
+			}
+			select {
+			case events <- ChangeEvent{Uid: event.Object.Metadata.Name}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Receives dashboard save events from the Grafana Live websocket
+type liveSource struct {
+	gc *GrafanaClient
+}
+
+func (ls *liveSource) Name() string {
+	return "live"
+}
+
+// Grafana Live publishes dashboard events on one channel per dashboard
+const liveDashboardChannel = "grafana/dashboard/uid/"
+
+const liveConnectTimeout = 10 * time.Second
+
+// Centrifuge protocol message, replies carry the id of the command
+type liveMessage struct {
+	Id    int `json:"id,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	Push *struct {
+		Channel string `json:"channel"`
+	} `json:"push,omitempty"`
+}
+
+func (ls *liveSource) Start(ctx context.Context, uids []string) (<-chan ChangeEvent, error) {
+	wsUrl := strings.Replace(ls.gc.Url, "http", "ws", 1) + "/api/live/ws"
+	config, err := websocket.NewConfig(wsUrl, ls.gc.Url)
+	if err != nil {
+		return nil, err
+	}
+	config.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ls.gc.ApiKey))
+	config.Header.Set("X-Grafana-Org-Id", ls.gc.TenantId)
+	config.Dialer = &net.Dialer{Timeout: liveConnectTimeout}
+
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connect first, then subscribe to the channel of every dashboard
+	commands := []map[string]interface{}{{"id": 1, "connect": map[string]interface{}{}}}
+	for i, uid := range uids {
+		commands = append(commands, map[string]interface{}{
+			"id":        i + 2,
+			"subscribe": map[string]interface{}{"channel": liveDashboardChannel + uid},
+		})
+	}
+	for _, command := range commands {
+		if err := websocket.JSON.Send(conn, command); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// Every command is answered before events are trusted
+	conn.SetReadDeadline(time.Now().Add(liveConnectTimeout))
+	for replies := 0; replies < len(commands); {
+		messages, err := receiveLiveMessages(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		for _, message := range messages {
+			if message.Id == 0 {
+				continue
+			}
+			if message.Error != nil {
+				conn.Close()
+				return nil, fmt.Errorf("live command %d failed: %s", message.Id, message.Error.Message)
+			}
+			replies += 1
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	events := make(chan ChangeEvent)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	go func() {
+		defer close(events)
+		defer close(done)
+		defer conn.Close()
+		for {
+			messages, err := receiveLiveMessages(conn)
+			if err != nil {
+				if ctx.Err() == nil {
+					ls.gc.Logger.Debug("live connection closed", slog.String("error", err.Error()))
+				}
+				return
+			}
+			for _, message := range messages {
+				// Empty messages are pings, the server drops clients that do not answer
+				if message.Id == 0 && message.Push == nil && message.Error == nil {
+					websocket.Message.Send(conn, "{}")
+					continue
+				}
+				if message.Push == nil || !strings.HasPrefix(message.Push.Channel, liveDashboardChannel) {
+					continue
+				}
+				select {
+				case events <- ChangeEvent{Uid: strings.TrimPrefix(message.Push.Channel, liveDashboardChannel)}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Reads one frame, Centrifuge batches several newline separated messages per frame
+func receiveLiveMessages(conn *websocket.Conn) ([]liveMessage, error) {
+	var frame string
+	if err := websocket.Message.Receive(conn, &frame); err != nil {
+		return nil, err
+	}
+	var messages []liveMessage
+	for _, line := range strings.Split(frame, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var message liveMessage
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
@@ -0,0 +1,133 @@
+package gclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gmerge"
+)
+
+var ErrDashboardConflict = fmt.Errorf("local dashboard file and Grafana both changed")
+
+type ConflictResolution string
+
+const (
+	KeepLocal  ConflictResolution = "local"
+	KeepRemote ConflictResolution = "remote"
+	MergeBoth  ConflictResolution = "merge"
+)
+
+// Attributes owned by the local file, never taken from the watcher
+var localDashboardAttributes = []string{"id", "uid", "title", "version", "description"}
+
+// Records the file content gsync last synced
+// The content serves as the merge base when both sides change
+func (gcd *GrafanaDashboardClient) recordSync(content []byte) {
+	gcd.localHash = hashContent(content)
+	gcd.baseContent = content
+}
+
+// Writes the syncs made since the last call to the config, one at a time since
+// each one writes the config file
+// Failures are logged and not retried, a stale record only makes later
+// commands more careful with the local file
+func (gc *GrafanaClient) recordWatcherSyncs(configContext gcontext.GConfigContext, dbClients []*GrafanaDashboardClient) {
+	for _, dbClient := range dbClients {
+		if dbClient.Uid == "" || dbClient.LastVersion == 0 {
+			continue
+		}
+		if dbClient.localHash == dbClient.recordedHash && dbClient.LastVersion == dbClient.recordedVersion {
+			continue
+		}
+		dbClient.recordedHash = dbClient.localHash
+		dbClient.recordedVersion = dbClient.LastVersion
+		if err := configContext.SetResourceSynced(dbClient.FilePath, dbClient.localHash, dbClient.LastVersion); err != nil {
+			gc.Logger.Warn(
+				"error recording watcher sync",
+				slog.String("path", dbClient.FilePath),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// Reports whether the local file changed since gsync last synced it
+func (gcd *GrafanaDashboardClient) isLocalChanged(content []byte) bool {
+	return gcd.localHash != "" && hashContent(content) != gcd.localHash
+}
+
+// Asks the configured resolver how to settle a conflict
+// Without a resolver the watcher stops so neither side is lost
+func (gc *GrafanaClient) resolveConflict(dbClient *GrafanaDashboardClient) (ConflictResolution, error) {
+	gc.Logger.Warn(
+		"Local dashboard file changed while Grafana has a new version",
+		slog.String("path", dbClient.FilePath),
+		slog.Int("version", dbClient.LastVersion))
+
+	if gc.ConflictResolver == nil {
+		return "", ErrDashboardConflict
+	}
+
+	// Only prompt for one conflict at a time
+	gc.conflictMutex.Lock()
+	defer gc.conflictMutex.Unlock()
+	return gc.ConflictResolver(dbClient.FilePath)
+}
+
+// Copies the watcher dashboard model with the attributes owned by the local file
+// Watcher attributes always differ from the file, compare against the file values instead
+func RestoreLocalAttributes(local, remote map[string]interface{}) map[string]interface{} {
+	remote = copyDashboard(remote)
+	stripWatcherMarker(remote, local)
+	for _, key := range localDashboardAttributes {
+		if value, ok := local[key]; ok {
+			remote[key] = value
+		} else {
+			delete(remote, key)
+		}
+	}
+	return remote
+}
+
+// Three-way merge of the dashboard models
+func mergeDashboards(base, local, remote map[string]interface{}) *gmerge.Result {
+	return gmerge.Merge(base, local, RestoreLocalAttributes(local, remote))
+}
+
+func copyDashboard(dashboard map[string]interface{}) map[string]interface{} {
+	dashboardCopy := make(map[string]interface{}, len(dashboard))
+	for key, value := range dashboard {
+		dashboardCopy[key] = value
+	}
+	return dashboardCopy
+}
+
+func unmarshalDashboard(content []byte) (map[string]interface{}, error) {
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal(content, &dashboard); err != nil {
+		return nil, err
+	}
+	return dashboard, nil
+}
+
+// Writes Git style conflict regions into the dashboard file and stops the watcher
+// The file holds both sides until the conflicts are resolved by hand
+func (gc *GrafanaClient) writeMergeConflicts(dbClient *GrafanaDashboardClient, result *gmerge.Result) error {
+	content, err := result.MarshalWithConflicts("local", "grafana")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dbClient.FilePath, content, 0644); err != nil {
+		return err
+	}
+	dbClient.recordSync(content)
+
+	gc.Logger.Error(
+		"Merge conflicts written to dashboard file, resolve them and restart the watcher",
+		slog.String("path", dbClient.FilePath),
+		slog.Int("conflicts", len(result.Conflicts)))
+	fmt.Fprint(os.Stderr, result.Report())
+	return fmt.Errorf("%w: %d merge conflicts in %s", ErrDashboardConflict, len(result.Conflicts), dbClient.FilePath)
+}
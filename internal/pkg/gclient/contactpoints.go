@@ -0,0 +1,379 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+)
+
+// Contact point integration as returned by the provisioning API
+// Integrations sharing a name make up one contact point
+type GrafanaContactPoint struct {
+	Uid                   string                 `json:"uid,omitempty"`
+	Name                  string                 `json:"name"`
+	Type                  string                 `json:"type"`
+	Settings              map[string]interface{} `json:"settings"`
+	DisableResolveMessage bool                   `json:"disableResolveMessage"`
+	Provenance            string                 `json:"provenance,omitempty"`
+}
+
+// Local contact point file holding every integration of the contact point
+type ContactPointFile struct {
+	Name         string                `json:"name"`
+	Integrations []GrafanaContactPoint `json:"integrations"`
+}
+
+// Result of syncing a contact point or notification policy file
+type AlertingResult struct {
+	Name     string
+	FilePath string
+	// created, updated, unchanged or missing
+	Status  string
+	Changes []gdiff.Change
+	Err     error
+}
+
+// Provisioned resources are locked in the UI unless provenance is disabled
+var disableProvenance = map[string]string{"X-Disable-Provenance": "true"}
+
+// File path of a contact point under the alerting path
+func ContactPointFilePath(alertingPath, name string) string {
+	return filepath.Join(alertingPath, "contact-points", Slugify(name)+".yaml")
+}
+
+// Reads a local contact point file
+func ReadContactPointFile(filePath string) (*ContactPointFile, error) {
+	var contactPoint ContactPointFile
+	if err := readResourceFile(filePath, &contactPoint); err != nil {
+		return nil, err
+	}
+	if contactPoint.Name == "" {
+		return nil, fmt.Errorf("contact point name attribute not found in %s", filePath)
+	}
+	for i := range contactPoint.Integrations {
+		contactPoint.Integrations[i].Name = contactPoint.Name
+	}
+	return &contactPoint, nil
+}
+
+// Lists contact point integrations, filtered by name when given
+func (gc *GrafanaClient) GetContactPoints(ctx context.Context, name string) ([]GrafanaContactPoint, error) {
+	apiUrl := gc.endpoint("api", "v1", "provisioning", "contact-points")
+	if name != "" {
+		apiUrl = fmt.Sprintf("%s?%s", apiUrl, url.Values{"name": {name}}.Encode())
+	}
+
+	resp, err := gc.createRequest(ctx, apiUrl, "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	var contactPoints []GrafanaContactPoint
+	if err := json.Unmarshal(body, &contactPoints); err != nil {
+		return nil, err
+	}
+	return contactPoints, nil
+}
+
+// Creates or updates a contact point integration, returning the saved integration
+func (gc *GrafanaClient) saveContactPoint(ctx context.Context, contactPoint GrafanaContactPoint, isUpdate bool) (*GrafanaContactPoint, error) {
+	apiUrl := gc.endpoint("api", "v1", "provisioning", "contact-points")
+	method := "POST"
+	if isUpdate {
+		apiUrl = gc.endpoint("api", "v1", "provisioning", "contact-points", contactPoint.Uid)
+		method = "PUT"
+	}
+	contactPoint.Provenance = ""
+
+	payload, err := json.Marshal(contactPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := gc.createRequestWithHeaders(ctx, apiUrl, method, payload, disableProvenance)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status=%d, body=%s", resp.StatusCode, string(body))
+	}
+
+	// Updates answer with a message instead of the integration
+	if isUpdate {
+		return &contactPoint, nil
+	}
+	var saved GrafanaContactPoint
+	if err := json.Unmarshal(body, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func (gc *GrafanaClient) deleteContactPoint(ctx context.Context, uid string) error {
+	apiUrl := gc.endpoint("api", "v1", "provisioning", "contact-points", uid)
+	resp, err := gc.createRequestWithHeaders(ctx, apiUrl, "DELETE", nil, disableProvenance)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status=%d, body=%s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// Converts Grafana integrations into the local file format
+// Redacted secrets become environment placeholders, reusing the local ones
+func toContactPointFile(name string, remote []GrafanaContactPoint, local *ContactPointFile) *ContactPointFile {
+	localIntegrations := make(map[string]GrafanaContactPoint)
+	if local != nil {
+		for _, integration := range local.Integrations {
+			localIntegrations[integration.Uid] = integration
+		}
+	}
+
+	contactPoint := &ContactPointFile{Name: name, Integrations: []GrafanaContactPoint{}}
+	for _, integration := range remote {
+		integration.Name = name
+		integration.Provenance = ""
+		integration.Settings = redactSettings(integration.Settings, localIntegrations[integration.Uid].Settings, name, integration.Type)
+		contactPoint.Integrations = append(contactPoint.Integrations, integration)
+	}
+	return contactPoint
+}
+
+// Converts the contact point into a generic model for comparisons
+func contactPointModel(contactPoint *ContactPointFile) map[string]interface{} {
+	content, _ := json.Marshal(contactPoint)
+	model, _ := unmarshalDashboard(content)
+	// Names are kept once at the top of the file
+	if integrations, ok := model["integrations"].([]interface{}); ok {
+		for _, integration := range integrations {
+			if object, ok := integration.(map[string]interface{}); ok {
+				delete(object, "name")
+			}
+		}
+	}
+	return model
+}
+
+// Downloads contact points into the alerting path, all of them when no names are given
+func (gc *GrafanaClient) PullContactPoints(ctx context.Context, alertingPath string, names []string) ([]AlertingResult, error) {
+	remote, err := gc.GetContactPoints(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var order []string
+	byName := make(map[string][]GrafanaContactPoint)
+	for _, integration := range remote {
+		if _, ok := byName[integration.Name]; !ok {
+			order = append(order, integration.Name)
+		}
+		byName[integration.Name] = append(byName[integration.Name], integration)
+	}
+
+	if len(names) > 0 {
+		order = names
+	}
+
+	var results []AlertingResult
+	for _, name := range order {
+		result := AlertingResult{Name: name, FilePath: ContactPointFilePath(alertingPath, name)}
+		integrations, ok := byName[name]
+		if !ok {
+			result.Status = "missing"
+			result.Err = fmt.Errorf("contact point %s not found in Grafana", name)
+			results = append(results, result)
+			continue
+		}
+
+		local, err := ReadContactPointFile(result.FilePath)
+		if err != nil && !os.IsNotExist(err) {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+
+		pulled := toContactPointFile(name, integrations, local)
+		switch {
+		case local == nil:
+			result.Status = "created"
+		case reflect.DeepEqual(contactPointModel(local), contactPointModel(pulled)):
+			result.Status = "unchanged"
+			results = append(results, result)
+			continue
+		default:
+			result.Status = "updated"
+		}
+
+		if err := writeResourceFile(result.FilePath, contactPointModel(pulled)); err != nil {
+			result.Err = err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Compares a local contact point file with Grafana
+// Secrets are redacted by Grafana, changes to secret values are not detected
+func (gc *GrafanaClient) DiffContactPoint(ctx context.Context, filePath string) ([]gdiff.Change, error) {
+	local, err := ReadContactPointFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := gc.GetContactPoints(ctx, local.Name)
+	if err != nil {
+		return nil, err
+	}
+	return gdiff.Compare(contactPointModel(local), contactPointModel(toContactPointFile(local.Name, remote, local))), nil
+}
+
+// Deploys a local contact point file, filling secrets in from the environment
+// New integrations get their Grafana uid written back into the file
+func (gc *GrafanaClient) PushContactPoint(ctx context.Context, filePath string, dryRun bool) AlertingResult {
+	result := AlertingResult{FilePath: filePath}
+
+	local, err := ReadContactPointFile(filePath)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Name = local.Name
+
+	remote, err := gc.GetContactPoints(ctx, local.Name)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	remoteUids := make(map[string]bool)
+	for _, integration := range remote {
+		remoteUids[integration.Uid] = true
+	}
+
+	result.Changes = gdiff.Compare(contactPointModel(toContactPointFile(local.Name, remote, local)), contactPointModel(local))
+	switch {
+	case len(result.Changes) == 0:
+		result.Status = "unchanged"
+		return result
+	case len(remote) == 0:
+		result.Status = "created"
+	default:
+		result.Status = "updated"
+	}
+
+	// Expanded before the dry run so missing secrets are reported by it too
+	// Existing integrations keep their stored secrets when no value is set
+	settings := make([]map[string]interface{}, len(local.Integrations))
+	for i, integration := range local.Integrations {
+		isUpdate := integration.Uid != "" && remoteUids[integration.Uid]
+		settings[i], err = expandSettings(integration.Settings, isUpdate)
+		if err != nil {
+			result.Err = fmt.Errorf("integration %s: %w", integration.Type, err)
+			return result
+		}
+	}
+	if dryRun {
+		return result
+	}
+
+	isUidAdded := false
+	for i, integration := range local.Integrations {
+		isUpdate := integration.Uid != "" && remoteUids[integration.Uid]
+		integration.Settings = settings[i]
+
+		saved, err := gc.saveContactPoint(ctx, integration, isUpdate)
+		if err != nil {
+			result.Err = fmt.Errorf("integration %s: %w", integration.Type, err)
+			return result
+		}
+		if local.Integrations[i].Uid != saved.Uid {
+			local.Integrations[i].Uid = saved.Uid
+			isUidAdded = true
+		}
+	}
+
+	// Integrations removed from the file are removed from the contact point
+	localUids := make(map[string]bool)
+	for _, integration := range local.Integrations {
+		localUids[integration.Uid] = true
+	}
+	for _, integration := range remote {
+		if localUids[integration.Uid] {
+			continue
+		}
+		if err := gc.deleteContactPoint(ctx, integration.Uid); err != nil {
+			result.Err = fmt.Errorf("integration %s: %w", integration.Type, err)
+			return result
+		}
+	}
+
+	if isUidAdded {
+		if err := writeResourceFile(filePath, contactPointModel(local)); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	gc.Logger.Info(
+		fmt.Sprintf("Contact point %s", result.Status),
+		slog.String("name", local.Name),
+		slog.String("path", filePath))
+	return result
+}
+
+// Resolves contact point file patterns relative to the alerting path, every
+// file under the contact-points directory when no patterns are given
+func ResolveContactPointFiles(alertingPath string, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"contact-points/*.yaml", "contact-points/*.yml", "contact-points/*.json"}
+	}
+
+	var filePaths []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(alertingPath, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid contact point pattern %s: %v", pattern, err)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				filePaths = append(filePaths, match)
+			}
+		}
+	}
+	return filePaths, nil
+}
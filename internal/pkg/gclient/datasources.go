@@ -0,0 +1,429 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+)
+
+var ErrDatasourceNotFound = fmt.Errorf("datasource not found")
+
+// Datasource as returned by the Grafana API
+// Secure values are never returned, secureJsonFields lists the ones set
+type GrafanaDatasource struct {
+	Id               int                    `json:"id,omitempty"`
+	Uid              string                 `json:"uid"`
+	Name             string                 `json:"name"`
+	Type             string                 `json:"type"`
+	Access           string                 `json:"access"`
+	Url              string                 `json:"url"`
+	User             string                 `json:"user"`
+	Database         string                 `json:"database"`
+	BasicAuth        bool                   `json:"basicAuth"`
+	BasicAuthUser    string                 `json:"basicAuthUser"`
+	WithCredentials  bool                   `json:"withCredentials"`
+	IsDefault        bool                   `json:"isDefault"`
+	JsonData         map[string]interface{} `json:"jsonData"`
+	SecureJsonData   map[string]interface{} `json:"secureJsonData,omitempty"`
+	SecureJsonFields map[string]bool        `json:"secureJsonFields,omitempty"`
+	Version          int                    `json:"version,omitempty"`
+}
+
+// Datasource in the Grafana provisioning format
+type ProvisionedDatasource struct {
+	Name            string                 `json:"name"`
+	Type            string                 `json:"type"`
+	Uid             string                 `json:"uid"`
+	Access          string                 `json:"access,omitempty"`
+	Url             string                 `json:"url,omitempty"`
+	User            string                 `json:"user,omitempty"`
+	Database        string                 `json:"database,omitempty"`
+	BasicAuth       bool                   `json:"basicAuth,omitempty"`
+	BasicAuthUser   string                 `json:"basicAuthUser,omitempty"`
+	WithCredentials bool                   `json:"withCredentials,omitempty"`
+	IsDefault       bool                   `json:"isDefault,omitempty"`
+	JsonData        map[string]interface{} `json:"jsonData,omitempty"`
+	SecureJsonData  map[string]interface{} `json:"secureJsonData,omitempty"`
+}
+
+// Grafana provisioning file holding the datasources
+type DatasourceFile struct {
+	ApiVersion  int                     `json:"apiVersion"`
+	Datasources []ProvisionedDatasource `json:"datasources"`
+}
+
+type DatasourceResult struct {
+	Uid  string
+	Name string
+	// created, updated, unchanged or missing
+	Status  string
+	Changes []gdiff.Change
+	Err     error
+}
+
+// Reads a datasource provisioning file, a missing file reads as empty
+func ReadDatasourceFile(filePath string) (*DatasourceFile, error) {
+	datasourceFile := &DatasourceFile{ApiVersion: 1}
+	if err := readResourceFile(filePath, datasourceFile); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, datasource := range datasourceFile.Datasources {
+		if datasource.Uid == "" {
+			return nil, fmt.Errorf("datasource %s has no uid attribute in %s", datasource.Name, filePath)
+		}
+	}
+	return datasourceFile, nil
+}
+
+func (gc *GrafanaClient) GetDatasources(ctx context.Context) ([]GrafanaDatasource, error) {
+	var datasources []GrafanaDatasource
+	if err := gc.getDatasourceJson(ctx, gc.endpoint("api", "datasources"), &datasources); err != nil {
+		return nil, err
+	}
+	return datasources, nil
+}
+
+// Fetches a datasource by uid, including the secure fields that are set
+func (gc *GrafanaClient) GetDatasource(ctx context.Context, uid string) (*GrafanaDatasource, error) {
+	var datasource GrafanaDatasource
+	if err := gc.getDatasourceJson(ctx, gc.endpoint("api", "datasources", "uid", uid), &datasource); err != nil {
+		return nil, err
+	}
+	return &datasource, nil
+}
+
+func (gc *GrafanaClient) getDatasourceJson(ctx context.Context, apiUrl string, value interface{}) error {
+	resp, err := gc.createRequest(ctx, apiUrl, "GET", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrDatasourceNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status=%d, body=%s", resp.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, value)
+}
+
+// Creates the datasource, or updates the datasource with the same uid
+func (gc *GrafanaClient) saveDatasource(ctx context.Context, datasource GrafanaDatasource, isUpdate bool) error {
+	apiUrl := gc.endpoint("api", "datasources")
+	method := "POST"
+	if isUpdate {
+		apiUrl = gc.endpoint("api", "datasources", "uid", datasource.Uid)
+		method = "PUT"
+	}
+
+	payload, err := json.Marshal(datasource)
+	if err != nil {
+		return err
+	}
+	resp, err := gc.createRequest(ctx, apiUrl, method, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status=%d, body=%s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// Converts a Grafana datasource into the provisioning format
+// Secure fields become environment placeholders, reusing the local ones
+func toProvisionedDatasource(remote *GrafanaDatasource, local *ProvisionedDatasource) ProvisionedDatasource {
+	datasource := ProvisionedDatasource{
+		Name:            remote.Name,
+		Type:            remote.Type,
+		Uid:             remote.Uid,
+		Access:          remote.Access,
+		Url:             remote.Url,
+		User:            remote.User,
+		Database:        remote.Database,
+		BasicAuth:       remote.BasicAuth,
+		BasicAuthUser:   remote.BasicAuthUser,
+		WithCredentials: remote.WithCredentials,
+		IsDefault:       remote.IsDefault,
+		JsonData:        remote.JsonData,
+	}
+	if len(datasource.JsonData) == 0 {
+		datasource.JsonData = nil
+	}
+
+	redacted := make(map[string]interface{})
+	for key, isSet := range remote.SecureJsonFields {
+		if isSet {
+			redacted[key] = redactedValue
+		}
+	}
+	if len(redacted) > 0 {
+		var localSecrets map[string]interface{}
+		if local != nil {
+			localSecrets = local.SecureJsonData
+		}
+		datasource.SecureJsonData = redactSettings(redacted, localSecrets, remote.Name)
+	}
+	return datasource
+}
+
+// Converts the datasource into a generic model for comparisons
+// Secret values are not returned by Grafana, only the keys are compared
+func datasourceModel(datasource ProvisionedDatasource) map[string]interface{} {
+	content, _ := json.Marshal(datasource)
+	model, _ := unmarshalDashboard(content)
+	if secrets, ok := model["secureJsonData"].(map[string]interface{}); ok {
+		for key := range secrets {
+			secrets[key] = redactedValue
+		}
+	}
+	return model
+}
+
+// Fills the secure values in from the environment
+// Updates leave secrets without a value out so Grafana keeps the stored ones,
+// new datasources fail instead
+func expandSecureJsonData(secrets map[string]interface{}, isUpdate bool) (map[string]interface{}, error) {
+	expanded := make(map[string]interface{}, len(secrets))
+	var missing []string
+	for key, value := range secrets {
+		stringValue, _ := value.(string)
+		match := secretPlaceholder.FindStringSubmatch(stringValue)
+		if match == nil {
+			expanded[key] = value
+			continue
+		}
+		if secret, ok := os.LookupEnv(match[1]); ok {
+			expanded[key] = secret
+		} else if !isUpdate {
+			missing = append(missing, match[1])
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: %s", ErrMissingSecret, strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// Downloads datasources into the provisioning file, matching entries by uid
+// Entries missing from Grafana are kept and reported
+func (gc *GrafanaClient) PullDatasources(ctx context.Context, filePath string, uids []string) ([]DatasourceResult, error) {
+	datasourceFile, err := ReadDatasourceFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(uids) == 0 {
+		datasources, err := gc.GetDatasources(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, datasource := range datasources {
+			uids = append(uids, datasource.Uid)
+		}
+		for _, local := range datasourceFile.Datasources {
+			if !slices.Contains(uids, local.Uid) {
+				uids = append(uids, local.Uid)
+			}
+		}
+	}
+
+	var results []DatasourceResult
+	isChanged := false
+	for _, uid := range uids {
+		result := DatasourceResult{Uid: uid}
+		index := datasourceFile.indexOf(uid)
+
+		remote, err := gc.GetDatasource(ctx, uid)
+		if err == ErrDatasourceNotFound {
+			result.Status = "missing"
+			if index >= 0 {
+				result.Name = datasourceFile.Datasources[index].Name
+			} else {
+				result.Err = fmt.Errorf("datasource uid=%s not found in Grafana", uid)
+			}
+			results = append(results, result)
+			continue
+		} else if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+		result.Name = remote.Name
+
+		if index < 0 {
+			result.Status = "created"
+			datasourceFile.Datasources = append(datasourceFile.Datasources, toProvisionedDatasource(remote, nil))
+		} else {
+			local := datasourceFile.Datasources[index]
+			pulled := toProvisionedDatasource(remote, &local)
+			result.Changes = gdiff.Compare(datasourceModel(local), datasourceModel(pulled))
+			if len(result.Changes) == 0 {
+				result.Status = "unchanged"
+				results = append(results, result)
+				continue
+			}
+			result.Status = "updated"
+			datasourceFile.Datasources[index] = pulled
+		}
+		isChanged = true
+		results = append(results, result)
+	}
+
+	if isChanged {
+		if err := writeResourceFile(filePath, datasourceFile); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// Compares the datasources of the provisioning file with Grafana by uid
+// Datasources only in Grafana are not part of the file and are left out
+func (gc *GrafanaClient) DiffDatasources(ctx context.Context, filePath string) ([]DatasourceResult, error) {
+	datasourceFile, err := ReadDatasourceFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []DatasourceResult
+	for _, local := range datasourceFile.Datasources {
+		result := DatasourceResult{Uid: local.Uid, Name: local.Name}
+		remote, err := gc.GetDatasource(ctx, local.Uid)
+		switch {
+		case err == ErrDatasourceNotFound:
+			result.Status = "missing"
+			result.Changes = gdiff.Compare(datasourceModel(local), map[string]interface{}{})
+		case err != nil:
+			result.Err = err
+		default:
+			result.Changes = gdiff.Compare(datasourceModel(local), datasourceModel(toProvisionedDatasource(remote, &local)))
+			result.Status = "unchanged"
+			if len(result.Changes) > 0 {
+				result.Status = "changed"
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Deploys the datasources of the provisioning file, filling secrets in from the
+// environment. Secret values cannot be compared, datasources are only pushed
+// when other attributes or the secret keys changed
+func (gc *GrafanaClient) PushDatasources(ctx context.Context, filePath string, uids []string, dryRun bool) ([]DatasourceResult, error) {
+	datasourceFile, err := ReadDatasourceFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []DatasourceResult
+	for _, local := range datasourceFile.Datasources {
+		if len(uids) > 0 && !slices.Contains(uids, local.Uid) {
+			continue
+		}
+		results = append(results, gc.pushDatasource(ctx, local, dryRun))
+	}
+	for _, uid := range uids {
+		if datasourceFile.indexOf(uid) < 0 {
+			results = append(results, DatasourceResult{Uid: uid, Err: fmt.Errorf("datasource uid=%s not found in %s", uid, filePath)})
+		}
+	}
+	return results, nil
+}
+
+func (gc *GrafanaClient) pushDatasource(ctx context.Context, local ProvisionedDatasource, dryRun bool) DatasourceResult {
+	result := DatasourceResult{Uid: local.Uid, Name: local.Name}
+
+	remote, err := gc.GetDatasource(ctx, local.Uid)
+	isUpdate := err == nil
+	switch {
+	case err == ErrDatasourceNotFound:
+		result.Status = "created"
+		result.Changes = gdiff.Compare(map[string]interface{}{}, datasourceModel(local))
+	case err != nil:
+		result.Err = err
+		return result
+	default:
+		result.Changes = gdiff.Compare(datasourceModel(toProvisionedDatasource(remote, &local)), datasourceModel(local))
+		if len(result.Changes) == 0 {
+			result.Status = "unchanged"
+			return result
+		}
+		result.Status = "updated"
+	}
+
+	secrets, err := expandSecureJsonData(local.SecureJsonData, isUpdate)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if dryRun {
+		return result
+	}
+
+	datasource := GrafanaDatasource{
+		Uid:             local.Uid,
+		Name:            local.Name,
+		Type:            local.Type,
+		Access:          local.Access,
+		Url:             local.Url,
+		User:            local.User,
+		Database:        local.Database,
+		BasicAuth:       local.BasicAuth,
+		BasicAuthUser:   local.BasicAuthUser,
+		WithCredentials: local.WithCredentials,
+		IsDefault:       local.IsDefault,
+		JsonData:        local.JsonData,
+		SecureJsonData:  secrets,
+	}
+	if datasource.Access == "" {
+		datasource.Access = "proxy"
+	}
+	if isUpdate {
+		datasource.Id = remote.Id
+		datasource.Version = remote.Version
+	}
+
+	if err := gc.saveDatasource(ctx, datasource, isUpdate); err != nil {
+		result.Err = err
+		return result
+	}
+	gc.Logger.Info(
+		fmt.Sprintf("Datasource %s", result.Status),
+		slog.String("uid", local.Uid),
+		slog.String("name", local.Name))
+	return result
+}
+
+func (df *DatasourceFile) indexOf(uid string) int {
+	for i, datasource := range df.Datasources {
+		if datasource.Uid == uid {
+			return i
+		}
+	}
+	return -1
+}
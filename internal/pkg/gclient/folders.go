@@ -0,0 +1,160 @@
+package gclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/alex067/gsync/internal/pkg/gapi"
+)
+
+var ErrFolderNotFound = fmt.Errorf("no Grafana folder for directory")
+
+// What we expect from the Grafana folder API
+type GrafanaFolder = gapi.Folder
+
+// Mirrors directories under the dashboards path to Grafana folders
+// Directories map to folders by title, nested directories to nested folders,
+// unless the directory is listed in the explicit mapping
+type FolderMirror struct {
+	gc *GrafanaClient
+	// Relative directory to folder uid, ex: team-a/infra -> abc123
+	dirToUid map[string]string
+	uidToDir map[string]string
+}
+
+// Lists the folders under the parent, top level folders for an empty parent
+func (gc *GrafanaClient) GetFolders(ctx context.Context, parentUid string) ([]GrafanaFolder, error) {
+	folders, err := gc.api().GetFolders(ctx, gapi.FolderQuery{ParentUid: parentUid, Limit: 1000})
+	return folders, apiError(err)
+}
+
+// Creates a folder, nested under the parent when given
+func (gc *GrafanaClient) CreateFolder(ctx context.Context, title, parentUid string) (*GrafanaFolder, error) {
+	folder, err := gc.api().CreateFolder(ctx, gapi.CreateFolderRequest{Title: title, ParentUid: parentUid})
+	return folder, apiError(err)
+}
+
+// Loads the Grafana folder tree and the explicit directory mapping
+func (gc *GrafanaClient) NewFolderMirror(ctx context.Context, mapping map[string]string) (*FolderMirror, error) {
+	fm := &FolderMirror{
+		gc:       gc,
+		dirToUid: make(map[string]string),
+		uidToDir: make(map[string]string),
+	}
+
+	// Grafana versions without nested folders ignore the parent and return
+	// every folder, visited folders are skipped to avoid walking them again
+	visited := make(map[string]bool)
+	var walk func(parentUid, parentDir string) error
+	walk = func(parentUid, parentDir string) error {
+		folders, err := gc.GetFolders(ctx, parentUid)
+		if err != nil {
+			return err
+		}
+		for _, folder := range folders {
+			if visited[folder.Uid] {
+				continue
+			}
+			visited[folder.Uid] = true
+
+			// Titles never add directories, ex: a/b or .., titles without any
+			// usable character map to the folder uid
+			name := pathSegment(folder.Title)
+			if name == "" {
+				name = pathSegment(folder.Uid)
+			}
+			dir := path.Join(parentDir, name)
+			if _, ok := fm.dirToUid[dir]; !ok {
+				fm.dirToUid[dir] = folder.Uid
+			}
+			fm.uidToDir[folder.Uid] = dir
+			if err := walk(folder.Uid, dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("", ""); err != nil {
+		return nil, err
+	}
+
+	// Explicit mappings take precedence over titles
+	for dir, folderUid := range mapping {
+		dir = normalizeDir(dir)
+		fm.dirToUid[dir] = folderUid
+		fm.uidToDir[folderUid] = dir
+	}
+	return fm, nil
+}
+
+// Uses forward slashes and no leading or trailing separators, the dashboards
+// path itself is the empty directory
+func normalizeDir(dir string) string {
+	dir = path.Clean(filepath.ToSlash(dir))
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return strings.Trim(dir, "/")
+}
+
+// Finds the folder uid for a directory relative to the dashboards path
+// Missing folders are created when create is set, one level at a time
+func (fm *FolderMirror) FolderUidForDir(ctx context.Context, dir string, create bool) (string, error) {
+	dir = normalizeDir(dir)
+	// Dashboards at the root of the path live in the General folder
+	if dir == "" {
+		return "", nil
+	}
+	if folderUid, ok := fm.dirToUid[dir]; ok {
+		return folderUid, nil
+	}
+	// Checked before creating the parents so nothing is left half created
+	if err := fm.checkFolderTitles(dir); err != nil {
+		return "", err
+	}
+	if !create {
+		return "", fmt.Errorf("%w %s", ErrFolderNotFound, dir)
+	}
+
+	parentUid, err := fm.FolderUidForDir(ctx, path.Dir(dir), create)
+	if err != nil {
+		return "", err
+	}
+
+	folder, err := fm.gc.CreateFolder(ctx, path.Base(dir), parentUid)
+	if err != nil {
+		return "", err
+	}
+	fm.gc.Logger.Info("Folder created", slog.String("title", folder.Title), slog.String("uid", folder.Uid))
+
+	fm.dirToUid[dir] = folder.Uid
+	fm.uidToDir[folder.Uid] = dir
+	return folder.Uid, nil
+}
+
+// Reports directories that cannot be folder titles, ex: .. or names with
+// surrounding spaces, only directories without a folder yet are checked
+func (fm *FolderMirror) checkFolderTitles(dir string) error {
+	for ; dir != "" && dir != "."; dir = path.Dir(dir) {
+		if _, ok := fm.dirToUid[dir]; ok {
+			return nil
+		}
+		if title := path.Base(dir); pathSegment(title) != title {
+			return fmt.Errorf("directory %s is not a valid folder title, map it to a folder uid in the context", dir)
+		}
+	}
+	return nil
+}
+
+// Finds the directory relative to the dashboards path for a folder uid
+func (fm *FolderMirror) DirForFolder(folderUid string) (string, bool) {
+	if folderUid == "" {
+		return "", true
+	}
+	dir, ok := fm.uidToDir[folderUid]
+	return filepath.FromSlash(dir), ok
+}
@@ -0,0 +1,140 @@
+package gclient
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Watches the real dashboards of a Grafana folder, no watcher copies are made
+// Every dashboard maps to a file under Options.DashboardsPath
+type GrafanaFolderWatcher struct {
+	FolderUid string
+	Options   PullOptions
+	// Watched dashboards keyed by uid
+	Dashboards map[string]*GrafanaDashboardClient
+	// Uids of dashboards that left the folder during the session
+	Removed []string
+	retry   retryBudget
+}
+
+// Fetches a folder by uid
+func (gc *GrafanaClient) GetFolder(ctx context.Context, uid string) (*GrafanaFolder, error) {
+	folder, err := gc.api().GetFolder(ctx, uid)
+	return folder, apiError(err)
+}
+
+// Lists the uids of the dashboards directly in the folder, watcher copies excluded
+func (gc *GrafanaClient) searchFolderDashboards(ctx context.Context, folderUid string) ([]string, error) {
+	results, err := gc.SearchDashboards(ctx, GrafanaSearchQuery{FolderUids: []string{folderUid}})
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []string
+	for _, result := range results {
+		if slices.Contains(result.Tags, WatcherTag) {
+			continue
+		}
+		uids = append(uids, result.Uid)
+	}
+	return uids, nil
+}
+
+// Pulls a dashboard that joined the folder and starts watching it
+func (gc *GrafanaClient) addFolderDashboard(ctx context.Context, fw *GrafanaFolderWatcher, uid string, dashboardFiles map[string]string) error {
+	dbClient := &GrafanaDashboardClient{Uid: uid, FolderUid: fw.FolderUid}
+	// Versions saved after the baseline are picked up by the next poll
+	if err := gc.GetDashboardChanges(ctx, dbClient); err != nil {
+		return err
+	}
+
+	result := gc.PullDashboard(ctx, uid, fw.Options, dashboardFiles)
+	if result.Err != nil {
+		return result.Err
+	}
+	dashboardFileData, err := os.ReadFile(result.FilePath)
+	if err != nil {
+		return err
+	}
+
+	dbClient.FilePath = result.FilePath
+	dbClient.recordSync(dashboardFileData)
+	fw.Dashboards[uid] = dbClient
+	return nil
+}
+
+// Finds dashboards that joined or left the folder, then saves the changes of
+// every watched dashboard to disk
+// Files of dashboards that left the folder are kept and reported
+func (gc *GrafanaClient) SyncFolder(ctx context.Context, fw *GrafanaFolderWatcher) error {
+	uids, err := gc.searchFolderDashboards(ctx, fw.FolderUid)
+	if err != nil {
+		return gc.pollFailed(&fw.retry, fw.FolderUid, err)
+	}
+	fw.retry.reset()
+
+	for uid, dbClient := range fw.Dashboards {
+		if slices.Contains(uids, uid) {
+			continue
+		}
+		gc.Logger.Warn(
+			"Dashboard deleted or moved out of the folder, keeping local file",
+			slog.String("uid", uid),
+			slog.String("path", dbClient.FilePath))
+		fw.Removed = append(fw.Removed, uid)
+		delete(fw.Dashboards, uid)
+	}
+
+	var dashboardFiles map[string]string
+	for _, uid := range uids {
+		if _, ok := fw.Dashboards[uid]; ok {
+			continue
+		}
+		if dashboardFiles == nil {
+			if dashboardFiles, err = IndexDashboardFiles(fw.Options.DashboardsPath); err != nil {
+				return err
+			}
+		}
+		if err := gc.addFolderDashboard(ctx, fw, uid, dashboardFiles); err != nil {
+			gc.Logger.Error("error pulling dashboard", slog.String("uid", uid), slog.String("error", err.Error()))
+			continue
+		}
+		gc.Logger.Info("Watching dashboard", slog.String("uid", uid), slog.String("path", fw.Dashboards[uid].FilePath))
+	}
+
+	var wg sync.WaitGroup
+	for _, dbClient := range fw.Dashboards {
+		if dbClient.Err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gc.pollWatcherDashboard(ctx, dbClient)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// Watches every dashboard in the folder on the shared poll loop
+// Dashboards created in the folder during the session are pulled as new files
+func (gc *GrafanaClient) StartWatchingFolder(ctx context.Context, fw *GrafanaFolderWatcher) error {
+	if err := os.MkdirAll(fw.Options.DashboardsPath, 0755); err != nil {
+		return err
+	}
+	fw.Dashboards = make(map[string]*GrafanaDashboardClient)
+
+	// The first sync pulls every dashboard already in the folder
+	if err := gc.SyncFolder(ctx, fw); err != nil {
+		return err
+	}
+
+	gc.Logger.Info("Watching...", slog.String("folder", fw.FolderUid), slog.Int("dashboards", len(fw.Dashboards)))
+	return gc.runWatchLoop(ctx, []func() error{func() error {
+		return gc.SyncFolder(ctx, fw)
+	}})
+}
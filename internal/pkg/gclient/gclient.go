@@ -3,29 +3,33 @@ package gclient
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"math/rand"
 	"net/http"
 	"os"
-	"os/signal"
+	"reflect"
+	"strings"
 	"sync"
-	"syscall"
 	"time"
 
+	"github.com/alex067/gsync/internal/pkg/gapi"
 	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+	"github.com/fsnotify/fsnotify"
 )
 
-var ErrCleanShutdown = fmt.Errorf("shutdown signal")
-var ErrNotThatSerious = fmt.Errorf("internal request failure but try again")
-var ErrInternalFailure = fmt.Errorf("internal request failure")
+var ErrDashboardNotFound = fmt.Errorf("dashboard not found")
+var ErrVersionMismatch = fmt.Errorf("dashboard changed in Grafana since the given version")
 
-// What we expect from Grafana API
+// Dashboard model and meta data as the watchers work with them
 type GrafanaDashboard struct {
-	Meta      map[string]interface{} `json:"meta"`
-	Dashboard map[string]interface{} `json:"dashboard"`
+	Meta      gapi.DashboardMeta
+	Dashboard map[string]interface{}
+	// Set for dashboards read through the resource API, changes on every write
+	ResourceVersion string
 }
 
 type GrafanaDashboardClient struct {
@@ -36,15 +40,95 @@ type GrafanaDashboardClient struct {
 	LastVersion        int
 	IsDashboardChanged bool
 	Uid                string
+	// Schema of the local file, v2 files are dashboard resources
+	Format DashboardFormat
+	// Set once the watcher stops polling due to a failure
+	Err   error
+	retry retryBudget
+	// Changes found in the latest version
+	Changes []gdiff.Change
+	// Hash and content of the file last synced by gsync
+	localHash   string
+	baseContent []byte
+	// Last sync written to the config
+	recordedHash    string
+	recordedVersion int
+	// Versions of the library panels the watcher uses, keyed by uid
+	libraryVersions map[string]int
+	// Latest resourceVersion when watching through the resource API
+	lastResourceVersion string
+}
+
+// Client of a watcher recorded in the config, outside of a watch session
+// The last recorded sync lets local edits be told apart from gsync writes
+func NewWatcherClient(resource gcontext.GContextGrafanaResource, folderUid string) *GrafanaDashboardClient {
+	return &GrafanaDashboardClient{
+		Uid:             resource.Uid,
+		FilePath:        resource.Path,
+		FolderUid:       folderUid,
+		LastVersion:     resource.Version,
+		localHash:       resource.Hash,
+		recordedHash:    resource.Hash,
+		recordedVersion: resource.Version,
+	}
 }
 
 type GrafanaClient struct {
-	Url        string
-	TenantId   string
-	ApiKey     string
-	Interval   time.Duration
-	HttpClient *http.Client
-	Logger     *slog.Logger
+	Url      string
+	TenantId string
+	ApiKey   string
+	Interval time.Duration
+	// Uploads local file edits to the watcher dashboards
+	SyncLocalChanges bool
+	// Decides how to settle local and remote changes made at the same time
+	ConflictResolver func(filePath string) (ConflictResolution, error)
+	// How saved dashboards keep their library panels
+	LibraryPanels LibraryPanelMode
+	// Backends telling watchers when to poll, nil picks the best available
+	ChangeSources []ChangeSource
+	// Skips detection of the dashboard resource API
+	LegacyDashboardApi bool
+	conflictMutex      sync.Mutex
+	// Detected on first use, nil when only the legacy API is served
+	resourceApiMutex     sync.Mutex
+	isResourceApiChecked bool
+	dashboardResourceApi *DashboardResourceApi
+	HttpClient           *http.Client
+	// Zero value uses DefaultRetryPolicy
+	Retry  RetryPolicy
+	Logger *slog.Logger
+}
+
+// Round tripper of the clients created by NewGrafanaClient, nil uses
+// http.DefaultTransport, ex: the chaos transport of --chaos
+// The Grafana Live websocket dials on its own and is not affected
+var Transport http.RoundTripper
+
+// Creates a client for the Grafana instance of the given context
+func NewGrafanaClient(currentContext gcontext.GContext, logger *slog.Logger) *GrafanaClient {
+	return &GrafanaClient{
+		Url:      currentContext.Url,
+		TenantId: currentContext.Context.Dashboards.GrafanaTenant,
+		ApiKey:   currentContext.Authentication.Grafana.Token,
+		Logger:   logger,
+		// Unknown modes are rejected by the commands before watching
+		LibraryPanels:      LibraryPanelMode(currentContext.Context.Dashboards.LibraryPanels),
+		LegacyDashboardApi: currentContext.Context.Dashboards.LegacyApi,
+		HttpClient: &http.Client{
+			Timeout:   60 * time.Second,
+			Transport: Transport,
+		},
+	}
+}
+
+// Typed API client sending requests through the retry policy of gc
+func (gc *GrafanaClient) api() *gapi.Client {
+	return &gapi.Client{
+		Url:   gc.Url,
+		Token: gc.ApiKey,
+		OrgId: gc.TenantId,
+		Doer:  retryingDoer{gc: gc},
+	}
 }
 
 // Sets default request headers to authenticate to Grafana
@@ -54,25 +138,38 @@ func (gc *GrafanaClient) setRequestHeaders(req *http.Request) {
 	req.Header.Set("X-Grafana-Org-Id", gc.TenantId)
 }
 
-func (gc *GrafanaClient) createRequest(apiUrl string, method string, payload []byte) (*http.Response, error) {
-	var req *http.Request
-	var err error
-	if payload != nil {
-		req, err = http.NewRequest(method, apiUrl, bytes.NewBuffer(payload))
-	} else {
-		req, err = http.NewRequest(method, apiUrl, nil)
-	}
-	if err != nil {
-		gc.Logger.Error(
-			"error creating request",
-			slog.String("error", err.Error()),
-		)
-		return nil, err
-	}
+// Builds the URL of an API path, segments are escaped, see gapi.Path
+func (gc *GrafanaClient) endpoint(segments ...string) string {
+	return strings.TrimSuffix(gc.Url, "/") + gapi.Path(segments...)
+}
 
-	gc.setRequestHeaders(req)
+func (gc *GrafanaClient) createRequest(ctx context.Context, apiUrl string, method string, payload []byte) (*http.Response, error) {
+	return gc.createRequestWithHeaders(ctx, apiUrl, method, payload, nil)
+}
 
-	resp, err := gc.HttpClient.Do(req)
+// Same as createRequest with extra headers, ex: X-Disable-Provenance
+func (gc *GrafanaClient) createRequestWithHeaders(
+	ctx context.Context,
+	apiUrl string,
+	method string,
+	payload []byte,
+	headers map[string]string,
+) (*http.Response, error) {
+	resp, err := gc.doWithRetry(ctx, func() (*http.Request, error) {
+		var body io.Reader
+		if payload != nil {
+			body = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, apiUrl, body)
+		if err != nil {
+			return nil, err
+		}
+		gc.setRequestHeaders(req)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		return req, nil
+	})
 	if err != nil {
 		gc.Logger.Error(
 			"error making request",
@@ -94,99 +191,242 @@ func (gc *GrafanaClient) generateRandomUid() string {
 	return string(randUid)
 }
 
+// What Grafana returns after saving a dashboard
+type GrafanaSaveDashboardResponse = gapi.SaveDashboardResponse
+
+// Creates or updates a dashboard
+// Without overwrite Grafana rejects the save when the version is outdated
+func (gc *GrafanaClient) saveDashboard(
+	ctx context.Context,
+	dashboard map[string]interface{},
+	folderUid string,
+	message string,
+	overwrite bool,
+) (*GrafanaSaveDashboardResponse, error) {
+	result, err := gc.api().SaveDashboard(ctx, gapi.SaveDashboardRequest{
+		Dashboard: dashboard,
+		FolderUid: folderUid,
+		Message:   message,
+		Overwrite: overwrite,
+	})
+	if gapi.IsStatus(err, http.StatusPreconditionFailed) {
+		return nil, fmt.Errorf("%w: %w", ErrVersionMismatch, err)
+	}
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return result, nil
+}
+
+// Overwrites uid and appends preview title so the watcher is never mistaken
+// for the real dashboard, the tag and metadata let orphaned watchers be found
+func setWatcherAttributes(dashboard map[string]interface{}, watcherUid string, metadata WatcherMetadata) {
+	dashboardTitle := dashboard["title"]
+	dashboard["uid"] = watcherUid
+	dashboard["title"] = fmt.Sprintf("%s (Gsync %s)", dashboardTitle, watcherUid)
+	dashboard["description"] = fmt.Sprintf("Generated by gsync. Watcher for %s", dashboardTitle)
+	setWatcherMarker(dashboard, metadata)
+}
+
 // Creates temp dashboard to watch over for changes
 // Dashboards are prefixed with hash and recorded in local disk
-func (gc *GrafanaClient) generateTempDashboard(dashboardFilePath, folderUid string) (string, error) {
-	apiUrl := fmt.Sprintf("%s/api/dashboards/db", gc.Url)
-	var dashboard map[string]interface{}
-
+func (gc *GrafanaClient) generateTempDashboard(ctx context.Context, dashboardFilePath, folderUid string) (string, error) {
 	// Ignore error since file is validated
 	dashboardFileData, _ := os.ReadFile(dashboardFilePath)
-	if err := json.Unmarshal(dashboardFileData, &dashboard); err != nil {
+	dashboard, format, err := parseDashboardFile(dashboardFileData)
+	if err != nil {
 		return "", err
 	}
 
 	// Generate random string hash
 	newUid := gc.generateRandomUid()
 
-	// Overwrite uid and append preview title
 	dashboardTitle := dashboard["title"]
-	dashboard["uid"] = newUid
-	dashboard["title"] = fmt.Sprintf("%s (Gsync %s)", dashboardTitle, newUid)
+	setWatcherAttributes(dashboard, newUid, newWatcherMetadata(dashboardFilePath))
 	dashboard["version"] = 0
 	dashboard["id"] = nil
-	dashboard["description"] = fmt.Sprintf("Generated by gsync. Watcher for %s", dashboardTitle)
-
-	requestBody := make(map[string]interface{})
-	requestBody["dashboard"] = dashboard
-	requestBody["overwrite"] = false
 
+	message := ""
 	if folderUid != "" {
-		requestBody["folderUid"] = folderUid
-		requestBody["message"] = fmt.Sprintf("Gsync preview dashboard for %s", dashboardTitle)
+		message = fmt.Sprintf("Gsync preview dashboard for %s", dashboardTitle)
 	}
 
-	payload, _ := json.Marshal(requestBody)
-
-	resp, err := gc.createRequest(apiUrl, "POST", payload)
-	if err != nil {
+	if _, err := gc.saveWatcherDashboard(ctx, format, dashboard, folderUid, message, false, false, ""); err != nil {
 		gc.Logger.Error(
 			"error creating request",
 			slog.String("error", err.Error()),
 		)
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("status=%d, body=%s", resp.StatusCode, string(body))
-	}
 
 	return newUid, nil
 }
 
 // Simply checks if the dashboard exists in Grafana
-func (gc *GrafanaClient) isDashboardExist(uid string) (bool, error) {
-	apiUrl := fmt.Sprintf("%s/api/dashboards/uid/%s", gc.Url, uid)
+func (gc *GrafanaClient) isDashboardExist(ctx context.Context, uid string) (bool, error) {
+	_, err := gc.GetDashboard(ctx, uid)
+	if err == ErrDashboardNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
 
-	req, err := http.NewRequest("GET", apiUrl, nil)
+// Changes stay pending until saved, a failed save is tried again on the next poll
+func (gcd *GrafanaDashboardClient) setAndCompareDashboardVersion() {
+	gcd.Mutex.Lock()
+	defer gcd.Mutex.Unlock()
+	// Resources change their resourceVersion on every write
+	if resourceVersion := gcd.Dashboard.ResourceVersion; resourceVersion != "" {
+		if gcd.lastResourceVersion != "" && gcd.lastResourceVersion != resourceVersion {
+			gcd.IsDashboardChanged = true
+		}
+		gcd.lastResourceVersion = resourceVersion
+		gcd.LastVersion = gcd.Dashboard.Meta.Version
+		return
+	}
+	if gcd.LastVersion != 0 && gcd.LastVersion != gcd.Dashboard.Meta.Version {
+		gcd.IsDashboardChanged = true
+	}
+	gcd.LastVersion = gcd.Dashboard.Meta.Version
+}
+
+// Creates the watcher dashboard for the given file, or reuses the watcher
+// recorded in the config if it still exists in Grafana
+// Reports whether a new watcher was created
+func (gc *GrafanaClient) initWatcherDashboard(
+	ctx context.Context,
+	configContext gcontext.GConfigContext,
+	dbClient *GrafanaDashboardClient,
+) (bool, error) {
+	dashboardFileData, err := os.ReadFile(dbClient.FilePath)
 	if err != nil {
-		gc.Logger.Error(
-			"error creating request",
-			slog.String("error", err.Error()))
-		return false, ErrInternalFailure
+		return false, err
+	}
+	if _, dbClient.Format, err = parseDashboardFile(dashboardFileData); err != nil {
+		return false, err
 	}
 
-	gc.setRequestHeaders(req)
+	// Check for existing watcher dashboards
+	watcherUid := configContext.GetResourceByPath(dbClient.FilePath)
+	if watcherUid != "" {
+		// Check if dashboard manually deleted by user
+		isDashboardExist, err := gc.isWatcherExist(ctx, dbClient, watcherUid)
+		if err != nil {
+			gc.Logger.Error(
+				"error checking for existing dashboard",
+				slog.String("uid", watcherUid),
+				slog.String("path", dbClient.FilePath),
+				slog.String("error", err.Error()))
+			return false, err
+		}
+
+		if isDashboardExist {
+			dbClient.Uid = watcherUid
+			gc.Logger.Info(
+				"Watcher dashboard found",
+				slog.String("path", dbClient.FilePath),
+				slog.String("url", fmt.Sprintf("%s/d/%s", gc.Url, watcherUid)))
+			gc.claimWatcher(configContext, dbClient)
+			return false, nil
+		}
+		gc.Logger.Info("Error fetching watcher dashboard from Grafana", slog.String("path", dbClient.FilePath))
+	}
 
-	resp, err := gc.HttpClient.Do(req)
+	gc.Logger.Info("Creating watcher dashboard...", slog.String("path", dbClient.FilePath))
+	// Deploy temp dashboard to watch
+	watcherUid, err = gc.generateTempDashboard(ctx, dbClient.FilePath, dbClient.FolderUid)
 	if err != nil {
 		gc.Logger.Error(
-			"error making request",
+			"error creating dashboard",
+			slog.String("path", dbClient.FilePath),
 			slog.String("error", err.Error()))
-		return false, ErrInternalFailure
+		return false, err
 	}
-	defer resp.Body.Close()
+	// Record new dashboard UID in local config file
+	configContext.SetNewResource(watcherUid, dbClient.FilePath)
+	dbClient.Uid = watcherUid
+	gc.claimWatcher(configContext, dbClient)
+	gc.Logger.Info(
+		"Watcher dashboard created",
+		slog.String("path", dbClient.FilePath),
+		slog.String("url", fmt.Sprintf("%s/d/%s", gc.Url, watcherUid)))
+	return true, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return false, nil
+// Deletes the watchers created by a session that failed to start
+// Watchers reused from the config are kept since they may hold unsaved changes
+func (gc *GrafanaClient) removeCreatedWatchers(
+	ctx context.Context,
+	configContext gcontext.GConfigContext,
+	created []*GrafanaDashboardClient,
+) {
+	// The session may have failed because ctx ended, the cleanup still runs
+	ctx = context.WithoutCancel(ctx)
+	for _, dbClient := range created {
+		if err := gc.DeleteWatcherDashboard(ctx, dbClient); err != nil && err != ErrDashboardNotFound {
+			gc.Logger.Error(
+				"error removing watcher dashboard",
+				slog.String("path", dbClient.FilePath),
+				slog.String("uid", dbClient.Uid),
+				slog.String("error", err.Error()))
+			continue
+		}
+		if err := configContext.ClearResourceDashboardByPath(dbClient.FilePath); err != nil {
+			gc.Logger.Error(
+				"error clearing watcher from config",
+				slog.String("path", dbClient.FilePath),
+				slog.String("error", err.Error()))
+		}
+		dbClient.Uid = ""
 	}
+}
 
-	return true, nil
+// Records this process as the watcher owner, ownership is only informational
+// so failures are logged and watching continues
+func (gc *GrafanaClient) claimWatcher(configContext gcontext.GConfigContext, dbClient *GrafanaDashboardClient) {
+	if err := configContext.ClaimResource(dbClient.FilePath); err != nil {
+		gc.Logger.Warn(
+			"error recording watcher owner",
+			slog.String("path", dbClient.FilePath),
+			slog.String("error", err.Error()))
+	}
 }
 
-func (gcd *GrafanaDashboardClient) setAndCompareDashboardVersion() {
-	gcd.Mutex.Lock()
-	defer gcd.Mutex.Unlock()
-	if gcd.LastVersion != 0 {
-		gcd.IsDashboardChanged = gcd.LastVersion != int(gcd.Dashboard.Meta["version"].(float64))
+// Polls a single watcher and saves detected changes to disk
+// Watchers that fail fatally or exhaust their retries are marked as failed and skipped
+func (gc *GrafanaClient) pollWatcherDashboard(ctx context.Context, dbClient *GrafanaDashboardClient) {
+	if err := gc.GetDashboardChanges(ctx, dbClient); err != nil {
+		dbClient.Err = gc.pollFailed(&dbClient.retry, dbClient.FilePath, err)
+		return
+	}
+	dbClient.retry.reset()
+	gc.pollLibraryPanels(ctx, dbClient)
+	if dbClient.IsDashboardChanged {
+		gc.Logger.Info("Version change detected, saving changes...", slog.String("path", dbClient.FilePath))
+		gc.logDashboardChanges(dbClient)
+		if err := gc.SaveChangesToDisk(ctx, dbClient); err != nil {
+			gc.Logger.Error(err.Error(), slog.String("path", dbClient.FilePath))
+			// Stop watching rather than lose either side of a conflict
+			if errors.Is(err, ErrDashboardConflict) {
+				dbClient.Err = err
+			}
+		}
 	}
-	gcd.LastVersion = int(gcd.Dashboard.Meta["version"].(float64))
+}
+
+// Logs a short summary of the changes found in the latest version
+func (gc *GrafanaClient) logDashboardChanges(dbClient *GrafanaDashboardClient) {
+	const maxChanges = 20
+	for i, change := range dbClient.Changes {
+		if i == maxChanges {
+			gc.Logger.Info(
+				fmt.Sprintf("... and %d more changes", len(dbClient.Changes)-maxChanges),
+				slog.String("path", dbClient.FilePath))
+			break
+		}
+		gc.Logger.Info(change.Summary, slog.String("path", dbClient.FilePath), slog.String("change", string(change.Type)))
+	}
+	dbClient.Changes = nil
 }
 
 // Main watcher process
@@ -197,192 +437,321 @@ func (gc *GrafanaClient) StartWatchingDashboard(
 	configContext gcontext.GConfigContext,
 	dbClient *GrafanaDashboardClient,
 ) error {
-	// Check for existing watcher dashboards
-	watcherUid := configContext.GetResourceByPath(dbClient.FilePath)
-	if watcherUid == "" {
-		gc.Logger.Info("Creating watcher dashboard...")
-		// Deploy temp dashboard to watch
-		watcherUid, err := gc.generateTempDashboard(dbClient.FilePath, dbClient.FolderUid)
+	return gc.StartWatchingDashboards(ctx, configContext, []*GrafanaDashboardClient{dbClient})
+}
+
+// Watches many dashboards at once on a shared change source until ctx ends
+// Each dashboard gets its own watcher; a failing watcher is dropped while the
+// remaining watchers keep running
+func (gc *GrafanaClient) StartWatchingDashboards(
+	ctx context.Context,
+	configContext gcontext.GConfigContext,
+	dbClients []*GrafanaDashboardClient,
+) error {
+	// Watchers are created one at a time since each one writes to the config file
+	// A failure removes the watchers created so far rather than leave them behind
+	var created []*GrafanaDashboardClient
+	for _, dbClient := range dbClients {
+		isCreated, err := gc.initWatcherDashboard(ctx, configContext, dbClient)
+		if isCreated {
+			created = append(created, dbClient)
+		}
+		if err == nil {
+			var dashboardFileData []byte
+			if dashboardFileData, err = os.ReadFile(dbClient.FilePath); err == nil {
+				dbClient.recordSync(dashboardFileData)
+			}
+		}
 		if err != nil {
-			gc.Logger.Error(
-				"error creating dashboard",
-				slog.String("error", err.Error()))
-			return err
+			gc.removeCreatedWatchers(ctx, configContext, created)
+			return fmt.Errorf("starting watcher for %s: %w", dbClient.FilePath, err)
 		}
-		// Record new dashboard UID in local config file
-		configContext.SetNewResource(watcherUid, dbClient.FilePath)
-		dbClient.Uid = watcherUid
-		gc.Logger.Info(
-			"Watcher dashboard created",
-			slog.String("url", fmt.Sprintf("%s/d/%s", gc.Url, watcherUid)))
-	} else {
-		// Check if dashboard manually deleted by user
-		isDashboardExist, err := gc.isDashboardExist(watcherUid)
+	}
+
+	// Nil channels block forever, disabling local sync in the select below
+	var localEvents chan fsnotify.Event
+	var localErrors chan error
+	if gc.SyncLocalChanges {
+		localWatcher, err := gc.newLocalWatcher(dbClients)
 		if err != nil {
-			gc.Logger.Error(
-				"error checking for existing dashboard",
-				slog.String("uid", watcherUid),
-				slog.String("path", dbClient.FilePath),
-				slog.String("error", err.Error()))
+			gc.Logger.Error("error watching local dashboard files", slog.String("error", err.Error()))
 			return err
 		}
+		defer localWatcher.Close()
+		localEvents = localWatcher.Events
+		localErrors = localWatcher.Errors
+	}
 
-		if !isDashboardExist {
-			gc.Logger.Info("Error fetching watcher dashboard from Grafana")
-			gc.Logger.Info("Creating watcher dashboard...")
-
-			watcherUid, err = gc.generateTempDashboard(dbClient.FilePath, dbClient.FolderUid)
-			if err != nil {
-				gc.Logger.Error(
-					"error creating dashboard",
-					slog.String("error", err.Error()))
-				return err
-			}
-			configContext.SetNewResource(watcherUid, dbClient.FilePath)
-			dbClient.Uid = watcherUid
-			gc.Logger.Info(
-				"Watcher dashboard created",
-				slog.String("url", fmt.Sprintf("%s/d/%s", gc.Url, watcherUid)))
-		} else {
-			dbClient.Uid = watcherUid
-			gc.Logger.Info(
-				"Watcher dashboard found",
-				slog.String("url", fmt.Sprintf("%s/d/%s", gc.Url, watcherUid)))
-		}
+	uids := make([]string, 0, len(dbClients))
+	for _, dbClient := range dbClients {
+		uids = append(uids, dbClient.Uid)
 	}
 
-	// Listen to interrupt and term signals
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	// Sources stop with the loop
+	sourceCtx, cancelSource := context.WithCancel(ctx)
+	defer cancelSource()
+	changes, source, err := gc.startChangeSource(sourceCtx, uids)
+	if err != nil {
+		return err
+	}
 
-	maxRetry := 3
-	retry := 0
+	// Event sources can miss changes, ex: while reconnecting, poll now and then anyway
+	resync := time.NewTicker(gc.Interval * resyncIntervals)
+	defer resync.Stop()
 
-	// Start polling timer
-	ticker := time.NewTicker(gc.Interval)
-	defer ticker.Stop()
+	// Stopped sources are restarted with the retry backoff, a source failing
+	// right away would otherwise be reconnected in a busy loop
+	var reconnect <-chan time.Time
+	reconnects := 0
 
-	gc.Logger.Info("Watching...")
+	gc.Logger.Info("Watching...", slog.Int("dashboards", len(dbClients)))
 
 	for {
+		var polled []*GrafanaDashboardClient
 		select {
-		case <-ticker.C:
-			// make request
-			if err := gc.GetDashboardChanges(dbClient); err != nil {
-				if err != ErrNotThatSerious {
-					return err
-				}
-				gc.Logger.Info("error detected, attempting retry...", slog.Int("retry", retry))
-				if retry >= maxRetry {
-					gc.Logger.Error("max retries reached")
-					return ErrInternalFailure
-				} else {
-					retry += 1
-				}
+		case event, ok := <-changes:
+			if !ok {
+				delay := gc.retryPolicy().backoff(reconnects)
+				reconnects += 1
+				gc.Logger.Warn(
+					"Change source stopped, reconnecting...",
+					slog.String("source", source),
+					slog.Duration("delay", delay))
+				changes = nil
+				reconnect = time.After(delay)
+				break
 			}
-			if dbClient.IsDashboardChanged {
-				gc.Logger.Info("Version change detected, saving changes...")
-				if err := gc.SaveChangesToDisk(dbClient); err != nil {
-					gc.Logger.Error(err.Error())
+			reconnects = 0
+			for _, dbClient := range dbClients {
+				if event.Uid == "" || event.Uid == dbClient.Uid {
+					polled = append(polled, dbClient)
 				}
 			}
-		case sig := <-signals:
-			gc.Logger.Info(fmt.Sprintf("Received signal: %v", sig))
-			return ErrCleanShutdown
+		case <-reconnect:
+			// Fall back to the next best source, ex: the websocket dropped
+			reconnect = nil
+			if changes, source, err = gc.startChangeSource(sourceCtx, uids); err != nil {
+				return err
+			}
+			polled = dbClients
+		case <-resync.C:
+			if source != "poll" {
+				polled = dbClients
+			}
+		case event := <-localEvents:
+			gc.handleLocalEvent(ctx, event, dbClients)
+			gc.recordWatcherSyncs(configContext, dbClients)
+		case err := <-localErrors:
+			gc.Logger.Error("error watching local dashboard files", slog.String("error", err.Error()))
 		case <-ctx.Done():
 			gc.Logger.Info("Context cancelled")
 			return ctx.Err()
 		}
+		if len(polled) == 0 {
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, dbClient := range polled {
+			if dbClient.Err != nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				gc.pollWatcherDashboard(ctx, dbClient)
+			}()
+		}
+		wg.Wait()
+		gc.recordWatcherSyncs(configContext, polled)
+
+		errs := make([]error, len(dbClients))
+		for i, dbClient := range dbClients {
+			errs[i] = dbClient.Err
+		}
+		if err := watchersStopped(errs); err != nil {
+			return err
+		}
 	}
 }
 
-// Fetches dashboard schema at intervals to watch for any changes
-// Detected changes are saved in memory
-func (gc *GrafanaClient) GetDashboardChanges(dbClient *GrafanaDashboardClient) error {
-	apiUrl := fmt.Sprintf("%s/api/dashboards/uid/%s", gc.Url, dbClient.Uid)
-
-	resp, err := gc.createRequest(apiUrl, "GET", nil)
+// Fetches the dashboard and its meta data by uid
+func (gc *GrafanaClient) GetDashboard(ctx context.Context, uid string) (*GrafanaDashboard, error) {
+	dashboard, err := gc.api().GetDashboard(ctx, uid)
+	if gapi.IsNotFound(err) {
+		return nil, ErrDashboardNotFound
+	}
 	if err != nil {
 		gc.Logger.Error(
-			"error creating request",
+			"error fetching dashboard version",
+			slog.String("uid", uid),
 			slog.String("error", err.Error()),
 		)
-		return ErrInternalFailure
+		return nil, apiError(err)
 	}
-	defer resp.Body.Close()
+	return &GrafanaDashboard{Meta: dashboard.Meta, Dashboard: dashboard.Dashboard}, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// Fetches dashboard schema at intervals to watch for any changes
+// Detected changes are saved in memory
+func (gc *GrafanaClient) GetDashboardChanges(ctx context.Context, dbClient *GrafanaDashboardClient) error {
+	dashboard, err := gc.getWatcherDashboard(ctx, dbClient)
 	if err != nil {
+		// Watchers can briefly go missing while Grafana restarts
+		if err == ErrDashboardNotFound {
+			return retryable(err)
+		}
 		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		gc.Logger.Error(
-			"error fetching dashboard version",
-			slog.Int("status", resp.StatusCode),
-			slog.String("error", string(body)),
-		)
-		return ErrNotThatSerious
-	}
+	previous := dbClient.Dashboard.Dashboard
+	dbClient.Dashboard = *dashboard
+	dbClient.setAndCompareDashboardVersion()
 
-	if err := json.Unmarshal(body, &dbClient.Dashboard); err != nil {
-		gc.Logger.Error(
-			"error reading response body",
-			slog.String("error", err.Error()),
-		)
-		return ErrInternalFailure
+	// Summarize what changed since the last known version
+	if dbClient.IsDashboardChanged && previous != nil {
+		dbClient.Changes = gdiff.Compare(previous, RestoreLocalAttributes(previous, dashboard.Dashboard))
 	}
-
-	dbClient.setAndCompareDashboardVersion()
 	return nil
 }
 
 // Saves current state of dashboard to local json file
-func (gc *GrafanaClient) SaveChangesToDisk(dbClient *GrafanaDashboardClient) error {
-	var dashboard map[string]interface{}
+// Nothing is written unless Grafana has a version the file has not seen, local
+// edits made since the last sync are treated as a conflict
+func (gc *GrafanaClient) SaveChangesToDisk(ctx context.Context, dbClient *GrafanaDashboardClient) error {
+	if !dbClient.IsDashboardChanged {
+		return nil
+	}
 
 	dashboardFileData, _ := os.ReadFile(dbClient.FilePath)
-	if err := json.Unmarshal(dashboardFileData, &dashboard); err != nil {
+	dashboard, format, err := parseDashboardFile(dashboardFileData)
+	if err != nil {
 		return err
 	}
 
-	versionIncrement := dashboard["version"]
-	// Check for changed status again to avoid unnecessary version increments
-	if dbClient.IsDashboardChanged {
-		versionIncrement = dashboard["version"].(float64) + 1
+	isMerged := false
+	if dbClient.isLocalChanged(dashboardFileData) {
+		resolution, err := gc.resolveConflict(dbClient)
+		if err != nil {
+			return err
+		}
+
+		switch resolution {
+		case KeepLocal:
+			gc.Logger.Info("Keeping local changes", slog.String("path", dbClient.FilePath))
+			dbClient.IsDashboardChanged = false
+			return gc.UploadLocalChanges(ctx, dbClient)
+		case MergeBoth:
+			// Conflict regions are written into the model, not the resource
+			if format == DashboardV2 {
+				return fmt.Errorf("%w: merging is not supported for v2 dashboard files", ErrDashboardConflict)
+			}
+			base, err := unmarshalDashboard(dbClient.baseContent)
+			if err != nil {
+				return err
+			}
+			result := mergeDashboards(base, dashboard, dbClient.Dashboard.Dashboard)
+			if len(result.Conflicts) > 0 {
+				return gc.writeMergeConflicts(dbClient, result)
+			}
+			gc.Logger.Info("Merged local and remote changes", slog.String("path", dbClient.FilePath))
+			dbClient.Dashboard.Dashboard = result.Merged
+			isMerged = true
+		case KeepRemote:
+			gc.Logger.Info("Keeping remote changes", slog.String("path", dbClient.FilePath))
+		default:
+			return fmt.Errorf("unknown conflict resolution: %s", resolution)
+		}
 	}
 
-	dbClient.Dashboard.Dashboard["id"] = dashboard["id"]
-	dbClient.Dashboard.Dashboard["uid"] = dashboard["uid"]
-	dbClient.Dashboard.Dashboard["title"] = dashboard["title"]
-	dbClient.Dashboard.Dashboard["version"] = versionIncrement
-	dbClient.Dashboard.Dashboard["description"] = dashboard["description"]
+	localVersion, _ := dashboard["version"].(float64)
+
+	// Watcher attributes stay on the watcher, the file keeps its own
+	fileDashboard := RestoreLocalAttributes(dashboard, dbClient.Dashboard.Dashboard)
+	fileDashboard["version"] = localVersion + 1
+
+	// Dashboards keep working with the references when library panels are unreachable
+	libraryVersions, err := gc.saveLibraryPanels(ctx, fileDashboard, dbClient.FilePath)
+	if err != nil {
+		gc.Logger.Warn(
+			"error saving library panels, keeping references",
+			slog.String("path", dbClient.FilePath),
+			slog.String("error", err.Error()))
+	} else if libraryVersions != nil {
+		dbClient.libraryVersions = libraryVersions
+	}
 
-	dashboardJson, _ := json.MarshalIndent(dbClient.Dashboard.Dashboard, "", "\t")
-	err := os.WriteFile(dbClient.FilePath, dashboardJson, 0644)
+	dashboardJson, err := marshalDashboardFile(fileDashboard, format, dashboardFileData)
 	if err != nil {
 		return err
 	}
+	err = os.WriteFile(dbClient.FilePath, dashboardJson, 0644)
+	if err != nil {
+		return err
+	}
+	dbClient.recordSync(dashboardJson)
 	dbClient.IsDashboardChanged = false
+
+	// Merged result holds local edits the watcher has not seen yet
+	if isMerged {
+		return gc.UploadLocalChanges(ctx, dbClient)
+	}
 	return nil
 }
 
-func (gc *GrafanaClient) DeleteWatcherDashboard(dbClient *GrafanaDashboardClient) error {
-	apiUrl := fmt.Sprintf("%s/api/dashboards/uid/%s", gc.Url, dbClient.Uid)
-	resp, err := gc.createRequest(apiUrl, "DELETE", nil)
+// Saves the latest watcher state to its local file outside of a watch session
+// Returns false when the file already matches the watcher
+// Files edited since the last recorded sync, or without one, are never
+// overwritten, see NewWatcherClient
+func (gc *GrafanaClient) SaveWatcherToDisk(ctx context.Context, dbClient *GrafanaDashboardClient) (bool, error) {
+	return gc.saveWatcherToDisk(ctx, dbClient, false)
+}
+
+// Saves the latest watcher state to its local file, dropping local edits
+// Only for an explicit choice of the user, see SaveWatcherToDisk
+func (gc *GrafanaClient) OverwriteWithWatcher(ctx context.Context, dbClient *GrafanaDashboardClient) (bool, error) {
+	return gc.saveWatcherToDisk(ctx, dbClient, true)
+}
+
+func (gc *GrafanaClient) saveWatcherToDisk(ctx context.Context, dbClient *GrafanaDashboardClient, isOverwrite bool) (bool, error) {
+	dashboardFileData, err := os.ReadFile(dbClient.FilePath)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer resp.Body.Close()
+	localDashboard, format, err := parseDashboardFile(dashboardFileData)
+	if err != nil {
+		return false, err
+	}
+	dbClient.Format = format
 
-	body, err := io.ReadAll(resp.Body)
+	dashboard, err := gc.getWatcherDashboard(ctx, dbClient)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if reflect.DeepEqual(RestoreLocalAttributes(localDashboard, dashboard.Dashboard), localDashboard) {
+		return false, nil
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("%s", string(body))
+	if isOverwrite {
+		dbClient.recordSync(dashboardFileData)
+	} else if dbClient.localHash == "" {
+		return false, fmt.Errorf("%w: no sync of %s recorded, local edits cannot be told apart", ErrDashboardConflict, dbClient.FilePath)
+	} else if dbClient.isLocalChanged(dashboardFileData) {
+		return false, fmt.Errorf("%w: %s changed since the last sync", ErrDashboardConflict, dbClient.FilePath)
 	}
 
-	return nil
+	dbClient.Dashboard = *dashboard
+	dbClient.IsDashboardChanged = true
+	return true, gc.SaveChangesToDisk(ctx, dbClient)
+}
+
+func (gc *GrafanaClient) DeleteWatcherDashboard(ctx context.Context, dbClient *GrafanaDashboardClient) error {
+	if gc.resourceApi(ctx) != nil {
+		return gc.deleteDashboardResource(ctx, dbClient.Uid)
+	}
+	err := gc.api().DeleteDashboard(ctx, dbClient.Uid)
+	if gapi.IsNotFound(err) {
+		return ErrDashboardNotFound
+	}
+	return apiError(err)
 }
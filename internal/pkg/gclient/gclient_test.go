@@ -1,26 +1,32 @@
 package gclient
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"slices"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alex067/gsync/internal/pkg/gapi"
+	"github.com/alex067/gsync/internal/pkg/gchaos"
 	"github.com/alex067/gsync/internal/pkg/gcontext"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
-	"github.com/docker/go-connections/nat"
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+	"github.com/alex067/gsync/internal/pkg/grafanatest"
+	"golang.org/x/net/websocket"
 )
 
 var (
@@ -29,276 +35,1970 @@ var (
 	newContext    gcontext.GContext
 )
 
-func init() {
-	if _, ok := os.LookupEnv("DOCKER_API_VERSION"); !ok {
-		os.Setenv("DOCKER_API_VERSION", "1.43")
-	}
-}
-
-func startGrafanaContainer(t *testing.T) {
+// Creates a context for the fake Grafana with its config file in a temp directory
+// Returns a copy of the test dashboard to watch
+func newTestContext(t *testing.T, server *grafanatest.Server) string {
 	t.Helper()
 
-	ctx := context.Background()
-	dockerCli, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		t.Fatalf("error initializing docker client: %s", err)
+	dir := t.TempDir()
+	gcf = gcontext.GConfigFile{Base: dir, Directory: ".gsync", Name: "config.yaml"}
+	if err := os.MkdirAll(filepath.Join(dir, gcf.Directory), 0755); err != nil {
+		t.Fatal(err)
 	}
+	configContext = gcontext.GConfigContext{}
 
-	reader, err := dockerCli.ImagePull(ctx, "docker.io/grafana/grafana:11.4.0", image.PullOptions{})
-	if err != nil {
-		t.Fatalf("error pulling grafana image: %s", err)
-	}
-	defer reader.Close()
+	newContext = gcontext.GContext{}
+	newContext.Url = server.URL
+	newContext.Name = "test"
+	newContext.Authentication.Grafana.Token = server.Token
+	newContext.Context.Dashboards.Path = dir
+	newContext.Context.Dashboards.GrafanaTenant = "1"
 
-	// Don't know why we need this but tests won't pass in Github otherwise lol
-	buf := new(strings.Builder)
-	_, err = io.Copy(buf, reader)
-	if err != nil {
-		t.Fatalf("error reading pull output: %s", err)
+	if err := configContext.CreateNewContext(newContext, gcf); err != nil {
+		t.Fatal("should create new context: ", err)
 	}
-
-	hostPortBinding := nat.PortBinding{
-		HostIP:   "0.0.0.0",
-		HostPort: "3000",
+	if err := configContext.SetCurrentContext(newContext.Name, true); err != nil {
+		t.Fatal(err)
 	}
 
-	containerPort, err := nat.NewPort("tcp", "3000")
+	_, filename, _, _ := runtime.Caller(0)
+	content, err := os.ReadFile(filepath.Join(filepath.Dir(filename), "test", "dashboard_test.json"))
 	if err != nil {
-		t.Fatalf("error mapping container port: %s", err)
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(dir, "dashboard_test.json")
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatal(err)
 	}
-	portBindings := nat.PortMap{containerPort: []nat.PortBinding{hostPortBinding}}
+	return filePath
+}
 
-	resp, err := dockerCli.ContainerCreate(
-		ctx,
-		&container.Config{
-			Image: "grafana/grafana:11.4.0",
-			Env: []string{
-				"GF_SECURITY_ADMIN_PASSWORD=admin",
-				"GF_SECURITY_ADMIN_USER=admin",
-			},
-			Healthcheck: &container.HealthConfig{},
+func newTestClient(server *grafanatest.Server) *GrafanaClient {
+	return &GrafanaClient{
+		Url:      server.URL,
+		TenantId: "1",
+		ApiKey:   server.Token,
+		Interval: 20 * time.Millisecond,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		HttpClient: &http.Client{
+			Timeout: 5 * time.Second,
 		},
-		&container.HostConfig{
-			PortBindings: portBindings,
-		}, nil, nil, "grafana",
-	)
-	if err != nil {
-		t.Fatalf("error creating grafana container: %s", err)
+		Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond},
 	}
+}
 
-	if err = dockerCli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		t.Fatalf("error starting grafana container: %s", err)
+// Waits for the condition, failing the test after a few seconds
+func waitFor(t *testing.T, message string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", message)
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
+}
+
+// Waits for the watcher to be created and polled once, edits made before the
+// first poll are the baseline
+func waitForWatcher(t *testing.T, server *grafanatest.Server) string {
+	t.Helper()
+	waitFor(t, "watcher dashboard", func() bool { return len(server.DashboardUids()) == 1 })
+	watcherUid := server.DashboardUids()[0]
+	waitFor(t, "first poll", func() bool {
+		return slices.Contains(server.Requests(), "GET /api/dashboards/uid/"+watcherUid)
+	})
+	return watcherUid
+}
 
-	// Wait for container to be ready
-	// Should expect 302 repsonse
-	interval := time.Second * 2
-	retries := 10
+// Starts watching in the background, the returned channel receives the exit error
+func startWatching(gc *GrafanaClient, ctx context.Context, dbClient *GrafanaDashboardClient) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- gc.StartWatchingDashboard(ctx, configContext, dbClient)
+	}()
+	return done
+}
 
-	httpClient := http.DefaultClient
-	req, _ := http.NewRequest("GET", "http://127.0.0.1:3000", nil)
+func TestWatchingDashboard(t *testing.T) {
+	t.Run("test watcher dashboard", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
 
-	for retry := 0; retry < retries; retry++ {
-		time.Sleep(interval)
-		t.Logf("pinging grafana... attempt: %d", retry)
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			t.Logf("error processing grafana healthcheck: %s", err)
-			continue
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := startWatching(gc, ctx, dbClient)
+
+		watcherUid := waitForWatcher(t, server)
+
+		// Edit the watcher as if saved in the UI
+		watcher := server.Dashboard(watcherUid)
+		watcher["timezone"] = "utc"
+		server.SaveDashboard(watcher, "")
+
+		waitFor(t, "saved changes", func() bool {
+			content, _ := os.ReadFile(dbClient.FilePath)
+			return strings.Contains(string(content), `"timezone": "utc"`)
+		})
+
+		cancel()
+		if exitErr := <-done; !errors.Is(exitErr, context.Canceled) {
+			t.Fatalf("expected context cancelled, got: %v", exitErr)
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			t.Logf("grafana healthcheck response: %d", resp.StatusCode)
-		} else {
-			t.Log("grafana container healthcheck passed!")
-			break
+		if configContext.GetResourceByPath(dbClient.FilePath) != watcherUid {
+			t.Fatalf("expected watcher %s recorded in the config", watcherUid)
 		}
-	}
+
+		if err := gc.GetDashboardChanges(context.Background(), dbClient); err != nil {
+			t.Fatalf("should get dashboard changes: %v", err)
+		}
+		// Version 2 means the watcher was created and edited once in Grafana
+		if dbClient.LastVersion != 2 {
+			t.Fatalf("expected dashboard version 2, got: %d", dbClient.LastVersion)
+		}
+		if dbClient.IsDashboardChanged {
+			t.Fatalf("expected dashboard changed to be false, got: %t", dbClient.IsDashboardChanged)
+		}
+
+		content, _ := os.ReadFile(dbClient.FilePath)
+		dashboard, _ := unmarshalDashboard(content)
+		if dashboard["uid"] == watcherUid || dashboard[watcherMetadataKey] != nil {
+			t.Fatalf("expected watcher attributes to stay on the watcher, got uid %v", dashboard["uid"])
+		}
+	})
+
+	t.Run("test watcher recovers from failed polls", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := startWatching(gc, ctx, dbClient)
+
+		watcherUid := waitForWatcher(t, server)
+		server.AddFault(grafanatest.Fault{
+			Method:     "GET",
+			PathPrefix: "/api/dashboards/uid/",
+			Status:     http.StatusBadGateway,
+			Times:      maxWatcherRetry,
+		})
+		watcher := server.Dashboard(watcherUid)
+		watcher["timezone"] = "utc"
+		server.SaveDashboard(watcher, "")
+
+		waitFor(t, "saved changes", func() bool {
+			content, _ := os.ReadFile(dbClient.FilePath)
+			return strings.Contains(string(content), `"timezone": "utc"`)
+		})
+		cancel()
+		<-done
+	})
+
+	t.Run("test watcher stops after max retries", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
+
+		server.AddFault(grafanatest.Fault{
+			Method:     "GET",
+			PathPrefix: "/api/dashboards/uid/",
+			Status:     http.StatusInternalServerError,
+		})
+		err := gc.StartWatchingDashboard(context.Background(), configContext, dbClient)
+		if !IsRetryable(err) || !strings.Contains(err.Error(), "max retries reached") {
+			t.Fatalf("expected retries to run out, got: %v", err)
+		}
+	})
+
+	t.Run("test failed start removes created watchers", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		filePath := newTestContext(t, server)
+		invalidFilePath := filepath.Join(filepath.Dir(filePath), "invalid.json")
+		if err := os.WriteFile(invalidFilePath, []byte("{"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		dbClients := []*GrafanaDashboardClient{{FilePath: filePath}, {FilePath: invalidFilePath}}
+		err := gc.StartWatchingDashboards(context.Background(), configContext, dbClients)
+		if err == nil || !strings.Contains(err.Error(), invalidFilePath) {
+			t.Fatalf("expected error starting %s, got: %v", invalidFilePath, err)
+		}
+		if uids := server.DashboardUids(); len(uids) != 0 {
+			t.Fatalf("expected created watchers removed, found %v", uids)
+		}
+		if len(configContext.GetWatchedDashboards()) != 0 || dbClients[0].Uid != "" {
+			t.Fatalf("expected no watchers recorded, got %v", configContext.GetWatchedDashboards())
+		}
+	})
+
+	t.Run("test final save keeps local edits when grafana is unchanged", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
+		ctx := context.Background()
+
+		if _, err := gc.initWatcherDashboard(ctx, configContext, dbClient); err != nil {
+			t.Fatal(err)
+		}
+		content, _ := os.ReadFile(dbClient.FilePath)
+		dbClient.recordSync(content)
+		if err := gc.GetDashboardChanges(ctx, dbClient); err != nil {
+			t.Fatal(err)
+		}
+		editLocalTimezone(t, dbClient.FilePath, "browser")
+		before, _ := os.ReadFile(dbClient.FilePath)
+
+		// As on shutdown, poll once more and save whatever the watcher has
+		if err := gc.GetDashboardChanges(ctx, dbClient); err != nil {
+			t.Fatal(err)
+		}
+		if err := gc.SaveChangesToDisk(ctx, dbClient); err != nil {
+			t.Fatalf("expected nothing to save, got: %v", err)
+		}
+		if after, _ := os.ReadFile(dbClient.FilePath); string(after) != string(before) {
+			t.Fatal("expected the local edits kept")
+		}
+	})
+
+	t.Run("test invalid token is rejected", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		gc.ApiKey = "invalid"
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
+
+		if err := gc.StartWatchingDashboard(context.Background(), configContext, dbClient); err == nil {
+			t.Fatal("expected error creating the watcher")
+		}
+		if len(server.DashboardUids()) != 0 {
+			t.Fatal("expected no watcher dashboard")
+		}
+	})
 }
 
-func cleanup(t *testing.T) {
-	ctx := context.Background()
-	dockerCli, err := client.NewClientWithOpts(client.FromEnv)
+// Writes the test dashboard file with the given timezone, as an editor would
+func editLocalTimezone(t *testing.T, filePath, timezone string) {
+	t.Helper()
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dashboard, err := unmarshalDashboard(content)
 	if err != nil {
-		t.Fatalf("error initializing docker client: %s", err)
+		t.Fatal(err)
 	}
-	if err := dockerCli.ContainerRemove(ctx, "grafana", container.RemoveOptions{Force: true}); err != nil {
-		t.Fatalf("failed to kill grafana container: %v", err)
+	dashboard["timezone"] = timezone
+	content, _ = json.MarshalIndent(dashboard, "", "  ")
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatal(err)
 	}
+}
 
-	_, absConfigFilePath, _ := gcf.GetAbsolutePath()
-	err = os.Remove(absConfigFilePath)
-	if err != nil {
-		t.Fatal("error cleaning up config file: ", err)
+func countRequests(server *grafanatest.Server, request string) int {
+	count := 0
+	for _, served := range server.Requests() {
+		if served == request {
+			count += 1
+		}
 	}
+	return count
 }
 
-func generateServiceAccountToken(t *testing.T) string {
-	t.Helper()
+func TestLocalSync(t *testing.T) {
+	t.Run("test local edits are uploaded", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		gc.SyncLocalChanges = true
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
 
-	payload := map[string]interface{}{
-		"name":       "gsync-admin",
-		"role":       "Admin",
-		"isDisabled": false,
-	}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := startWatching(gc, ctx, dbClient)
+		watcherUid := waitForWatcher(t, server)
 
-	payloadJson, err := json.Marshal(payload)
-	if err != nil {
-		t.Fatalf("error constructing payload: %s", err)
-	}
+		editLocalTimezone(t, dbClient.FilePath, "browser")
+		waitFor(t, "uploaded changes", func() bool {
+			return server.Dashboard(watcherUid)["timezone"] == "browser"
+		})
+		if title, _ := server.Dashboard(watcherUid)["title"].(string); !strings.Contains(title, watcherUid) {
+			t.Fatalf("expected the watcher title kept, got %s", title)
+		}
+		cancel()
+		<-done
+	})
 
-	req, err := http.NewRequest("POST", "http://127.0.0.1:3000/api/serviceaccounts", bytes.NewBuffer(payloadJson))
-	if err != nil {
-		t.Fatalf("error constructing payload request: %s", err)
-	}
+	t.Run("test own writes are not uploaded", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		gc.SyncLocalChanges = true
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
 
-	req.SetBasicAuth("admin", "admin")
-	req.Header.Set("Content-Type", "application/json")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := startWatching(gc, ctx, dbClient)
+		watcherUid := waitForWatcher(t, server)
 
-	client := http.DefaultClient
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Fatalf("service account request error: %s", err)
+		watcher := server.Dashboard(watcherUid)
+		watcher["timezone"] = "utc"
+		server.SaveDashboard(watcher, "")
+		waitFor(t, "saved changes", func() bool {
+			content, _ := os.ReadFile(dbClient.FilePath)
+			return strings.Contains(string(content), `"timezone": "utc"`)
+		})
+		// Leave time for the file events of the save to be handled
+		time.Sleep(10 * gc.Interval)
+		cancel()
+		<-done
+
+		if saves := countRequests(server, "POST /api/dashboards/db"); saves != 1 {
+			t.Fatalf("expected only the watcher creation to be saved, got %d saves", saves)
+		}
+	})
+
+	// Starts a watcher, then edits it in Grafana and the file after the last poll
+	setupRace := func(t *testing.T) (*GrafanaClient, *grafanatest.Server, *GrafanaDashboardClient) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
+		ctx := context.Background()
+
+		if _, err := gc.initWatcherDashboard(ctx, configContext, dbClient); err != nil {
+			t.Fatal(err)
+		}
+		content, _ := os.ReadFile(dbClient.FilePath)
+		dbClient.recordSync(content)
+		if err := gc.GetDashboardChanges(ctx, dbClient); err != nil {
+			t.Fatal(err)
+		}
+		watcher := server.Dashboard(dbClient.Uid)
+		watcher["timezone"] = "utc"
+		server.SaveDashboard(watcher, "")
+		editLocalTimezone(t, dbClient.FilePath, "browser")
+		return gc, server, dbClient
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		t.Fatalf("failed to create service account: %d", resp.StatusCode)
+	t.Run("test uploads never overwrite newer watcher versions", func(t *testing.T) {
+		gc, server, dbClient := setupRace(t)
+		before, _ := os.ReadFile(dbClient.FilePath)
+
+		err := gc.UploadLocalChanges(context.Background(), dbClient)
+		if !errors.Is(err, ErrDashboardConflict) {
+			t.Fatalf("expected a conflict, got: %v", err)
+		}
+		if timezone := server.Dashboard(dbClient.Uid)["timezone"]; timezone != "utc" {
+			t.Fatalf("expected the Grafana timezone kept, got %v", timezone)
+		}
+		if after, _ := os.ReadFile(dbClient.FilePath); string(after) != string(before) {
+			t.Fatal("expected the local file untouched")
+		}
+	})
+
+	t.Run("test refused uploads go through the conflict resolver", func(t *testing.T) {
+		gc, server, dbClient := setupRace(t)
+		gc.ConflictResolver = func(string) (ConflictResolution, error) { return KeepLocal, nil }
+
+		if err := gc.UploadLocalChanges(context.Background(), dbClient); err != nil {
+			t.Fatalf("expected the local edits kept, got: %v", err)
+		}
+		if timezone := server.Dashboard(dbClient.Uid)["timezone"]; timezone != "browser" {
+			t.Fatalf("expected the local timezone uploaded, got %v", timezone)
+		}
+		// Created, edited in Grafana, then uploaded over the edit
+		if dbClient.LastVersion != 3 {
+			t.Fatalf("expected the upload recorded as version 3, got %d", dbClient.LastVersion)
+		}
+	})
+
+	t.Run("test reset overwrites newer watcher versions", func(t *testing.T) {
+		gc, server, dbClient := setupRace(t)
+
+		if err := gc.ResetWatcherToLocal(context.Background(), dbClient); err != nil {
+			t.Fatalf("expected the watcher reset, got: %v", err)
+		}
+		if timezone := server.Dashboard(dbClient.Uid)["timezone"]; timezone != "browser" {
+			t.Fatalf("expected the local timezone uploaded, got %v", timezone)
+		}
+	})
+}
+
+func TestSaveWatcherToDisk(t *testing.T) {
+	// Starts a watcher and saves a Grafana edit to it, returns the recorded sync
+	setup := func(t *testing.T) (*GrafanaClient, *grafanatest.Server, gcontext.GContextGrafanaResource) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
+		ctx := context.Background()
+
+		if _, err := gc.initWatcherDashboard(ctx, configContext, dbClient); err != nil {
+			t.Fatal(err)
+		}
+		content, _ := os.ReadFile(dbClient.FilePath)
+		dbClient.recordSync(content)
+		if err := gc.GetDashboardChanges(ctx, dbClient); err != nil {
+			t.Fatal(err)
+		}
+		watcher := server.Dashboard(dbClient.Uid)
+		watcher["timezone"] = "utc"
+		server.SaveDashboard(watcher, "")
+
+		return gc, server, gcontext.GContextGrafanaResource{
+			Uid:     dbClient.Uid,
+			Path:    dbClient.FilePath,
+			Hash:    dbClient.localHash,
+			Version: dbClient.LastVersion,
+		}
 	}
 
-	var serviceAccount struct {
-		ID int `json:"id"`
+	t.Run("test status reports the side that changed", func(t *testing.T) {
+		gc, _, resource := setup(t)
+		ctx := context.Background()
+
+		status := gc.GetWatcherStatus(ctx, resource)
+		if !status.SyncRecorded || status.LocalChanged || !status.RemoteChanged {
+			t.Fatalf("expected only Grafana changed, got %+v", status)
+		}
+		editLocalTimezone(t, resource.Path, "browser")
+		status = gc.GetWatcherStatus(ctx, resource)
+		if !status.LocalChanged || !status.RemoteChanged {
+			t.Fatalf("expected both sides changed, got %+v", status)
+		}
+		resource.Hash = ""
+		if status := gc.GetWatcherStatus(ctx, resource); status.SyncRecorded {
+			t.Fatal("expected no sync recorded")
+		}
+	})
+
+	t.Run("test saves watcher edits to an unchanged file", func(t *testing.T) {
+		gc, _, resource := setup(t)
+
+		isSaved, err := gc.SaveWatcherToDisk(context.Background(), NewWatcherClient(resource, ""))
+		if err != nil || !isSaved {
+			t.Fatalf("expected the watcher edits saved, got %v, %v", isSaved, err)
+		}
+		content, _ := os.ReadFile(resource.Path)
+		if !strings.Contains(string(content), `"timezone": "utc"`) {
+			t.Fatal("expected the watcher timezone in the file")
+		}
+	})
+
+	t.Run("test local edits since the last sync are kept", func(t *testing.T) {
+		gc, _, resource := setup(t)
+		editLocalTimezone(t, resource.Path, "browser")
+		before, _ := os.ReadFile(resource.Path)
+
+		_, err := gc.SaveWatcherToDisk(context.Background(), NewWatcherClient(resource, ""))
+		if !errors.Is(err, ErrDashboardConflict) {
+			t.Fatalf("expected a conflict, got %v", err)
+		}
+		if after, _ := os.ReadFile(resource.Path); string(after) != string(before) {
+			t.Fatal("expected the local file untouched")
+		}
+
+		// Chosen explicitly, the watcher changes replace the local edits
+		if _, err := gc.OverwriteWithWatcher(context.Background(), NewWatcherClient(resource, "")); err != nil {
+			t.Fatal(err)
+		}
+		if after, _ := os.ReadFile(resource.Path); !strings.Contains(string(after), `"timezone": "utc"`) {
+			t.Fatal("expected the watcher timezone in the file")
+		}
+	})
+
+	t.Run("test files without a recorded sync are kept", func(t *testing.T) {
+		gc, _, resource := setup(t)
+		resource.Hash = ""
+		before, _ := os.ReadFile(resource.Path)
+
+		_, err := gc.SaveWatcherToDisk(context.Background(), NewWatcherClient(resource, ""))
+		if !errors.Is(err, ErrDashboardConflict) {
+			t.Fatalf("expected a conflict, got %v", err)
+		}
+		if after, _ := os.ReadFile(resource.Path); string(after) != string(before) {
+			t.Fatal("expected the local file untouched")
+		}
+	})
+}
+
+func TestSyncFolder(t *testing.T) {
+	server := grafanatest.NewServer(t)
+	gc := newTestClient(server)
+	server.AddFolder(grafanatest.Folder{Uid: "infra", Title: "Infra"})
+	server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "CPU"}, "infra")
+	server.SaveDashboard(map[string]interface{}{"uid": "other", "title": "Other"}, "")
+
+	fw := &GrafanaFolderWatcher{
+		FolderUid:  "infra",
+		Options:    PullOptions{DashboardsPath: t.TempDir(), NamingScheme: "{slug}.json"},
+		Dashboards: make(map[string]*GrafanaDashboardClient),
 	}
-	if err = json.NewDecoder(resp.Body).Decode(&serviceAccount); err != nil {
-		t.Fatalf("failed to read service account result from body: %s", err)
+	if err := gc.SyncFolder(context.Background(), fw); err != nil {
+		t.Fatal(err)
 	}
-
-	tokenPayload := map[string]interface{}{
-		"name": "gsync-token",
-		"role": "Admin",
+	if len(fw.Dashboards) != 1 || fw.Dashboards["cpu"] == nil {
+		t.Fatalf("expected only the folder dashboard, got %v", fw.Dashboards)
 	}
 
-	tokenPayloadJson, err := json.Marshal(tokenPayload)
-	if err != nil {
-		t.Fatalf("error constructing payload: %s", err)
+	// Dashboards joining and leaving the folder are picked up by the next sync
+	server.SaveDashboard(map[string]interface{}{"uid": "memory", "title": "Memory"}, "infra")
+	server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "CPU"}, "")
+	if err := gc.SyncFolder(context.Background(), fw); err != nil {
+		t.Fatal(err)
+	}
+	if fw.Dashboards["memory"] == nil || fw.Dashboards["cpu"] != nil || !reflect.DeepEqual(fw.Removed, []string{"cpu"}) {
+		t.Fatalf("expected memory watched and cpu removed, got %v, removed %v", fw.Dashboards, fw.Removed)
 	}
+	if _, err := os.Stat(filepath.Join(fw.Options.DashboardsPath, "memory.json")); err != nil {
+		t.Fatalf("expected memory dashboard pulled: %v", err)
+	}
+}
 
-	req, err = http.NewRequest(
-		"POST",
-		fmt.Sprintf("http://127.0.0.1:3000/api/serviceaccounts/%d/tokens", serviceAccount.ID),
-		bytes.NewBuffer(tokenPayloadJson),
-	)
-	if err != nil {
-		t.Fatalf("error constructing payload request: %s", err)
+func TestDashboardFilePath(t *testing.T) {
+	dashboard := &GrafanaDashboard{
+		Meta: gapi.DashboardMeta{
+			Slug:        "pods-view",
+			FolderUid:   "abc",
+			FolderTitle: "Kubernetes Views",
+		},
+		Dashboard: map[string]interface{}{
+			"uid":   "k8s-pods",
+			"title": "Pods View",
+		},
 	}
 
-	req.SetBasicAuth("admin", "admin")
-	req.Header.Set("Content-Type", "application/json")
+	t.Run("test default naming scheme", func(t *testing.T) {
+		got, err := dashboardFilePath(PullOptions{DashboardsPath: "dashboards"}, dashboard)
+		want := filepath.Join("dashboards", "kubernetes-views", "pods-view.json")
+		if got != want || err != nil {
+			t.Errorf("got %s, %v, want %s", got, err, want)
+		}
+	})
 
-	resp, err = client.Do(req)
-	if err != nil {
-		t.Fatalf("service account token request error: %s", err)
-	}
-	defer resp.Body.Close()
+	t.Run("test custom naming scheme", func(t *testing.T) {
+		got, err := dashboardFilePath(PullOptions{DashboardsPath: "dashboards", NamingScheme: "{folderUid}/{uid}.json"}, dashboard)
+		want := filepath.Join("dashboards", "abc", "k8s-pods.json")
+		if got != want || err != nil {
+			t.Errorf("got %s, %v, want %s", got, err, want)
+		}
+	})
 
-	var result struct {
-		Key string `json:"key"`
-	}
+	t.Run("test titles never add directories", func(t *testing.T) {
+		traversal := &GrafanaDashboard{
+			Meta: gapi.DashboardMeta{FolderUid: "abc", FolderTitle: "../../etc"},
+			Dashboard: map[string]interface{}{
+				"uid":   "k8s-pods",
+				"title": "../../x",
+			},
+		}
+		got, err := dashboardFilePath(PullOptions{DashboardsPath: "dashboards", NamingScheme: "{folderTitle}/{title}.json"}, traversal)
+		want := filepath.Join("dashboards", "..-..-etc", "..-..-x.json")
+		if got != want || err != nil {
+			t.Errorf("got %s, %v, want %s", got, err, want)
+		}
 
-	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		t.Fatalf("failed to read token result from body: %s", err)
-	}
+		traversal.Dashboard["title"] = ".."
+		got, err = dashboardFilePath(PullOptions{DashboardsPath: "dashboards", NamingScheme: "{title}.json"}, traversal)
+		want = filepath.Join("dashboards", "k8s-pods.json")
+		if got != want || err != nil {
+			t.Errorf("got %s, %v, want %s", got, err, want)
+		}
+	})
 
-	return result.Key
+	t.Run("test naming schemes leaving the dashboards path are rejected", func(t *testing.T) {
+		_, err := dashboardFilePath(PullOptions{DashboardsPath: "dashboards", NamingScheme: "../{slug}.json"}, dashboard)
+		if !errors.Is(err, ErrPathOutsideDashboards) {
+			t.Errorf("expected path outside of the dashboards path, got %v", err)
+		}
+	})
 }
 
-func TestWatchingDashboard(t *testing.T) {
-	startGrafanaContainer(t)
-	token := generateServiceAccountToken(t)
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+func TestPullDashboard(t *testing.T) {
+	ctx := context.Background()
 
-	_, filename, _, _ := runtime.Caller(0)
-	dir := filepath.Dir(filename)
+	t.Run("test dashboards with the same slug get their own file", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu-a", "title": "CPU"}, "")
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu-b", "title": "CPU"}, "")
+		options := PullOptions{DashboardsPath: t.TempDir()}
 
-	gcf.Base = dir
-	gcf.Directory = "test"
-	gcf.Name = "config.yaml"
+		dashboardFiles := map[string]string{}
+		first := gc.PullDashboard(ctx, "cpu-a", options, dashboardFiles)
+		second := gc.PullDashboard(ctx, "cpu-b", options, dashboardFiles)
+		if first.Err != nil || second.Err != nil {
+			t.Fatalf("expected both dashboards pulled, got %v, %v", first.Err, second.Err)
+		}
+		if first.FilePath != filepath.Join(options.DashboardsPath, "cpu.json") ||
+			second.FilePath != filepath.Join(options.DashboardsPath, "cpu-cpu-b.json") {
+			t.Fatalf("expected separate files, got %s and %s", first.FilePath, second.FilePath)
+		}
+		content, _ := os.ReadFile(first.FilePath)
+		if dashboard, _ := unmarshalDashboard(content); dashboard["uid"] != "cpu-a" {
+			t.Fatalf("expected cpu-a kept in %s, got %v", first.FilePath, dashboard["uid"])
+		}
 
-	newContext.Url = "http://localhost:3000"
-	newContext.Name = "test"
-	newContext.Authentication.Grafana.Token = token
-	newContext.Context.Dashboards.Path = dir
-	newContext.Context.Dashboards.GrafanaTenant = "test"
+		// Pulling again updates the files found for each uid
+		again := gc.PullDashboard(ctx, "cpu-b", options, dashboardFiles)
+		if again.FilePath != second.FilePath || again.Status != "unchanged" {
+			t.Fatalf("expected %s unchanged, got %s %s", second.FilePath, again.FilePath, again.Status)
+		}
+	})
+
+	t.Run("test files owned by other dashboards are kept", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "CPU"}, "")
+		options := PullOptions{DashboardsPath: t.TempDir()}
+
+		existing := []byte(`{"uid": "other", "title": "CPU"}`)
+		existingPath := filepath.Join(options.DashboardsPath, "cpu.json")
+		if err := os.WriteFile(existingPath, existing, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(options.DashboardsPath, "cpu-cpu.json"), existing, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		result := gc.PullDashboard(ctx, "cpu", options, map[string]string{"other": existingPath})
+		if result.Err == nil {
+			t.Fatalf("expected an error without a free file name, got %s", result.FilePath)
+		}
+		if content, _ := os.ReadFile(existingPath); string(content) != string(existing) {
+			t.Fatalf("expected %s untouched, got %s", existingPath, content)
+		}
+	})
+
+	t.Run("test titles stay under the dashboards path", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "../../cpu"}, "")
+		options := PullOptions{DashboardsPath: filepath.Join(t.TempDir(), "dashboards"), NamingScheme: "{title}.json"}
+
+		result := gc.PullDashboard(ctx, "cpu", options, map[string]string{})
+		if result.Err != nil || filepath.Dir(result.FilePath) != options.DashboardsPath {
+			t.Fatalf("expected the file under %s, got %s, %v", options.DashboardsPath, result.FilePath, result.Err)
+		}
+	})
+}
 
-	err := configContext.CreateNewContext(newContext, gcf)
+// Edits the pulled file and bumps its version the way watcher sessions do
+func editPulledFile(t *testing.T, filePath string, key string, value interface{}, version int) {
+	t.Helper()
+	content, _ := os.ReadFile(filePath)
+	dashboard, err := unmarshalDashboard(content)
 	if err != nil {
-		t.Fatal("should create new context: ", err)
+		t.Fatal(err)
+	}
+	dashboard[key] = value
+	dashboard["version"] = version
+	content, _ = json.MarshalIndent(dashboard, "", "\t")
+	if err := os.WriteFile(filePath, content, 0644); err != nil {
+		t.Fatal(err)
 	}
+}
 
-	t.Run("test watcher dashboard", func(t *testing.T) {
-		gc := &GrafanaClient{
-			Url:      "http://127.0.0.1:3000",
-			TenantId: "local",
-			ApiKey:   token,
-			Interval: time.Duration(1) * time.Second,
-			Logger:   logger,
-			HttpClient: &http.Client{
-				Timeout: 60 * time.Second,
-			},
-		}
+func TestPushDashboard(t *testing.T) {
+	ctx := context.Background()
 
-		_, filename, _, _ := runtime.Caller(0)
-		dir := filepath.Dir(filename)
+	t.Run("test remote edits after a pull are refused", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "CPU"}, "")
+		options := PullOptions{DashboardsPath: t.TempDir()}
 
-		dbClient := &GrafanaDashboardClient{}
-		dbClient.FilePath = filepath.Join(dir, gcf.Directory, "dashboard_test.json")
+		pulled := gc.PullDashboard(ctx, "cpu", options, map[string]string{})
+		if pulled.Err != nil || pulled.Version != 1 {
+			t.Fatalf("expected version 1 pulled, got %d, %v", pulled.Version, pulled.Err)
+		}
+		synced := gcontext.GContextGrafanaResource{Uid: "cpu", Path: pulled.FilePath, Version: pulled.Version}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+		// The local version runs ahead of Grafana while the UI saves a new version
+		editPulledFile(t, pulled.FilePath, "timezone", "utc", 10)
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "CPU", "description": "edited in the UI"}, "")
 
-		done := make(chan error, 1)
-		// chan ensures the goroutine started and sends the signal in time
-		started := make(chan struct{}, 1)
+		result := gc.PushDashboard(ctx, pulled.FilePath, PushOptions{Synced: synced})
+		if result.Status != "refused" || !errors.Is(result.Err, ErrRemoteNewer) {
+			t.Fatalf("expected the push refused, got %s, %v", result.Status, result.Err)
+		}
+		if remote := server.Dashboard("cpu"); remote["description"] != "edited in the UI" || remote["timezone"] != nil {
+			t.Fatalf("expected the UI edit kept, got %v", remote)
+		}
 
-		go func() {
-			close(started)
-			done <- gc.StartWatchingDashboard(ctx, configContext, dbClient)
-		}()
+		result = gc.PushDashboard(ctx, pulled.FilePath, PushOptions{Synced: synced, Force: true})
+		if result.Err != nil || result.Status != "updated" || server.Dashboard("cpu")["timezone"] != "utc" {
+			t.Fatalf("expected the forced push to update Grafana, got %s, %v", result.Status, result.Err)
+		}
+	})
 
-		<-started
-		time.Sleep(2 * time.Second)
+	t.Run("test dry runs into new folders still diff", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "CPU"}, "")
+		options := PullOptions{DashboardsPath: t.TempDir()}
+		pulled := gc.PullDashboard(ctx, "cpu", options, map[string]string{})
+		editPulledFile(t, pulled.FilePath, "timezone", "utc", 1)
 
-		if err := syscall.Kill(syscall.Getpid(), syscall.SIGINT); err != nil {
-			t.Fatalf("failed to send interrupt signal: %v", err)
+		result := gc.PushDashboard(ctx, pulled.FilePath, PushOptions{DryRun: true, NewFolder: "team"})
+		if result.Err != nil || result.Status != "updated" || result.NewFolder != "team" || len(result.Changes) != 1 {
+			t.Fatalf("expected an update into the new folder, got %s %v, %v", result.Status, result.Changes, result.Err)
+		}
+		if saves := countRequests(server, "POST /api/dashboards/db"); saves != 0 {
+			t.Fatalf("expected nothing saved, got %d saves", saves)
 		}
+	})
+
+	t.Run("test pushes without remote edits are accepted", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "CPU"}, "")
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "CPU"}, "")
+		options := PullOptions{DashboardsPath: t.TempDir()}
 
-		exitErr := <-done
+		pulled := gc.PullDashboard(ctx, "cpu", options, map[string]string{})
+		synced := gcontext.GContextGrafanaResource{Uid: "cpu", Path: pulled.FilePath, Version: pulled.Version}
+		editPulledFile(t, pulled.FilePath, "timezone", "utc", 1)
 
-		if exitErr == nil {
-			t.Errorf("expected to receive shutdown signal")
+		result := gc.PushDashboard(ctx, pulled.FilePath, PushOptions{Synced: synced})
+		if result.Err != nil || result.Status != "updated" || result.RemoteVersion != 3 {
+			t.Fatalf("expected version 3 pushed, got %s %d, %v", result.Status, result.RemoteVersion, result.Err)
 		}
 
-		err := gc.GetDashboardChanges(dbClient)
+		synced.Version = result.RemoteVersion
+		editPulledFile(t, pulled.FilePath, "timezone", "browser", 3)
+		result = gc.PushDashboard(ctx, pulled.FilePath, PushOptions{Synced: synced})
+		if result.Err != nil || result.RemoteVersion != 4 || server.Dashboard("cpu")["timezone"] != "browser" {
+			t.Fatalf("expected version 4 pushed, got %d, %v", result.RemoteVersion, result.Err)
+		}
+	})
+}
+
+func TestFolderMirror(t *testing.T) {
+	ctx := context.Background()
+	newMirror := func(t *testing.T, mapping map[string]string) (*grafanatest.Server, *FolderMirror) {
+		server := grafanatest.NewServer(t)
+		server.AddFolder(grafanatest.Folder{Uid: "team", Title: "Team"})
+		server.AddFolder(grafanatest.Folder{Uid: "infra", Title: "Infra", ParentUid: "team"})
+		server.AddFolder(grafanatest.Folder{Uid: "slash", Title: "A/B"})
+		server.AddFolder(grafanatest.Folder{Uid: "dots", Title: ".."})
+		mirror, err := newTestClient(server).NewFolderMirror(ctx, mapping)
 		if err != nil {
-			t.Fatalf("should get dashboard changes: %v", err)
+			t.Fatal(err)
 		}
+		return server, mirror
+	}
 
-		// Version 1 means we have successfully watched for the temp dashboard in Grafana
-		if dbClient.LastVersion != 1 {
-			t.Fatalf("expected dashboard version 1, got: %d", dbClient.LastVersion)
+	t.Run("test nested folders map to nested directories", func(t *testing.T) {
+		_, mirror := newMirror(t, nil)
+		folderUid, err := mirror.FolderUidForDir(ctx, filepath.Join("Team", "Infra"), false)
+		if err != nil || folderUid != "infra" {
+			t.Fatalf("expected infra, got %s, %v", folderUid, err)
+		}
+		if dir, ok := mirror.DirForFolder("infra"); !ok || dir != filepath.Join("Team", "Infra") {
+			t.Fatalf("expected Team/Infra, got %s", dir)
 		}
+		if folderUid, err := mirror.FolderUidForDir(ctx, ".", false); err != nil || folderUid != "" {
+			t.Fatalf("expected the General folder for the root, got %s, %v", folderUid, err)
+		}
+	})
 
-		if dbClient.IsDashboardChanged {
-			t.Fatalf("expected dashboard changed to be false, got: %t", dbClient.IsDashboardChanged)
+	t.Run("test titles never add directories", func(t *testing.T) {
+		_, mirror := newMirror(t, nil)
+		if dir, _ := mirror.DirForFolder("slash"); dir != "A-B" {
+			t.Fatalf("expected A-B, got %s", dir)
 		}
+		if dir, _ := mirror.DirForFolder("dots"); dir != "dots" {
+			t.Fatalf("expected the uid as directory, got %s", dir)
+		}
+	})
 
-		err = gc.SaveChangesToDisk(dbClient)
-		if err != nil {
-			t.Fatalf("should save changes to disk: %v", err)
+	t.Run("test mappings take precedence over titles", func(t *testing.T) {
+		_, mirror := newMirror(t, map[string]string{"platform/": "infra"})
+		if folderUid, err := mirror.FolderUidForDir(ctx, "platform", false); err != nil || folderUid != "infra" {
+			t.Fatalf("expected infra, got %s, %v", folderUid, err)
+		}
+		if dir, _ := mirror.DirForFolder("infra"); dir != "platform" {
+			t.Fatalf("expected platform, got %s", dir)
 		}
+	})
 
-		t.Cleanup(func() {
-			cleanup(t)
-		})
+	t.Run("test missing folders are created level by level", func(t *testing.T) {
+		server, mirror := newMirror(t, nil)
+		dir := filepath.Join("Team", "Apps", "Web")
+		if _, err := mirror.FolderUidForDir(ctx, dir, false); !errors.Is(err, ErrFolderNotFound) {
+			t.Fatalf("expected folder not found without create, got %v", err)
+		}
+		if creates := countRequests(server, "POST /api/folders"); creates != 0 {
+			t.Fatalf("expected no folders created, got %d", creates)
+		}
+
+		folderUid, err := mirror.FolderUidForDir(ctx, dir, true)
+		if err != nil || folderUid == "" {
+			t.Fatalf("expected a new folder, got %s, %v", folderUid, err)
+		}
+		if creates := countRequests(server, "POST /api/folders"); creates != 2 {
+			t.Fatalf("expected Apps and Web created, got %d creates", creates)
+		}
+		if again, _ := mirror.FolderUidForDir(ctx, dir, true); again != folderUid {
+			t.Fatalf("expected the created folder reused, got %s", again)
+		}
+	})
+
+	t.Run("test directories that are not folder titles are rejected", func(t *testing.T) {
+		server, mirror := newMirror(t, nil)
+		for _, dir := range []string{"...", filepath.Join("Team", " spaced "), filepath.Join("..", "shared")} {
+			for _, create := range []bool{false, true} {
+				_, err := mirror.FolderUidForDir(ctx, dir, create)
+				if err == nil || errors.Is(err, ErrFolderNotFound) {
+					t.Fatalf("expected %q rejected, got %v", dir, err)
+				}
+			}
+		}
+		if creates := countRequests(server, "POST /api/folders"); creates != 0 {
+			t.Fatalf("expected no folders created, got %d", creates)
+		}
+	})
+}
+
+func TestRestoreLocalAttributes(t *testing.T) {
+	local := map[string]interface{}{
+		"uid":     "k8s-pods",
+		"title":   "Pods View",
+		"version": float64(3),
+	}
+	remote := map[string]interface{}{
+		"uid":     "xyz",
+		"title":   "Pods View (Gsync xyz)",
+		"version": float64(7),
+	}
+	setWatcherAttributes(remote, "xyz", newWatcherMetadata("pods.json"))
+
+	t.Run("test watcher marker is stripped", func(t *testing.T) {
+		got := RestoreLocalAttributes(local, remote)
+		if !reflect.DeepEqual(got, local) {
+			t.Errorf("got %v, want %v", got, local)
+		}
+		if _, ok := readWatcherMetadata(remote); !ok {
+			t.Errorf("watcher metadata removed from the watcher model")
+		}
+	})
+
+	t.Run("test local tags are kept", func(t *testing.T) {
+		taggedLocal := copyDashboard(local)
+		taggedLocal["tags"] = []interface{}{"k8s"}
+		taggedRemote := copyDashboard(remote)
+		taggedRemote["tags"] = []interface{}{"k8s", WatcherTag}
+
+		got := RestoreLocalAttributes(taggedLocal, taggedRemote)
+		if !reflect.DeepEqual(got, taggedLocal) {
+			t.Errorf("got %v, want %v", got, taggedLocal)
+		}
+	})
+}
+
+func TestAlertRuleFile(t *testing.T) {
+	ruleFilePath := filepath.Join(t.TempDir(), "cpu.yaml")
+	ruleYaml := "uid: cpu-high\ntitle: CPU high\nfolderUID: alerts\nruleGroup: node\nfor: 5m\nlabels:\n  severity: page\n"
+	if err := os.WriteFile(ruleFilePath, []byte(ruleYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("test watcher edits are written back", func(t *testing.T) {
+		localRule, err := ReadAlertRuleFile(ruleFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		watcherRule := copyDashboard(localRule)
+		watcherRule["uid"] = "abc"
+		watcherRule["title"] = "CPU high (Gsync abc)"
+		watcherRule["ruleGroup"] = watcherRuleGroup
+		watcherRule["isPaused"] = true
+		watcherRule["updated"] = "2024-01-01T00:00:00Z"
+		watcherRule["for"] = "10m"
+
+		arClient := &GrafanaAlertRuleClient{FilePath: ruleFilePath, Rule: watcherRule}
+		if err := (&GrafanaClient{}).SaveAlertRuleToDisk(arClient); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ReadAlertRuleFile(ruleFilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := copyDashboard(localRule)
+		want["for"] = "10m"
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestContactPointSecrets(t *testing.T) {
+	remote := []GrafanaContactPoint{{
+		Uid:  "slack-1",
+		Name: "On Call",
+		Type: "slack",
+		Settings: map[string]interface{}{
+			"recipient": "#alerts",
+			"url":       redactedValue,
+			"token":     redactedValue,
+		},
+	}}
+	local := &ContactPointFile{
+		Name: "On Call",
+		Integrations: []GrafanaContactPoint{{
+			Uid:      "slack-1",
+			Type:     "slack",
+			Settings: map[string]interface{}{"token": "${SLACK_TOKEN}"},
+		}},
+	}
+
+	t.Run("test redacted secrets become placeholders", func(t *testing.T) {
+		pulled := toContactPointFile("On Call", remote, local)
+		settings := pulled.Integrations[0].Settings
+		expected := map[string]interface{}{
+			"recipient": "#alerts",
+			"url":       "${GSYNC_SECRET_ON_CALL_SLACK_URL}",
+			"token":     "${SLACK_TOKEN}",
+		}
+		if !reflect.DeepEqual(settings, expected) {
+			t.Fatalf("expected %v, got %v", expected, settings)
+		}
+	})
+
+	t.Run("test placeholders are filled from the environment", func(t *testing.T) {
+		t.Setenv("SLACK_TOKEN", "xoxb-1")
+		settings := map[string]interface{}{"token": "${SLACK_TOKEN}", "url": "${GSYNC_SECRET_ON_CALL_SLACK_URL}"}
+
+		if _, err := expandSettings(settings, false); !errors.Is(err, ErrMissingSecret) {
+			t.Fatalf("expected missing secret error, got %v", err)
+		}
+
+		expanded, err := expandSettings(settings, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]interface{}{"token": "xoxb-1", "url": redactedValue}
+		if !reflect.DeepEqual(expanded, expected) {
+			t.Fatalf("expected %v, got %v", expected, expanded)
+		}
+	})
+}
+
+func TestPushContactPoint(t *testing.T) {
+	t.Run("test dry runs report missing secrets", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		filePath := ContactPointFilePath(t.TempDir(), "On Call")
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		local := &ContactPointFile{
+			Name: "On Call",
+			Integrations: []GrafanaContactPoint{{
+				Type:     "slack",
+				Settings: map[string]interface{}{"url": "${GSYNC_SECRET_ON_CALL_SLACK_URL}"},
+			}},
+		}
+		if err := writeResourceFile(filePath, contactPointModel(local)); err != nil {
+			t.Fatal(err)
+		}
+
+		result := gc.PushContactPoint(context.Background(), filePath, true)
+		if !errors.Is(result.Err, ErrMissingSecret) {
+			t.Fatalf("expected missing secret error, got %v", result.Err)
+		}
+		if creates := countRequests(server, "POST /api/v1/provisioning/contact-points"); creates != 0 {
+			t.Fatalf("expected nothing created in a dry run, got %d creates", creates)
+		}
+	})
+}
+
+func TestWatchingPolicy(t *testing.T) {
+	// Writes the local policy tree next to the test context, returns the watcher client
+	setup := func(t *testing.T, server *grafanatest.Server) *GrafanaPolicyClient {
+		dir := filepath.Dir(newTestContext(t, server))
+		pClient := &GrafanaPolicyClient{
+			FilePath:   PolicyFilePath(dir),
+			BackupPath: filepath.Join(dir, "policies.backup.yaml"),
+		}
+		if err := writeResourceFile(pClient.FilePath, map[string]interface{}{"receiver": "team"}); err != nil {
+			t.Fatal(err)
+		}
+		return pClient
+	}
+
+	t.Run("test live tree is backed up, watched and restored", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		pClient := setup(t, server)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan error, 1)
+		go func() {
+			done <- gc.StartWatchingPolicy(ctx, configContext, pClient)
+		}()
+
+		waitFor(t, "local tree in Grafana", func() bool { return server.Policy()["receiver"] == "team" })
+		backup, err := ReadPolicyFile(pClient.BackupPath)
+		if err != nil || backup["receiver"] != "grafana-default-email" {
+			t.Fatalf("expected the live tree backed up, got %v, %v", backup, err)
+		}
+		if resource, _ := configContext.GetKindResource(gcontext.PolicyResource, pClient.FilePath); resource.Backup != pClient.BackupPath {
+			t.Fatalf("expected the backup recorded, got %q", resource.Backup)
+		}
+
+		server.SetPolicy(map[string]interface{}{"receiver": "team", "group_by": []interface{}{"alertname"}})
+		waitFor(t, "saved changes", func() bool {
+			local, _ := ReadPolicyFile(pClient.FilePath)
+			return local["group_by"] != nil
+		})
+		cancel()
+		<-done
+
+		if err := gc.RestorePolicyBackup(context.Background(), pClient); err != nil {
+			t.Fatal(err)
+		}
+		if receiver := server.Policy()["receiver"]; receiver != "grafana-default-email" {
+			t.Fatalf("expected the live tree restored, got %v", receiver)
+		}
+		if _, err := os.Stat(pClient.BackupPath); !os.IsNotExist(err) {
+			t.Fatal("expected the backup removed")
+		}
+	})
+
+	t.Run("test leftover backups are reused", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		pClient := setup(t, server)
+
+		// Left by a session that never restored, Grafana holds its edits
+		if err := writeResourceFile(pClient.BackupPath, map[string]interface{}{"receiver": "grafana-default-email"}); err != nil {
+			t.Fatal(err)
+		}
+		// Config writes outside of the home directory fail, the record is kept in memory
+		configContext.SetNewKindResource(gcontext.PolicyResource, "policies", pClient.FilePath)
+		configContext.SetKindResourceBackup(gcontext.PolicyResource, pClient.FilePath, pClient.BackupPath)
+		server.SetPolicy(map[string]interface{}{"receiver": "interrupted"})
+
+		if err := gc.initWatcherPolicy(context.Background(), configContext, pClient); err != nil {
+			t.Fatal(err)
+		}
+		if puts := countRequests(server, "PUT /api/v1/provisioning/policies"); puts != 0 {
+			t.Fatalf("expected the live tree kept, got %d puts", puts)
+		}
+		if local, _ := ReadPolicyFile(pClient.FilePath); local["receiver"] != "interrupted" {
+			t.Fatalf("expected the interrupted session edits saved, got %v", local)
+		}
+		if backup, _ := ReadPolicyFile(pClient.BackupPath); backup["receiver"] != "grafana-default-email" {
+			t.Fatalf("expected the original backup kept, got %v", backup)
+		}
+	})
+}
+
+func TestInlineLibraryPanels(t *testing.T) {
+	dashboard := map[string]interface{}{
+		"panels": []interface{}{
+			map[string]interface{}{
+				"id":           float64(1),
+				"gridPos":      map[string]interface{}{"x": float64(0), "y": float64(0)},
+				"libraryPanel": map[string]interface{}{"uid": "cpu", "name": "CPU"},
+			},
+			map[string]interface{}{
+				"type":      "row",
+				"collapsed": true,
+				"panels": []interface{}{
+					map[string]interface{}{
+						"id":           float64(3),
+						"libraryPanel": map[string]interface{}{"uid": "cpu", "name": "CPU"},
+					},
+				},
+			},
+		},
+	}
+
+	if refs := libraryPanelRefs(dashboard); !reflect.DeepEqual(refs, []string{"cpu"}) {
+		t.Fatalf("expected library panel refs [cpu], got %v", refs)
+	}
+
+	inlineLibraryPanels(dashboard, map[string]*GrafanaLibraryPanel{
+		"cpu": {
+			Uid:   "cpu",
+			Name:  "CPU",
+			Model: map[string]interface{}{"id": float64(9), "type": "timeseries", "title": "CPU"},
+		},
+	})
+
+	panels := dashboard["panels"].([]interface{})
+	expected := map[string]interface{}{
+		"id":      float64(1),
+		"gridPos": map[string]interface{}{"x": float64(0), "y": float64(0)},
+		"type":    "timeseries",
+		"title":   "CPU",
+	}
+	if !reflect.DeepEqual(panels[0], expected) {
+		t.Fatalf("expected %v, got %v", expected, panels[0])
+	}
+	nested := panels[1].(map[string]interface{})["panels"].([]interface{})[0].(map[string]interface{})
+	if _, ok := nested["libraryPanel"]; ok || nested["id"] != float64(3) {
+		t.Fatalf("expected nested panel to be inlined with its own id, got %v", nested)
+	}
+	if len(libraryPanelRefs(dashboard)) != 0 {
+		t.Fatal("expected no library panel refs after inlining")
+	}
+}
+
+func TestLibraryPanelFiles(t *testing.T) {
+	libraryPanelFiles := func(t *testing.T, dir string) []string {
+		t.Helper()
+		filePaths, err := IndexLibraryPanelFiles(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range filePaths {
+			filePaths[i] = filepath.Base(filePaths[i])
+		}
+		return filePaths
+	}
+	cpu := &GrafanaLibraryPanel{Uid: "cpu-1", Name: "CPU", Model: map[string]interface{}{"type": "timeseries"}}
+
+	t.Run("test panels sharing a name get their own files", func(t *testing.T) {
+		dir := t.TempDir()
+		other := &GrafanaLibraryPanel{Uid: "cpu-2", Name: "CPU", Model: map[string]interface{}{"type": "stat"}}
+		for _, libraryPanel := range []*GrafanaLibraryPanel{cpu, other} {
+			if _, status, err := saveLibraryPanelToDisk(dir, libraryPanel); err != nil || status != "created" {
+				t.Fatalf("expected %s created, got %s, %v", libraryPanel.Uid, status, err)
+			}
+		}
+		if files := libraryPanelFiles(t, dir); !reflect.DeepEqual(files, []string{"cpu-cpu-1.json", "cpu-cpu-2.json"}) {
+			t.Fatalf("expected a file per panel, got %v", files)
+		}
+	})
+
+	t.Run("test renamed panels move their file", func(t *testing.T) {
+		dir := t.TempDir()
+		if _, _, err := saveLibraryPanelToDisk(dir, cpu); err != nil {
+			t.Fatal(err)
+		}
+		renamed := *cpu
+		renamed.Name = "CPU usage"
+		filePath, status, err := saveLibraryPanelToDisk(dir, &renamed)
+		if err != nil || status != "updated" {
+			t.Fatalf("expected the renamed panel updated, got %s, %v", status, err)
+		}
+		if files := libraryPanelFiles(t, dir); !reflect.DeepEqual(files, []string{"cpu-usage-cpu-1.json"}) {
+			t.Fatalf("expected only the renamed file, got %v", files)
+		}
+		if local, _ := ReadLibraryPanelFile(filePath); local.Name != "CPU usage" {
+			t.Fatalf("expected the new name saved, got %v", local)
+		}
+	})
+
+	t.Run("test files named without the uid are moved", func(t *testing.T) {
+		dir := t.TempDir()
+		legacyPath := filepath.Join(dir, LibraryPanelDirectory, "cpu.json")
+		if err := os.MkdirAll(filepath.Dir(legacyPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeLibraryPanelFile(legacyPath, cpu); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := saveLibraryPanelToDisk(dir, cpu); err != nil {
+			t.Fatal(err)
+		}
+		if files := libraryPanelFiles(t, dir); !reflect.DeepEqual(files, []string{"cpu-cpu-1.json"}) {
+			t.Fatalf("expected the file moved to the uid name, got %v", files)
+		}
+	})
+}
+
+func TestDatasourceSecrets(t *testing.T) {
+	remote := &GrafanaDatasource{
+		Uid:              "prom",
+		Name:             "Prometheus",
+		Type:             "prometheus",
+		Url:              "http://prometheus:9090",
+		BasicAuth:        true,
+		BasicAuthUser:    "grafana",
+		SecureJsonFields: map[string]bool{"basicAuthPassword": true, "httpHeaderValue1": false},
+	}
+
+	provisioned := toProvisionedDatasource(remote, nil)
+	expected := map[string]interface{}{"basicAuthPassword": "${GSYNC_SECRET_PROMETHEUS_BASICAUTHPASSWORD}"}
+	if !reflect.DeepEqual(provisioned.SecureJsonData, expected) {
+		t.Fatalf("expected %v, got %v", expected, provisioned.SecureJsonData)
+	}
+
+	t.Run("test secret values are not compared", func(t *testing.T) {
+		local := provisioned
+		local.SecureJsonData = map[string]interface{}{"basicAuthPassword": "${PROM_PASSWORD}"}
+		if changes := gdiff.Compare(datasourceModel(local), datasourceModel(toProvisionedDatasource(remote, &local))); len(changes) != 0 {
+			t.Fatalf("expected no changes, got %v", changes)
+		}
+	})
+
+	t.Run("test updates keep stored secrets without a value", func(t *testing.T) {
+		secrets := map[string]interface{}{"basicAuthPassword": "${PROM_PASSWORD}"}
+		if _, err := expandSecureJsonData(secrets, false); !errors.Is(err, ErrMissingSecret) {
+			t.Fatalf("expected missing secret error, got %v", err)
+		}
+		expanded, err := expandSecureJsonData(secrets, true)
+		if err != nil || len(expanded) != 0 {
+			t.Fatalf("expected no secrets sent, got %v, %v", expanded, err)
+		}
+	})
+}
+
+func TestDashboardFileFormats(t *testing.T) {
+	t.Run("test v1 files are the dashboard model", func(t *testing.T) {
+		content := []byte(`{"uid": "abc", "title": "CPU", "version": 3}`)
+		dashboard, format, err := parseDashboardFile(content)
+		if err != nil || format != DashboardV1 || dashboard["uid"] != "abc" {
+			t.Fatalf("expected v1 dashboard abc, got %v, %s, %v", dashboard, format, err)
+		}
+	})
+
+	t.Run("test v2 files keep their resource envelope", func(t *testing.T) {
+		content := []byte(`{
+			"apiVersion": "dashboard.grafana.app/v2beta1",
+			"kind": "Dashboard",
+			"metadata": {"name": "abc", "generation": 3, "labels": {"team": "infra"}},
+			"spec": {"title": "CPU", "elements": {}}
+		}`)
+		dashboard, format, err := parseDashboardFile(content)
+		if err != nil || format != DashboardV2 {
+			t.Fatalf("expected v2 dashboard, got %s, %v", format, err)
+		}
+		if dashboard["uid"] != "abc" || dashboard["version"] != float64(3) || dashboard["title"] != "CPU" {
+			t.Fatalf("expected uid, version and spec in the model, got %v", dashboard)
+		}
+
+		dashboard["version"] = float64(4)
+		dashboard["title"] = "CPU usage"
+		saved, err := marshalDashboardFile(dashboard, format, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var file DashboardResource
+		if err := json.Unmarshal(saved, &file); err != nil {
+			t.Fatal(err)
+		}
+		if file.ApiVersion != "dashboard.grafana.app/v2beta1" || file.Metadata.Generation != 4 || file.Metadata.Labels["team"] != "infra" {
+			t.Fatalf("expected envelope to be kept, got %+v", file)
+		}
+		if _, ok := file.Spec["uid"]; ok || file.Spec["title"] != "CPU usage" {
+			t.Fatalf("expected spec without uid, got %v", file.Spec)
+		}
+	})
+
+	t.Run("test v2 files are pulled and pushed through the v2 api", func(t *testing.T) {
+		server := newResourceServer(t)
+		gc := newSourceClient(server.URL)
+		server.put(DashboardResource{
+			Metadata: DashboardResourceMetadata{Name: "abc"},
+			Spec:     map[string]interface{}{"title": "CPU usage", "elements": map[string]interface{}{}},
+		})
+
+		dir := t.TempDir()
+		filePath := filepath.Join(dir, "cpu.json")
+		content := []byte(`{
+			"apiVersion": "dashboard.grafana.app/v2beta1",
+			"kind": "Dashboard",
+			"metadata": {"name": "abc", "generation": 1},
+			"spec": {"title": "CPU", "elements": {}}
+		}`)
+		if err := os.WriteFile(filePath, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		dashboardFiles, err := IndexDashboardFiles(dir)
+		if err != nil || dashboardFiles["abc"] != filePath {
+			t.Fatalf("expected the v2 file indexed, got %v, %v", dashboardFiles, err)
+		}
+		result := gc.PullDashboard(context.Background(), "abc", PullOptions{DashboardsPath: dir}, dashboardFiles)
+		if result.Err != nil || result.FilePath != filePath || result.Status != "updated" {
+			t.Fatalf("expected the v2 file updated, got %+v", result)
+		}
+		saved, _ := os.ReadFile(filePath)
+		var file DashboardResource
+		if err := json.Unmarshal(saved, &file); err != nil {
+			t.Fatal(err)
+		}
+		if file.Spec["title"] != "CPU usage" || file.Spec["elements"] == nil || file.Spec["spec"] != nil || file.Spec["id"] != nil {
+			t.Fatalf("expected the v2 spec pulled into the file, got %v", file.Spec)
+		}
+
+		dashboard, format, _ := parseDashboardFile(saved)
+		dashboard["title"] = "CPU load"
+		content, _ = marshalDashboardFile(dashboard, format, saved)
+		if err := os.WriteFile(filePath, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		pushed := gc.PushDashboard(context.Background(), filePath, PushOptions{})
+		if pushed.Err != nil || pushed.Status != "updated" {
+			t.Fatalf("expected the v2 file pushed to abc, got %+v", pushed)
+		}
+		stored := server.get("abc")
+		if stored.Spec["title"] != "CPU load" || stored.Metadata.Generation != pushed.RemoteVersion {
+			t.Fatalf("expected the v2 spec stored, got %+v", stored)
+		}
+		if requests := server.legacyRequests(); len(requests) != 0 {
+			t.Fatalf("expected no legacy dashboard requests, got %v", requests)
+		}
+	})
+
+	t.Run("test failed api detections are retried", func(t *testing.T) {
+		server := newResourceServer(t)
+		server.detectFailures.Store(1)
+		gc := newSourceClient(server.URL)
+		gc.Retry = RetryPolicy{MaxAttempts: 1}
+
+		if api := gc.resourceApi(context.Background()); api != nil {
+			t.Fatalf("expected no api while detection fails, got %+v", api)
+		}
+		if api := gc.resourceApi(context.Background()); api == nil || api.Versions[DashboardV2] != "v2beta1" {
+			t.Fatalf("expected the api detected on retry, got %+v", api)
+		}
+	})
+
+	t.Run("test watcher metadata moves to an annotation for v2", func(t *testing.T) {
+		dashboard := map[string]interface{}{"uid": "watcher", "title": "CPU", "version": float64(1)}
+		setWatcherMarker(dashboard, WatcherMetadata{SourcePath: "cpu.json"})
+
+		resource := dashboardToResource(DashboardV2, dashboard, "infra")
+		if _, ok := resource.Spec[watcherMetadataKey]; ok {
+			t.Fatalf("expected no watcher metadata in the spec, got %v", resource.Spec)
+		}
+		if resource.Metadata.Name != "watcher" || resource.Metadata.Annotations[folderAnnotation] != "infra" {
+			t.Fatalf("expected name and folder annotation, got %+v", resource.Metadata)
+		}
+
+		resource.Metadata.ResourceVersion = "42"
+		resource.Metadata.Generation = 2
+		watcher := resourceToDashboard(resource)
+		metadata, ok := readWatcherMetadata(watcher.Dashboard)
+		if !ok || metadata.SourcePath != "cpu.json" {
+			t.Fatalf("expected watcher metadata from the annotation, got %v", watcher.Dashboard)
+		}
+		if watcher.ResourceVersion != "42" || watcher.Meta.Version != 2 || watcher.Dashboard["version"] != float64(2) {
+			t.Fatalf("expected resource versions in the watcher, got %s %+v", watcher.ResourceVersion, watcher.Meta)
+		}
+	})
+}
+
+// Serves the v2 dashboard resource API, legacy dashboard requests are recorded
+type resourceServer struct {
+	*httptest.Server
+	detectFailures atomic.Int32
+	mutex          sync.Mutex
+	resources      map[string]DashboardResource
+	legacy         []string
+}
+
+func newResourceServer(t *testing.T) *resourceServer {
+	server := &resourceServer{resources: make(map[string]DashboardResource)}
+	const dashboardsPath = "/apis/dashboard.grafana.app/v2beta1/namespaces/default/dashboards/"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /apis/dashboard.grafana.app", func(w http.ResponseWriter, r *http.Request) {
+		if server.detectFailures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"versions": [{"version": "v1beta1"}, {"version": "v2beta1"}]}`)
+	})
+	mux.HandleFunc("GET /api/frontend/settings", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"namespace": "default"}`)
+	})
+	mux.HandleFunc("GET "+dashboardsPath+"{name}", func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		resource, ok := server.resources[r.PathValue("name")]
+		server.mutex.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(resource)
+	})
+	mux.HandleFunc("PUT "+dashboardsPath+"{name}", func(w http.ResponseWriter, r *http.Request) {
+		var resource DashboardResource
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		server.mutex.Lock()
+		stored, ok := server.resources[r.PathValue("name")]
+		server.mutex.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if resource.Metadata.ResourceVersion != "" && resource.Metadata.ResourceVersion != stored.Metadata.ResourceVersion {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(server.put(resource))
+	})
+	mux.HandleFunc("/api/dashboards/", func(w http.ResponseWriter, r *http.Request) {
+		server.mutex.Lock()
+		server.legacy = append(server.legacy, r.Method+" "+r.URL.Path)
+		server.mutex.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+	})
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Stores the resource as its next generation
+func (s *resourceServer) put(resource DashboardResource) DashboardResource {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	resource.ApiVersion = "dashboard.grafana.app/v2beta1"
+	resource.Kind = "Dashboard"
+	resource.Metadata.Generation = s.resources[resource.Metadata.Name].Metadata.Generation + 1
+	resource.Metadata.ResourceVersion = fmt.Sprint(resource.Metadata.Generation * 10)
+	s.resources[resource.Metadata.Name] = resource
+	return resource
+}
+
+func (s *resourceServer) get(name string) DashboardResource {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.resources[name]
+}
+
+func (s *resourceServer) legacyRequests() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.legacy)
+}
+
+type failingChangeSource struct{}
+
+func (fs *failingChangeSource) Name() string {
+	return "failing"
+}
+
+func (fs *failingChangeSource) Start(ctx context.Context, uids []string) (<-chan ChangeEvent, error) {
+	return nil, fmt.Errorf("not available")
+}
+
+func TestStartChangeSource(t *testing.T) {
+	gc := &GrafanaClient{
+		Logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		ChangeSources: []ChangeSource{&failingChangeSource{}, &tickerSource{interval: time.Millisecond}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	changes, source, err := gc.startChangeSource(ctx, []string{"abc"})
+	if err != nil || source != "poll" {
+		t.Fatalf("expected fallback to the ticker, got %s, %v", source, err)
+	}
+	if event := <-changes; event.Uid != "" {
+		t.Fatalf("expected ticks to poll every watcher, got %v", event)
+	}
+
+	cancel()
+	for range changes {
+	}
+
+	gc.ChangeSources = []ChangeSource{&failingChangeSource{}}
+	if _, _, err := gc.startChangeSource(context.Background(), nil); err == nil {
+		t.Fatal("expected error without any available source")
+	}
+}
+
+// Closes its channel right away, as a source losing its connection would
+type closingChangeSource struct {
+	starts atomic.Int32
+}
+
+func (cs *closingChangeSource) Name() string {
+	return "closing"
+}
+
+func (cs *closingChangeSource) Start(ctx context.Context, uids []string) (<-chan ChangeEvent, error) {
+	cs.starts.Add(1)
+	events := make(chan ChangeEvent)
+	close(events)
+	return events, nil
+}
+
+func TestChangeSourceReconnect(t *testing.T) {
+	server := grafanatest.NewServer(t)
+	gc := newTestClient(server)
+	gc.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+	source := &closingChangeSource{}
+	gc.ChangeSources = []ChangeSource{source}
+	dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startWatching(gc, ctx, dbClient)
+	waitFor(t, "watcher dashboard", func() bool { return len(server.DashboardUids()) == 1 })
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-done
+
+	// Without the backoff the source is restarted thousands of times
+	if starts := source.starts.Load(); starts < 2 || starts > 25 {
+		t.Fatalf("expected a few reconnects spaced by the backoff, got %d", starts)
+	}
+}
+
+// Fake Grafana Live endpoint, replies to every command in one batched frame
+// then sends the given frames, answers to pings are sent to pongs
+func newLiveServer(t *testing.T, replies string, frames []string, pongs chan string) *httptest.Server {
+	handler := websocket.Handler(func(conn *websocket.Conn) {
+		defer conn.Close()
+		// A connect command and one subscribe per dashboard
+		for i := 0; i < 2; i++ {
+			var command string
+			if err := websocket.Message.Receive(conn, &command); err != nil {
+				return
+			}
+		}
+		websocket.Message.Send(conn, replies)
+		for _, frame := range frames {
+			websocket.Message.Send(conn, frame)
+			if frame == "{}" {
+				var pong string
+				websocket.Message.Receive(conn, &pong)
+				pongs <- pong
+			}
+		}
+		// Held open until the client goes away
+		var frame string
+		websocket.Message.Receive(conn, &frame)
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/live/ws" || r.Header.Get("Authorization") != "Bearer "+grafanatest.DefaultToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newSourceClient(url string) *GrafanaClient {
+	return &GrafanaClient{
+		Url:        url,
+		TenantId:   "1",
+		ApiKey:     grafanatest.DefaultToken,
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		HttpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func TestLiveSource(t *testing.T) {
+	t.Run("test pings are answered and batched pushes become events", func(t *testing.T) {
+		pongs := make(chan string, 1)
+		server := newLiveServer(t, "{\"id\":1,\"connect\":{}}\n{\"id\":2,\"subscribe\":{}}", []string{
+			"{}",
+			"{\"push\":{\"channel\":\"grafana/broadcast\"}}\n{\"push\":{\"channel\":\"grafana/dashboard/uid/abc\"}}",
+		}, pongs)
+		source := &liveSource{gc: newSourceClient(server.URL)}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events, err := source.Start(ctx, []string{"abc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pong := <-pongs; pong != "{}" {
+			t.Fatalf("expected the ping answered, got %q", pong)
+		}
+		select {
+		case event := <-events:
+			if event.Uid != "abc" {
+				t.Fatalf("expected an event for abc, got %v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the dashboard event")
+		}
+
+		cancel()
+		for range events {
+		}
+	})
+
+	t.Run("test failed subscriptions fail the start", func(t *testing.T) {
+		server := newLiveServer(t, "{\"id\":1,\"connect\":{}}\n{\"id\":2,\"error\":{\"code\":103,\"message\":\"permission denied\"}}", nil, nil)
+		source := &liveSource{gc: newSourceClient(server.URL)}
+
+		if _, err := source.Start(context.Background(), []string{"abc"}); err == nil || !strings.Contains(err.Error(), "permission denied") {
+			t.Fatalf("expected the subscription error, got %v", err)
+		}
+	})
+}
+
+func TestResourceWatchSource(t *testing.T) {
+	watchQueries := make(chan url.Values, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /apis/dashboard.grafana.app", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"versions": [{"version": "v1beta1"}]}`)
+	})
+	mux.HandleFunc("GET /api/frontend/settings", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"namespace": "stacks-1"}`)
+	})
+	mux.HandleFunc("GET /apis/dashboard.grafana.app/v1beta1/namespaces/stacks-1/dashboards", func(w http.ResponseWriter, r *http.Request) {
+		watchQueries <- r.URL.Query()
+		for _, event := range []string{
+			`{"type": "BOOKMARK", "object": {"metadata": {"name": ""}}}`,
+			`{"type": "MODIFIED", "object": {"metadata": {"name": "other"}}}`,
+			`{"type": "MODIFIED", "object": {"metadata": {"name": "abc"}}}`,
+		} {
+			fmt.Fprintln(w, event)
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	source := &resourceWatchSource{gc: newSourceClient(server.URL)}
+
+	events, err := source.Start(context.Background(), []string{"abc", "def"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []ChangeEvent
+	for event := range events {
+		received = append(received, event)
+	}
+	// The stream ending closes the channel so the watch loop reconnects
+	if !reflect.DeepEqual(received, []ChangeEvent{{Uid: "abc"}}) {
+		t.Fatalf("expected only the watched dashboard event, got %v", received)
+	}
+	if query := <-watchQueries; query.Get("watch") != "true" || query.Has("fieldSelector") {
+		t.Fatalf("expected a watch without field selector for many uids, got %v", query)
+	}
+
+	if _, err := source.Start(context.Background(), []string{"abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if query := <-watchQueries; query.Get("fieldSelector") != "metadata.name=abc" {
+		t.Fatalf("expected a field selector for a single uid, got %v", query)
+	}
+}
+
+// Runs a watch session against a failing Grafana, then checks the local file
+// still holds a valid dashboard and every watcher is eventually removed
+func TestWatcherChaos(t *testing.T) {
+	scenarios := []struct {
+		name   string
+		config gchaos.Config
+	}{
+		{name: "latency", config: gchaos.Config{Latency: 30 * time.Millisecond}},
+		{name: "5xx bursts", config: gchaos.Config{ServerErrorRate: 0.2, BurstLength: 3}},
+		{name: "rate limits", config: gchaos.Config{RateLimitRate: 0.3, RetryAfter: time.Second}},
+		{name: "truncated bodies", config: gchaos.Config{TruncateRate: 0.2}},
+		{name: "connection resets", config: gchaos.Config{ResetRate: 0.2}},
+		{name: "auth expiry", config: gchaos.Config{AuthExpiry: 8}},
+		{name: "everything", config: gchaos.Config{
+			Latency:         10 * time.Millisecond,
+			ServerErrorRate: 0.05,
+			BurstLength:     2,
+			RateLimitRate:   0.05,
+			TruncateRate:    0.05,
+			ResetRate:       0.05,
+		}},
+	}
+
+	for _, scenario := range scenarios {
+		for seed := int64(1); seed <= 2; seed++ {
+			t.Run(fmt.Sprintf("test %s seed %d", scenario.name, seed), func(t *testing.T) {
+				server := grafanatest.NewServer(t)
+				gc := newTestClient(server)
+				filePath := newTestContext(t, server)
+				original, _ := os.ReadFile(filePath)
+				originalDashboard, _ := unmarshalDashboard(original)
+
+				config := scenario.config
+				config.Seed = seed
+				transport := gchaos.NewTransport(nil, config, nil)
+				gc.HttpClient.Transport = transport
+
+				dbClient := &GrafanaDashboardClient{FilePath: filePath}
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				done := startWatching(gc, ctx, dbClient)
+
+				// Edit every watcher as if saved in the UI while the session runs
+				timezones := []string{"utc", "browser", "Europe/Paris"}
+				for _, timezone := range timezones {
+					time.Sleep(60 * time.Millisecond)
+					for _, uid := range server.DashboardUids() {
+						watcher := server.Dashboard(uid)
+						watcher["timezone"] = timezone
+						server.SaveDashboard(watcher, "")
+					}
+				}
+				time.Sleep(60 * time.Millisecond)
+				cancel()
+				exitErr := <-done
+				t.Logf("watcher exited with %v, injected %v", exitErr, transport.Injected())
+
+				// Tokens are refreshed before cleaning up
+				config.AuthExpiry = 0
+				transport.SetConfig(config)
+				cleanupWatchers(t, gc, server, dbClient)
+
+				content, err := os.ReadFile(filePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				dashboard, err := unmarshalDashboard(content)
+				if err != nil {
+					t.Fatalf("local file corrupted: %v", err)
+				}
+				if dashboard["uid"] != originalDashboard["uid"] || dashboard["title"] != originalDashboard["title"] {
+					t.Fatalf("expected local attributes kept, got uid %v, title %v", dashboard["uid"], dashboard["title"])
+				}
+				if _, ok := dashboard[watcherMetadataKey]; ok {
+					t.Fatal("expected no watcher metadata in the local file")
+				}
+				if timezone := dashboard["timezone"]; timezone != originalDashboard["timezone"] && !slices.Contains(timezones, timezone.(string)) {
+					t.Fatalf("unexpected timezone %v in the local file", timezone)
+				}
+			})
+		}
+	}
+}
+
+// Saves and deletes the watcher the way the start command shuts down, then
+// removes any watcher whose creation response was lost
+func cleanupWatchers(t *testing.T, gc *GrafanaClient, server *grafanatest.Server, dbClient *GrafanaDashboardClient) {
+	t.Helper()
+	for attempt := 0; attempt < 50 && len(server.DashboardUids()) > 0; attempt++ {
+		if dbClient.Uid != "" {
+			if err := gc.GetDashboardChanges(context.Background(), dbClient); err == nil {
+				gc.SaveChangesToDisk(context.Background(), dbClient)
+			}
+			if err := gc.DeleteWatcherDashboard(context.Background(), dbClient); err == nil || err == ErrDashboardNotFound {
+				dbClient.Uid = ""
+			}
+			continue
+		}
+		orphans, err := gc.FindOrphanWatchers(context.Background(), nil, time.Nanosecond, false)
+		if err != nil {
+			continue
+		}
+		for _, orphan := range orphans {
+			gc.DeleteWatcherDashboard(context.Background(), &GrafanaDashboardClient{Uid: orphan.Uid})
+		}
+	}
+	if uids := server.DashboardUids(); len(uids) > 0 {
+		t.Fatalf("expected every watcher removed, found %v", uids)
+	}
+}
+
+func TestFindOrphanWatchers(t *testing.T) {
+	server := grafanatest.NewServer(t)
+	gc := newTestClient(server)
+	host, _ := os.Hostname()
+	for _, uid := range []string{"recorded", "unrecorded"} {
+		watcher := map[string]interface{}{"uid": uid, "title": uid}
+		// No owner process, as left by a crashed session
+		setWatcherMarker(watcher, WatcherMetadata{Host: host, SourcePath: uid + ".json"})
+		server.SaveDashboard(watcher, "")
+	}
+	recorded := map[string]gcontext.GContextGrafanaResource{
+		"recorded": {Uid: "recorded", Path: "cpu.json"},
+	}
+
+	orphans, err := gc.FindOrphanWatchers(context.Background(), recorded, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 2 {
+		t.Fatalf("expected both watchers found, got %+v", orphans)
+	}
+	for _, orphan := range orphans {
+		isRecorded := orphan.Uid == "recorded"
+		if orphan.IsRecorded != isRecorded || (isRecorded && orphan.Path != "cpu.json") {
+			t.Fatalf("expected only watchers with a config entry recorded, got %+v", orphan)
+		}
+	}
+}
+
+func TestEndpointEscaping(t *testing.T) {
+	paths := make(chan string, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.EscapedPath()
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	gc := newSourceClient(server.URL + "/")
+	gc.Retry = RetryPolicy{MaxAttempts: 1}
+	ctx := context.Background()
+
+	gc.GetAlertRule(ctx, "a/b")
+	gc.deleteContactPoint(ctx, "../c")
+	gc.GetDatasource(ctx, "d?e")
+	for _, expected := range []string{
+		"/api/v1/provisioning/alert-rules/a%2Fb",
+		"/api/v1/provisioning/contact-points/..%2Fc",
+		"/api/datasources/uid/d%3Fe",
+	} {
+		if path := <-paths; path != expected {
+			t.Fatalf("expected %s, got %s", expected, path)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("test transient statuses are retried", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		server.SaveDashboard(map[string]interface{}{"uid": "cpu", "title": "CPU"}, "")
+		server.AddFault(grafanatest.Fault{PathPrefix: "/api/dashboards/uid/", Status: http.StatusServiceUnavailable, Times: 2})
+
+		if _, err := gc.GetDashboard(context.Background(), "cpu"); err != nil {
+			t.Fatalf("expected the third attempt to succeed, got: %v", err)
+		}
+	})
+
+	t.Run("test exhausted retries are retryable", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		server.AddFault(grafanatest.Fault{PathPrefix: "/api/dashboards/uid/", Status: http.StatusBadGateway})
+
+		_, err := gc.GetDashboard(context.Background(), "cpu")
+		if !IsRetryable(err) {
+			t.Fatalf("expected retryable error, got: %v", err)
+		}
+		if requests := len(server.Requests()); requests != 3 {
+			t.Fatalf("expected 3 attempts, got %d", requests)
+		}
+	})
+
+	t.Run("test auth failures are fatal", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		gc.ApiKey = "invalid"
+
+		_, err := gc.GetDashboard(context.Background(), "cpu")
+		if !IsFatal(err) || len(server.Requests()) != 1 {
+			t.Fatalf("expected a single fatal attempt, got: %v after %d requests", err, len(server.Requests()))
+		}
+	})
+
+	t.Run("test retry after is honored", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		gc.Retry.MaxDelay = time.Second
+		gc.HttpClient.Transport = gchaos.NewTransport(nil, gchaos.Config{Seed: 1, RateLimitRate: 1, RetryAfter: time.Second}, nil)
+
+		start := time.Now()
+		_, err := gc.GetDashboard(context.Background(), "cpu")
+		if !IsRetryable(err) {
+			t.Fatalf("expected retryable error, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 2*time.Second {
+			t.Fatalf("expected two waits of one second, took %v", elapsed)
+		}
+	})
+
+	t.Run("test lost responses of creates are not retried", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		gc.HttpClient.Transport = gchaos.NewTransport(nil, gchaos.Config{Seed: 1, ResetRate: 1}, nil)
+
+		_, err := gc.saveDashboard(context.Background(), map[string]interface{}{"uid": "cpu", "title": "CPU"}, "", "", false)
+		if !IsFatal(err) || len(server.DashboardUids()) != 1 {
+			t.Fatalf("expected a single create and a fatal error, got: %v", err)
+		}
+	})
+
+	t.Run("test budget resets after a successful poll", func(t *testing.T) {
+		gc := &GrafanaClient{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+		var budget retryBudget
+		for day := 0; day < 10; day++ {
+			if err := gc.pollFailed(&budget, "cpu.json", retryable(io.ErrUnexpectedEOF)); err != nil {
+				t.Fatalf("expected spread out failures to be retried, got: %v", err)
+			}
+			budget.reset()
+		}
+		for i := 0; i < maxWatcherRetry; i++ {
+			gc.pollFailed(&budget, "cpu.json", retryable(io.ErrUnexpectedEOF))
+		}
+		if err := gc.pollFailed(&budget, "cpu.json", retryable(io.ErrUnexpectedEOF)); err == nil {
+			t.Fatal("expected consecutive failures to spend the budget")
+		}
+	})
+}
+
+// Blocks requests until their context ends once hang is set, as a hung Grafana would
+type hangingTransport struct {
+	hang atomic.Bool
+}
+
+func (ht *hangingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !ht.hang.Load() {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestCancellation(t *testing.T) {
+	t.Run("test cancelled requests return early", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		transport := &hangingTransport{}
+		transport.hang.Store(true)
+		gc.HttpClient.Transport = transport
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := gc.GetDashboard(ctx, "cpu")
+		if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+			t.Fatalf("expected the deadline to end the request, got: %v after %v", err, time.Since(start))
+		}
+		if len(server.Requests()) != 0 {
+			t.Fatalf("expected no retries, got %v", server.Requests())
+		}
+	})
+
+	t.Run("test cancelled retries stop waiting", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		gc.Retry.MaxDelay = time.Minute
+		gc.HttpClient.Transport = gchaos.NewTransport(nil, gchaos.Config{Seed: 1, RateLimitRate: 1, RetryAfter: 30 * time.Second}, nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := gc.GetDashboard(ctx, "cpu")
+		if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+			t.Fatalf("expected the deadline to end the wait, got: %v after %v", err, time.Since(start))
+		}
+	})
+
+	t.Run("test watcher stops while a poll hangs", func(t *testing.T) {
+		server := grafanatest.NewServer(t)
+		gc := newTestClient(server)
+		transport := &hangingTransport{}
+		gc.HttpClient.Transport = transport
+		dbClient := &GrafanaDashboardClient{FilePath: newTestContext(t, server)}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := startWatching(gc, ctx, dbClient)
+		waitForWatcher(t, server)
+		transport.hang.Store(true)
+		time.Sleep(5 * gc.Interval)
+		cancel()
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.Canceled) || dbClient.Err != nil {
+				t.Fatalf("expected a clean cancellation, got: %v, watcher error: %v", err, dbClient.Err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the watcher to stop without waiting for the hung poll")
+		}
 	})
 }
@@ -0,0 +1,447 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+
+	"github.com/alex067/gsync/internal/pkg/gapi"
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+)
+
+var ErrLibraryPanelNotFound = fmt.Errorf("library panel not found")
+
+// How saved dashboards keep the library panels they reference
+type LibraryPanelMode string
+
+const (
+	// Dashboards keep the libraryPanel uid only
+	LibraryPanelReference LibraryPanelMode = "reference"
+	// Library panel models replace the references, unlinking the panels
+	LibraryPanelInline LibraryPanelMode = "inline"
+	// Library panel models are saved next to the dashboard under LibraryPanelDirectory
+	LibraryPanelFiles LibraryPanelMode = "files"
+)
+
+// Directory holding library panel files, skipped when indexing dashboards
+const LibraryPanelDirectory = "library-panels"
+
+// Library element as returned by the Grafana API
+type GrafanaLibraryPanel struct {
+	Uid       string                 `json:"uid"`
+	Name      string                 `json:"name"`
+	FolderUid string                 `json:"folderUid"`
+	Model     map[string]interface{} `json:"model"`
+	Version   int                    `json:"version,omitempty"`
+}
+
+type LibraryPanelResult struct {
+	Uid      string
+	Name     string
+	FilePath string
+	// created, updated or unchanged
+	Status  string
+	Changes []gdiff.Change
+	Err     error
+}
+
+// Validates the library panel mode, empty defaults to reference
+func ParseLibraryPanelMode(mode string) (LibraryPanelMode, error) {
+	switch LibraryPanelMode(mode) {
+	case "", LibraryPanelReference:
+		return LibraryPanelReference, nil
+	case LibraryPanelInline, LibraryPanelFiles:
+		return LibraryPanelMode(mode), nil
+	}
+	return "", fmt.Errorf("unknown library panel mode %s, expected reference, inline or files", mode)
+}
+
+// File path of a library panel under the given directory
+// Names are not unique in Grafana, the uid keeps panels sharing one apart
+func LibraryPanelFilePath(dir, name, uid string) string {
+	fileName := pathSegment(uid)
+	if slug := Slugify(name); slug != "" {
+		fileName = fmt.Sprintf("%s-%s", slug, fileName)
+	}
+	return filepath.Join(dir, LibraryPanelDirectory, fileName+".json")
+}
+
+// Finds the library panel files under the given directory holding the uid
+func findLibraryPanelFiles(dir, uid string) ([]string, error) {
+	filePaths, err := filepath.Glob(filepath.Join(dir, LibraryPanelDirectory, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var owned []string
+	for _, filePath := range filePaths {
+		// Files that are not library panels are left alone
+		if libraryPanel, err := ReadLibraryPanelFile(filePath); err == nil && libraryPanel.Uid == uid {
+			owned = append(owned, filePath)
+		}
+	}
+	return owned, nil
+}
+
+// Reads a local library panel file
+func ReadLibraryPanelFile(filePath string) (*GrafanaLibraryPanel, error) {
+	var libraryPanel GrafanaLibraryPanel
+	if err := readResourceFile(filePath, &libraryPanel); err != nil {
+		return nil, err
+	}
+	if libraryPanel.Uid == "" {
+		return nil, fmt.Errorf("library panel uid attribute not found in %s", filePath)
+	}
+	return &libraryPanel, nil
+}
+
+// Writes a library panel file, the version is instance specific and left out
+func writeLibraryPanelFile(filePath string, libraryPanel *GrafanaLibraryPanel) error {
+	saved := *libraryPanel
+	saved.Version = 0
+	return writeResourceFile(filePath, &saved)
+}
+
+// Finds every library panel file under the dashboards path
+func IndexLibraryPanelFiles(dashboardsPath string) ([]string, error) {
+	var filePaths []string
+	err := filepath.Walk(dashboardsPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".json" && filepath.Base(filepath.Dir(path)) == LibraryPanelDirectory {
+			filePaths = append(filePaths, path)
+		}
+		return nil
+	})
+	return filePaths, err
+}
+
+func (gc *GrafanaClient) GetLibraryPanel(ctx context.Context, uid string) (*GrafanaLibraryPanel, error) {
+	element, err := gc.api().GetLibraryElement(ctx, uid)
+	if gapi.IsNotFound(err) {
+		return nil, ErrLibraryPanelNotFound
+	}
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return libraryPanelFromElement(element), nil
+}
+
+// Keeps the attributes gsync writes to library panel files
+func libraryPanelFromElement(element *gapi.LibraryElement) *GrafanaLibraryPanel {
+	return &GrafanaLibraryPanel{
+		Uid:       element.Uid,
+		Name:      element.Name,
+		FolderUid: element.FolderUid,
+		Model:     element.Model,
+		Version:   element.Version,
+	}
+}
+
+// Lists library panels, filtered by folder uids when given
+func (gc *GrafanaClient) SearchLibraryPanels(ctx context.Context, folderUids []string) ([]GrafanaLibraryPanel, error) {
+	var libraryPanels []GrafanaLibraryPanel
+	for page := 1; ; page++ {
+		result, err := gc.api().SearchLibraryElements(ctx, gapi.LibraryElementQuery{
+			Kind:       gapi.LibraryPanelKind,
+			FolderUids: folderUids,
+			PerPage:    100,
+			Page:       page,
+		})
+		if err != nil {
+			return nil, apiError(err)
+		}
+		for _, element := range result.Elements {
+			libraryPanels = append(libraryPanels, *libraryPanelFromElement(&element))
+		}
+		if len(result.Elements) == 0 || len(libraryPanels) >= result.TotalCount {
+			return libraryPanels, nil
+		}
+	}
+}
+
+// Creates the library panel, or updates it over the given Grafana version
+func (gc *GrafanaClient) saveLibraryPanel(ctx context.Context, libraryPanel *GrafanaLibraryPanel, remoteVersion int) error {
+	_, err := gc.api().SaveLibraryElement(ctx, gapi.SaveLibraryElementRequest{
+		Uid:       libraryPanel.Uid,
+		Name:      libraryPanel.Name,
+		Kind:      gapi.LibraryPanelKind,
+		FolderUid: libraryPanel.FolderUid,
+		Model:     libraryPanel.Model,
+		Version:   remoteVersion,
+	})
+	return apiError(err)
+}
+
+// Downloads a library panel into the given directory
+func (gc *GrafanaClient) PullLibraryPanel(ctx context.Context, uid, dir string) LibraryPanelResult {
+	result := LibraryPanelResult{Uid: uid}
+
+	libraryPanel, err := gc.GetLibraryPanel(ctx, uid)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Name = libraryPanel.Name
+	result.FilePath, result.Status, result.Err = saveLibraryPanelToDisk(dir, libraryPanel)
+	return result
+}
+
+// Writes the library panel file under the given directory when it differs from
+// the local one, returning its path
+// Files left by an older name of the panel are moved to the current name
+func saveLibraryPanelToDisk(dir string, libraryPanel *GrafanaLibraryPanel) (string, string, error) {
+	filePath := LibraryPanelFilePath(dir, libraryPanel.Name, libraryPanel.Uid)
+	owned, err := findLibraryPanelFiles(dir, libraryPanel.Uid)
+	if err != nil {
+		return "", "", err
+	}
+	isMoved := false
+	for _, ownedPath := range owned {
+		if ownedPath == filePath {
+			continue
+		}
+		if !isMoved && !slices.Contains(owned, filePath) {
+			err = os.Rename(ownedPath, filePath)
+			isMoved = true
+		} else {
+			err = os.Remove(ownedPath)
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	status := "created"
+	local, err := ReadLibraryPanelFile(filePath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return "", "", err
+	case reflect.DeepEqual(libraryPanelModel(local), libraryPanelModel(libraryPanel)):
+		if isMoved {
+			return filePath, "updated", nil
+		}
+		return filePath, "unchanged", nil
+	default:
+		status = "updated"
+	}
+	return filePath, status, writeLibraryPanelFile(filePath, libraryPanel)
+}
+
+// Converts the library panel into a generic model for comparisons
+func libraryPanelModel(libraryPanel *GrafanaLibraryPanel) map[string]interface{} {
+	saved := *libraryPanel
+	saved.Version = 0
+	content, _ := json.Marshal(&saved)
+	model, _ := unmarshalDashboard(content)
+	return model
+}
+
+// Deploys a local library panel file to its uid
+func (gc *GrafanaClient) PushLibraryPanel(ctx context.Context, filePath string, dryRun bool) LibraryPanelResult {
+	result := LibraryPanelResult{FilePath: filePath}
+
+	local, err := ReadLibraryPanelFile(filePath)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Uid = local.Uid
+	result.Name = local.Name
+
+	remote, err := gc.GetLibraryPanel(ctx, local.Uid)
+	remoteVersion := 0
+	switch {
+	case err == ErrLibraryPanelNotFound:
+		result.Status = "created"
+		result.Changes = gdiff.Compare(map[string]interface{}{}, libraryPanelModel(local))
+	case err != nil:
+		result.Err = err
+		return result
+	default:
+		remoteVersion = remote.Version
+		result.Changes = gdiff.Compare(libraryPanelModel(remote), libraryPanelModel(local))
+		if len(result.Changes) == 0 {
+			result.Status = "unchanged"
+			return result
+		}
+		result.Status = "updated"
+	}
+
+	if dryRun {
+		return result
+	}
+
+	if err := gc.saveLibraryPanel(ctx, local, remoteVersion); err != nil {
+		result.Err = err
+		return result
+	}
+	gc.Logger.Info(
+		fmt.Sprintf("Library panel %s", result.Status),
+		slog.String("uid", local.Uid),
+		slog.String("path", filePath))
+	return result
+}
+
+// Calls visit for every panel of the dashboard, including panels in collapsed rows
+func visitPanels(dashboard map[string]interface{}, visit func(panel map[string]interface{}) map[string]interface{}) {
+	var visitList func(panels []interface{})
+	visitList = func(panels []interface{}) {
+		for i, item := range panels {
+			panel, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if nested, ok := panel["panels"].([]interface{}); ok {
+				visitList(nested)
+			}
+			panels[i] = visit(panel)
+		}
+	}
+	if panels, ok := dashboard["panels"].([]interface{}); ok {
+		visitList(panels)
+	}
+}
+
+// Returns the uid of the library panel a panel links to
+func libraryPanelUid(panel map[string]interface{}) string {
+	libraryPanel, _ := panel["libraryPanel"].(map[string]interface{})
+	uid, _ := libraryPanel["uid"].(string)
+	return uid
+}
+
+// Lists the library panel uids referenced by the dashboard, in panel order
+func libraryPanelRefs(dashboard map[string]interface{}) []string {
+	var uids []string
+	seen := make(map[string]bool)
+	visitPanels(dashboard, func(panel map[string]interface{}) map[string]interface{} {
+		if uid := libraryPanelUid(panel); uid != "" && !seen[uid] {
+			seen[uid] = true
+			uids = append(uids, uid)
+		}
+		return panel
+	})
+	return uids
+}
+
+// Replaces library panel references with a copy of the library panel model
+// The copy keeps the position and id of the reference and no longer links to
+// the library panel
+func inlineLibraryPanels(dashboard map[string]interface{}, libraryPanels map[string]*GrafanaLibraryPanel) {
+	visitPanels(dashboard, func(panel map[string]interface{}) map[string]interface{} {
+		libraryPanel, ok := libraryPanels[libraryPanelUid(panel)]
+		if !ok {
+			return panel
+		}
+
+		inlined := make(map[string]interface{}, len(libraryPanel.Model))
+		for key, value := range libraryPanel.Model {
+			inlined[key] = value
+		}
+		for _, key := range []string{"id", "gridPos"} {
+			if value, ok := panel[key]; ok {
+				inlined[key] = value
+			}
+		}
+		delete(inlined, "libraryPanel")
+		return inlined
+	})
+}
+
+// Applies the library panel mode to a dashboard saved to filePath
+// Returns the versions of the library panels fetched, keyed by uid
+func (gc *GrafanaClient) saveLibraryPanels(ctx context.Context, dashboard map[string]interface{}, filePath string) (map[string]int, error) {
+	if gc.LibraryPanels != LibraryPanelInline && gc.LibraryPanels != LibraryPanelFiles {
+		return nil, nil
+	}
+
+	versions := make(map[string]int)
+	libraryPanels := make(map[string]*GrafanaLibraryPanel)
+	for _, uid := range libraryPanelRefs(dashboard) {
+		libraryPanel, err := gc.GetLibraryPanel(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("library panel uid=%s: %w", uid, err)
+		}
+		libraryPanels[uid] = libraryPanel
+		versions[uid] = libraryPanel.Version
+	}
+
+	if gc.LibraryPanels == LibraryPanelInline {
+		inlineLibraryPanels(dashboard, libraryPanels)
+		return versions, nil
+	}
+
+	for _, libraryPanel := range libraryPanels {
+		libraryPanelPath, status, err := saveLibraryPanelToDisk(filepath.Dir(filePath), libraryPanel)
+		if err != nil {
+			return nil, err
+		}
+		if status != "unchanged" {
+			gc.Logger.Info(
+				fmt.Sprintf("Library panel %s", status),
+				slog.String("uid", libraryPanel.Uid),
+				slog.String("path", libraryPanelPath))
+		}
+	}
+	return versions, nil
+}
+
+// Reports whether a library panel used by the watcher changed since the last
+// poll, the first poll records the versions to compare against
+// Edits to library panels are saved to the library panel and leave the
+// dashboard version as is
+func (gc *GrafanaClient) isLibraryPanelChanged(ctx context.Context, dbClient *GrafanaDashboardClient) bool {
+	isBaseline := dbClient.libraryVersions == nil
+	if isBaseline {
+		dbClient.libraryVersions = make(map[string]int)
+	}
+
+	isChanged := false
+	for _, uid := range libraryPanelRefs(dbClient.Dashboard.Dashboard) {
+		libraryPanel, err := gc.GetLibraryPanel(ctx, uid)
+		if err != nil {
+			// Retried on the next tick
+			gc.Logger.Warn(
+				"error fetching library panel",
+				slog.String("uid", uid),
+				slog.String("path", dbClient.FilePath),
+				slog.String("error", err.Error()))
+			continue
+		}
+		if version, ok := dbClient.libraryVersions[uid]; !isBaseline && (!ok || version != libraryPanel.Version) {
+			gc.Logger.Info(
+				"Library panel change detected",
+				slog.String("uid", uid),
+				slog.String("name", libraryPanel.Name),
+				slog.String("path", dbClient.FilePath))
+			isChanged = true
+		}
+		dbClient.libraryVersions[uid] = libraryPanel.Version
+	}
+	return isChanged
+}
+
+// Saves library panel edits made through the watcher, inlined panels are
+// saved with the dashboard
+func (gc *GrafanaClient) pollLibraryPanels(ctx context.Context, dbClient *GrafanaDashboardClient) {
+	if gc.LibraryPanels != LibraryPanelInline && gc.LibraryPanels != LibraryPanelFiles {
+		return
+	}
+	if dbClient.IsDashboardChanged || dbClient.Dashboard.Dashboard == nil || !gc.isLibraryPanelChanged(ctx, dbClient) {
+		return
+	}
+
+	if gc.LibraryPanels == LibraryPanelInline {
+		dbClient.IsDashboardChanged = true
+		return
+	}
+	if _, err := gc.saveLibraryPanels(ctx, dbClient.Dashboard.Dashboard, dbClient.FilePath); err != nil {
+		gc.Logger.Error(err.Error(), slog.String("path", dbClient.FilePath))
+	}
+}
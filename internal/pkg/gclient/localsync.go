@@ -0,0 +1,153 @@
+package gclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Hashes file content to tell local edits apart from writes made by gsync
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func hashFile(filePath string) (string, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return hashContent(content), nil
+}
+
+// Uploads the local dashboard file to the watcher dashboard
+// Keeps the watcher uid and title so the watcher stays recognizable
+// Grafana refuses the upload when the watcher changed since the last poll, the
+// new version then goes through the conflict check of SaveChangesToDisk
+func (gc *GrafanaClient) UploadLocalChanges(ctx context.Context, dbClient *GrafanaDashboardClient) error {
+	err := gc.uploadLocalChanges(ctx, dbClient, false)
+	if !errors.Is(err, ErrVersionMismatch) {
+		return err
+	}
+
+	gc.Logger.Warn("Watcher changed in Grafana before the upload", slog.String("path", dbClient.FilePath))
+	if err := gc.GetDashboardChanges(ctx, dbClient); err != nil {
+		return err
+	}
+	return gc.SaveChangesToDisk(ctx, dbClient)
+}
+
+// Uploads the local dashboard file over any watcher version, dropping Grafana edits
+// Only for an explicit choice of the user, see UploadLocalChanges
+func (gc *GrafanaClient) ResetWatcherToLocal(ctx context.Context, dbClient *GrafanaDashboardClient) error {
+	return gc.uploadLocalChanges(ctx, dbClient, true)
+}
+
+func (gc *GrafanaClient) uploadLocalChanges(ctx context.Context, dbClient *GrafanaDashboardClient, isOverwrite bool) error {
+	dashboardFileData, err := os.ReadFile(dbClient.FilePath)
+	if err != nil {
+		return err
+	}
+
+	dashboard, format, err := parseDashboardFile(dashboardFileData)
+	if err != nil {
+		return err
+	}
+
+	// Keep the original creation time of the watcher
+	metadata := newWatcherMetadata(dbClient.FilePath)
+	if previous, ok := readWatcherMetadata(dbClient.Dashboard.Dashboard); ok {
+		metadata.CreatedAt = previous.CreatedAt
+	}
+	setWatcherAttributes(dashboard, dbClient.Uid, metadata)
+	dashboard["version"] = dbClient.LastVersion
+	dashboard["id"] = nil
+	if dbClient.Dashboard.Dashboard != nil {
+		dashboard["id"] = dbClient.Dashboard.Dashboard["id"]
+	}
+
+	// Without a resourceVersion the resource API overwrites the watcher
+	resourceVersion := dbClient.lastResourceVersion
+	if isOverwrite {
+		resourceVersion = ""
+	}
+	saved, err := gc.saveWatcherDashboard(ctx, format, dashboard, dbClient.FolderUid, "Gsync local file changes", true, isOverwrite, resourceVersion)
+	if err != nil {
+		return err
+	}
+
+	// Our own upload is not a remote change, skip it on the next poll
+	dbClient.Mutex.Lock()
+	dbClient.LastVersion = saved.Meta.Version
+	if saved.ResourceVersion != "" {
+		dbClient.lastResourceVersion = saved.ResourceVersion
+	}
+	dbClient.Dashboard.Dashboard = dashboard
+	dbClient.Mutex.Unlock()
+	dbClient.recordSync(dashboardFileData)
+	return nil
+}
+
+// Watches the parent directory of every dashboard file
+// Directories are watched instead of files since editors often replace files on save
+func (gc *GrafanaClient) newLocalWatcher(dbClients []*GrafanaDashboardClient) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	watchedDirs := make(map[string]bool)
+	for _, dbClient := range dbClients {
+		dir := filepath.Dir(dbClient.FilePath)
+		if watchedDirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		watchedDirs[dir] = true
+	}
+	return watcher, nil
+}
+
+// Uploads the local edit if the event belongs to a watched dashboard file
+// Events caused by gsync writing the file itself are ignored through the content hash
+func (gc *GrafanaClient) handleLocalEvent(ctx context.Context, event fsnotify.Event, dbClients []*GrafanaDashboardClient) {
+	if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+		return
+	}
+
+	for _, dbClient := range dbClients {
+		if dbClient.Err != nil || filepath.Clean(event.Name) != filepath.Clean(dbClient.FilePath) {
+			continue
+		}
+
+		currentHash, err := hashFile(dbClient.FilePath)
+		if err != nil || currentHash == dbClient.localHash {
+			return
+		}
+
+		// Editors may write files in several steps, skip until the file is valid
+		dashboardFileData, _ := os.ReadFile(dbClient.FilePath)
+		if !json.Valid(dashboardFileData) {
+			return
+		}
+
+		gc.Logger.Info("Local change detected, uploading to watcher...", slog.String("path", dbClient.FilePath))
+		if err := gc.UploadLocalChanges(ctx, dbClient); err != nil {
+			gc.Logger.Error(
+				"error uploading local changes",
+				slog.String("path", dbClient.FilePath),
+				slog.String("error", err.Error()))
+		}
+		return
+	}
+}
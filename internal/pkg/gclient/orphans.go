@@ -0,0 +1,191 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/user"
+	"regexp"
+	"time"
+
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+)
+
+// Tag marking every watcher dashboard created by gsync
+const WatcherTag = "gsync-watcher"
+
+// Dashboard attribute holding the watcher metadata
+const watcherMetadataKey = "gsync"
+
+// Watchers created before tagging are only recognizable by their title
+var legacyWatcherTitle = regexp.MustCompile(`\(Gsync [A-Za-z]{14}\)$`)
+
+type WatcherMetadata struct {
+	Owner      string    `json:"owner"`
+	Host       string    `json:"host"`
+	Pid        int       `json:"pid"`
+	SourcePath string    `json:"sourcePath"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type OrphanWatcher struct {
+	Uid      string
+	Title    string
+	Metadata *WatcherMetadata
+	// Why the watcher is considered orphaned
+	Reason string
+	// Recorded in the config with its owner gone, the watcher may hold changes
+	// not saved to Path yet and is only recovered with start --resume
+	IsRecorded bool
+	Path       string
+}
+
+// Describes the current gsync session as the watcher owner
+func newWatcherMetadata(sourcePath string) WatcherMetadata {
+	metadata := WatcherMetadata{
+		Pid:        os.Getpid(),
+		SourcePath: sourcePath,
+		CreatedAt:  time.Now().UTC(),
+	}
+	metadata.Host, _ = os.Hostname()
+	if currentUser, err := user.Current(); err == nil {
+		metadata.Owner = currentUser.Username
+	}
+	return metadata
+}
+
+// Reads the watcher metadata from a watcher dashboard model
+func readWatcherMetadata(dashboard map[string]interface{}) (*WatcherMetadata, bool) {
+	value, ok := dashboard[watcherMetadataKey]
+	if !ok {
+		return nil, false
+	}
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	var metadata WatcherMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return nil, false
+	}
+	return &metadata, true
+}
+
+// Adds the watcher tag and metadata to the dashboard model
+func setWatcherMarker(dashboard map[string]interface{}, metadata WatcherMetadata) {
+	tags, _ := dashboard["tags"].([]interface{})
+	for _, tag := range tags {
+		if tag == WatcherTag {
+			dashboard[watcherMetadataKey] = metadata
+			return
+		}
+	}
+	dashboard["tags"] = append(append([]interface{}{}, tags...), WatcherTag)
+	dashboard[watcherMetadataKey] = metadata
+}
+
+// Removes the watcher tag and metadata so they never reach the local file
+func stripWatcherMarker(remote, local map[string]interface{}) {
+	delete(remote, watcherMetadataKey)
+	if value, ok := local[watcherMetadataKey]; ok {
+		remote[watcherMetadataKey] = value
+	}
+
+	tags, ok := remote["tags"].([]interface{})
+	if !ok {
+		return
+	}
+	strippedTags := make([]interface{}, 0, len(tags))
+	for _, tag := range tags {
+		if tag != WatcherTag {
+			strippedTags = append(strippedTags, tag)
+		}
+	}
+	if _, ok := local["tags"]; !ok && len(strippedTags) == 0 {
+		delete(remote, "tags")
+		return
+	}
+	remote["tags"] = strippedTags
+}
+
+// Finds watcher dashboards no live gsync session owns
+// Only watchers without a config entry are orphaned, when their owner process
+// on this host is gone or, with a ttl, when they are older than the ttl.
+// Recorded watchers whose owner is gone are returned with IsRecorded set, they
+// are never to be deleted. Untagged watchers from older gsync versions carry no
+// metadata and are only included when asked for
+func (gc *GrafanaClient) FindOrphanWatchers(
+	ctx context.Context,
+	recorded map[string]gcontext.GContextGrafanaResource,
+	ttl time.Duration,
+	includeUntagged bool,
+) ([]OrphanWatcher, error) {
+	results, err := gc.SearchDashboards(ctx, GrafanaSearchQuery{Tags: []string{WatcherTag}})
+	if err != nil {
+		return nil, err
+	}
+
+	if includeUntagged {
+		legacyResults, err := gc.SearchDashboards(ctx, GrafanaSearchQuery{Query: "Gsync"})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, legacyResults...)
+	}
+
+	host, _ := os.Hostname()
+	seen := make(map[string]bool)
+	var orphans []OrphanWatcher
+
+	for _, result := range results {
+		if seen[result.Uid] {
+			continue
+		}
+		seen[result.Uid] = true
+
+		if resource, ok := recorded[result.Uid]; ok {
+			if !resource.IsOwnerAlive() {
+				orphans = append(orphans, OrphanWatcher{
+					Uid:        result.Uid,
+					Title:      result.Title,
+					Reason:     "owner process gone, changes may not be saved",
+					IsRecorded: true,
+					Path:       resource.Path,
+				})
+			}
+			continue
+		}
+
+		orphan := OrphanWatcher{Uid: result.Uid, Title: result.Title}
+
+		dashboard, err := gc.GetDashboard(ctx, result.Uid)
+		if err == ErrDashboardNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		metadata, ok := readWatcherMetadata(dashboard.Dashboard)
+		switch {
+		case !ok:
+			if !includeUntagged || !legacyWatcherTitle.MatchString(result.Title) {
+				continue
+			}
+			orphan.Reason = "untagged watcher not recorded in config"
+		case ttl > 0 && time.Since(metadata.CreatedAt) > ttl:
+			orphan.Reason = "older than ttl"
+		case metadata.Host == host && !owner(metadata).IsOwnerAlive():
+			orphan.Reason = "owner process gone"
+		default:
+			continue
+		}
+		orphan.Metadata = metadata
+		orphans = append(orphans, orphan)
+	}
+	return orphans, nil
+}
+
+// Describes the watcher owner the way the config records it
+func owner(metadata *WatcherMetadata) *gcontext.GContextGrafanaResource {
+	return &gcontext.GContextGrafanaResource{Pid: metadata.Pid, Host: metadata.Host}
+}
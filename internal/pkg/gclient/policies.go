@@ -0,0 +1,164 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+)
+
+// File path of the notification policy tree under the alerting path
+func PolicyFilePath(alertingPath string) string {
+	return filepath.Join(alertingPath, "policies.yaml")
+}
+
+// Reads the local notification policy tree
+func ReadPolicyFile(filePath string) (map[string]interface{}, error) {
+	var tree map[string]interface{}
+	if err := readResourceFile(filePath, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Fetches the notification policy tree, provenance is left out since it is
+// instance specific
+func (gc *GrafanaClient) GetNotificationPolicy(ctx context.Context) (map[string]interface{}, error) {
+	apiUrl := gc.endpoint("api", "v1", "provisioning", "policies")
+
+	resp, err := gc.createRequest(ctx, apiUrl, "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		gc.Logger.Error(
+			"error fetching notification policy",
+			slog.Int("status", resp.StatusCode),
+			slog.String("error", string(body)),
+		)
+		return nil, statusError(resp.StatusCode, body)
+	}
+
+	tree, err := unmarshalDashboard(body)
+	if err != nil {
+		return nil, err
+	}
+	delete(tree, "provenance")
+	return tree, nil
+}
+
+// Replaces the whole notification policy tree
+func (gc *GrafanaClient) putNotificationPolicy(ctx context.Context, tree map[string]interface{}) error {
+	apiUrl := gc.endpoint("api", "v1", "provisioning", "policies")
+	payload, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+
+	resp, err := gc.createRequestWithHeaders(ctx, apiUrl, "PUT", payload, disableProvenance)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status=%d, body=%s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// Downloads the notification policy tree into the alerting path
+func (gc *GrafanaClient) PullNotificationPolicy(ctx context.Context, alertingPath string) AlertingResult {
+	result := AlertingResult{Name: "notification policy", FilePath: PolicyFilePath(alertingPath)}
+
+	tree, err := gc.GetNotificationPolicy(ctx)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	local, err := ReadPolicyFile(result.FilePath)
+	switch {
+	case os.IsNotExist(err):
+		result.Status = "created"
+	case err != nil:
+		result.Err = err
+		return result
+	case reflect.DeepEqual(local, tree):
+		result.Status = "unchanged"
+		return result
+	default:
+		result.Status = "updated"
+	}
+
+	if err := writeResourceFile(result.FilePath, tree); err != nil {
+		result.Err = err
+	}
+	return result
+}
+
+// Compares the local notification policy tree with Grafana
+func (gc *GrafanaClient) DiffNotificationPolicy(ctx context.Context, filePath string) ([]gdiff.Change, error) {
+	local, err := ReadPolicyFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := gc.GetNotificationPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return gdiff.Compare(local, tree), nil
+}
+
+// Replaces the notification policy tree in Grafana with the local file
+func (gc *GrafanaClient) PushNotificationPolicy(ctx context.Context, filePath string, dryRun bool) AlertingResult {
+	result := AlertingResult{Name: "notification policy", FilePath: filePath}
+
+	local, err := ReadPolicyFile(filePath)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	tree, err := gc.GetNotificationPolicy(ctx)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Changes = gdiff.Compare(tree, local)
+	if len(result.Changes) == 0 {
+		result.Status = "unchanged"
+		return result
+	}
+	result.Status = "updated"
+
+	if dryRun {
+		return result
+	}
+
+	if err := gc.putNotificationPolicy(ctx, local); err != nil {
+		result.Err = err
+		return result
+	}
+	gc.Logger.Info("Notification policy updated", slog.String("path", filePath))
+	return result
+}
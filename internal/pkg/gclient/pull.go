@@ -0,0 +1,279 @@
+package gclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/alex067/gsync/internal/pkg/gapi"
+)
+
+var DefaultNamingScheme = "{folder}/{slug}.json"
+
+var ErrPathOutsideDashboards = fmt.Errorf("dashboard file path outside of the dashboards path")
+
+// What we expect from the Grafana search API
+type GrafanaSearchResult = gapi.SearchResult
+
+type GrafanaSearchQuery struct {
+	Query      string
+	Tags       []string
+	FolderUids []string
+	Uids       []string
+}
+
+type PullOptions struct {
+	DashboardsPath string
+	// File path relative to the dashboards path, ex: {folder}/{slug}.json
+	NamingScheme string
+	// Places dashboards in the directory mirroring their folder when set
+	Mirror *FolderMirror
+}
+
+type PullResult struct {
+	Uid      string
+	Title    string
+	FilePath string
+	// created, updated or unchanged
+	Status string
+	// Grafana version the file was pulled from
+	Version int
+	Err     error
+}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Lowercases and hyphenates a title the way Grafana builds dashboard slugs
+func Slugify(title string) string {
+	return strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(title), "-"), "-")
+}
+
+// Searches Grafana for dashboards
+func (gc *GrafanaClient) SearchDashboards(ctx context.Context, query GrafanaSearchQuery) ([]GrafanaSearchResult, error) {
+	results, err := gc.api().Search(ctx, gapi.SearchQuery{
+		Type:          gapi.SearchTypeDashboard,
+		Query:         query.Query,
+		Tags:          query.Tags,
+		FolderUids:    query.FolderUids,
+		DashboardUids: query.Uids,
+	})
+	return results, apiError(err)
+}
+
+// Maps dashboard uids to the local files holding them
+func IndexDashboardFiles(dashboardsPath string) (map[string]string, error) {
+	dashboardFiles := make(map[string]string)
+	err := filepath.Walk(dashboardsPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == LibraryPanelDirectory {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		dashboardFileData, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// Skip json files that are not dashboards
+		dashboard, _, err := parseDashboardFile(dashboardFileData)
+		if err != nil {
+			return nil
+		}
+		if uid, _ := dashboard["uid"].(string); uid != "" {
+			dashboardFiles[uid] = path
+		}
+		return nil
+	})
+	return dashboardFiles, err
+}
+
+var unsafePathPattern = regexp.MustCompile(`[/\\:]+`)
+
+// Makes a Grafana value safe to use as a single path segment
+// Separators are replaced and dot only names, ex: .., are dropped
+func pathSegment(value string) string {
+	value = strings.TrimSpace(unsafePathPattern.ReplaceAllString(value, "-"))
+	if strings.Trim(value, ".") == "" {
+		return ""
+	}
+	return value
+}
+
+// Builds the local file path of a pulled dashboard from the naming scheme
+// Values from Grafana never add directories, paths leaving the dashboards
+// path are rejected
+func dashboardFilePath(options PullOptions, dashboard *GrafanaDashboard) (string, error) {
+	namingScheme := options.NamingScheme
+	if namingScheme == "" {
+		namingScheme = DefaultNamingScheme
+	}
+
+	title, _ := dashboard.Dashboard["title"].(string)
+	uid, _ := dashboard.Dashboard["uid"].(string)
+	uid = pathSegment(uid)
+	slug := pathSegment(dashboard.Meta.Slug)
+	if slug == "" {
+		slug = Slugify(title)
+	}
+	// Titles made only of symbols, ex: .., still need a file name
+	if slug == "" {
+		slug = uid
+	}
+	title = pathSegment(title)
+	if title == "" {
+		title = slug
+	}
+	folderUid := dashboard.Meta.FolderUid
+	folderTitle := dashboard.Meta.FolderTitle
+	// Dashboards in the General folder live at the root of the dashboards path
+	if folderUid == "" {
+		folderTitle = ""
+	}
+
+	folderDir := Slugify(folderTitle)
+	if options.Mirror != nil {
+		if dir, ok := options.Mirror.DirForFolder(folderUid); ok {
+			folderDir = dir
+		}
+	}
+
+	replacer := strings.NewReplacer(
+		"{folder}", folderDir,
+		"{folderTitle}", pathSegment(folderTitle),
+		"{folderUid}", pathSegment(folderUid),
+		"{slug}", slug,
+		"{uid}", uid,
+		"{title}", title,
+	)
+	filePath := filepath.Join(options.DashboardsPath, replacer.Replace(namingScheme))
+
+	// Mirrored directories and the naming scheme come from the config, check them anyway
+	relativePath, err := filepath.Rel(options.DashboardsPath, filePath)
+	if err != nil || relativePath == "." || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrPathOutsideDashboards, filePath)
+	}
+	return filePath, nil
+}
+
+// Picks the file of a dashboard new to the dashboards path
+// A file owned by another dashboard, ex: same title in another folder, is
+// never overwritten, the uid is added to the name instead
+func newDashboardFilePath(options PullOptions, dashboard *GrafanaDashboard, uid string) (string, error) {
+	filePath, err := dashboardFilePath(options, dashboard)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return filePath, nil
+	}
+
+	extension := filepath.Ext(filePath)
+	uniquePath := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(filePath, extension), pathSegment(uid), extension)
+	if _, err := os.Stat(uniquePath); !os.IsNotExist(err) {
+		return "", fmt.Errorf("%s and %s already exist, set a naming scheme with {uid}", filePath, uniquePath)
+	}
+	return uniquePath, nil
+}
+
+// Downloads a dashboard into the dashboards path
+// Existing files are updated in place with the same version bump rules used by
+// the watcher, keeping the local id and never falling behind the Grafana version
+// New files are added to dashboardFiles so later pulls do not reuse the path
+func (gc *GrafanaClient) PullDashboard(ctx context.Context, uid string, options PullOptions, dashboardFiles map[string]string) PullResult {
+	result := PullResult{Uid: uid}
+
+	// Existing files keep their schema, the dashboard is fetched in it
+	format := DashboardV1
+	var dashboardFileData []byte
+	var localDashboard map[string]interface{}
+	filePath, exists := dashboardFiles[uid]
+	if exists {
+		var err error
+		dashboardFileData, err = os.ReadFile(filePath)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		localDashboard, format, err = parseDashboardFile(dashboardFileData)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	dashboard, err := gc.GetDashboardInFormat(ctx, uid, format)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Title, _ = dashboard.Dashboard["title"].(string)
+	result.Version = dashboard.Meta.Version
+
+	pulledDashboard := dashboard.Dashboard
+	// Instance specific ids are not kept in normalized files, v2 specs have none
+	if format == DashboardV1 {
+		pulledDashboard["id"] = nil
+	}
+
+	if !exists {
+		if filePath, err = newDashboardFilePath(options, dashboard, uid); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	if _, err := gc.saveLibraryPanels(ctx, pulledDashboard, filePath); err != nil {
+		result.Err = err
+		return result
+	}
+
+	if exists {
+		if format == DashboardV1 {
+			pulledDashboard["id"] = localDashboard["id"]
+		}
+		remoteVersion, _ := pulledDashboard["version"].(float64)
+		localVersion, _ := localDashboard["version"].(float64)
+		pulledDashboard["version"] = localDashboard["version"]
+
+		if reflect.DeepEqual(pulledDashboard, localDashboard) {
+			result.FilePath = filePath
+			result.Status = "unchanged"
+			return result
+		}
+		pulledDashboard["version"] = max(localVersion+1, remoteVersion)
+		result.Status = "updated"
+	} else {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			result.Err = err
+			return result
+		}
+		result.Status = "created"
+	}
+	result.FilePath = filePath
+
+	dashboardJson, err := marshalDashboardFile(pulledDashboard, format, dashboardFileData)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if err := os.WriteFile(filePath, dashboardJson, 0644); err != nil {
+		result.Err = err
+		return result
+	}
+	dashboardFiles[uid] = filePath
+
+	gc.Logger.Info(
+		fmt.Sprintf("Dashboard %s", result.Status),
+		slog.String("uid", uid),
+		slog.String("path", filePath))
+	return result
+}
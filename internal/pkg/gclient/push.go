@@ -0,0 +1,166 @@
+package gclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+)
+
+var ErrRemoteNewer = fmt.Errorf("newer dashboard version in Grafana")
+
+type PushOptions struct {
+	// Folder for the dashboard, existing dashboards keep their folder when empty
+	FolderUid string
+	// Directory of a mirrored folder that does not exist yet, dry runs only
+	NewFolder string
+	Message   string
+	DryRun    bool
+	// Overwrites newer dashboard versions in Grafana
+	Force bool
+	// Last pull or push of the file recorded in the config, zero when unknown
+	Synced gcontext.GContextGrafanaResource
+}
+
+type PushResult struct {
+	FilePath string
+	Uid      string
+	// created, updated, unchanged or refused
+	Status        string
+	LocalVersion  int
+	RemoteVersion int
+	Changes       []gdiff.Change
+	// Directory whose folder the push would create
+	NewFolder string
+	Err       error
+}
+
+// Deploys the local dashboard file to its real uid
+// Refuses to overwrite versions saved in Grafana since the file was last synced
+// unless forced, a successful push records the new Grafana version in the local file
+func (gc *GrafanaClient) PushDashboard(ctx context.Context, filePath string, options PushOptions) PushResult {
+	result := PushResult{FilePath: filePath}
+
+	dashboardFileData, err := os.ReadFile(filePath)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	localDashboard, format, err := parseDashboardFile(dashboardFileData)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Uid, _ = localDashboard["uid"].(string)
+	if result.Uid == "" {
+		result.Err = fmt.Errorf("dashboard uid attribute not found in given config file")
+		return result
+	}
+	localVersion, _ := localDashboard["version"].(float64)
+	result.LocalVersion = int(localVersion)
+
+	folderUid := options.FolderUid
+	result.NewFolder = options.NewFolder
+	pushedDashboard := copyDashboard(localDashboard)
+	pushedDashboard["id"] = nil
+
+	remoteDashboard, err := gc.GetDashboardInFormat(ctx, result.Uid, format)
+	switch {
+	case err == ErrDashboardNotFound:
+		remoteDashboard = nil
+		delete(pushedDashboard, "version")
+		result.Status = "created"
+	case err != nil:
+		result.Err = err
+		return result
+	default:
+		result.RemoteVersion = remoteDashboard.Meta.Version
+
+		// Instance specific attributes are not edits
+		remote := copyDashboard(remoteDashboard.Dashboard)
+		if format == DashboardV1 {
+			remote["id"] = localDashboard["id"]
+		}
+		remote["version"] = localDashboard["version"]
+		result.Changes = gdiff.Compare(remote, localDashboard)
+
+		isMoved := options.NewFolder != "" || (folderUid != "" && folderUid != remoteDashboard.Meta.FolderUid)
+		if len(result.Changes) == 0 && !isMoved {
+			result.Status = "unchanged"
+			return result
+		}
+
+		if err := checkRemoteNewer(result, options); err != nil && !options.Force {
+			result.Status = "refused"
+			result.Err = err
+			return result
+		}
+
+		if folderUid == "" {
+			folderUid = remoteDashboard.Meta.FolderUid
+		}
+		// Grafana rejects the save if the dashboard changes again before it lands
+		pushedDashboard["version"] = result.RemoteVersion
+		result.Status = "updated"
+	}
+
+	if options.DryRun {
+		return result
+	}
+	if options.NewFolder != "" {
+		result.Err = fmt.Errorf("%w %s", ErrFolderNotFound, options.NewFolder)
+		return result
+	}
+
+	version, err := gc.saveDashboardInFormat(ctx, format, pushedDashboard, folderUid, options.Message, options.Force, remoteDashboard)
+	if err != nil {
+		if errors.Is(err, ErrVersionMismatch) {
+			result.Status = "refused"
+		}
+		result.Err = err
+		return result
+	}
+	result.RemoteVersion = version
+
+	// Track the Grafana version so the next push compares against it
+	if result.LocalVersion != version {
+		localDashboard["version"] = version
+		dashboardJson, err := marshalDashboardFile(localDashboard, format, dashboardFileData)
+		if err != nil {
+			result.Err = err
+			return result
+		}
+		if err := os.WriteFile(filePath, dashboardJson, 0644); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	gc.Logger.Info(
+		fmt.Sprintf("Dashboard %s", result.Status),
+		slog.String("uid", result.Uid),
+		slog.String("path", filePath),
+		slog.Int("version", version))
+	return result
+}
+
+// Reports versions saved in Grafana since the file was last pulled or pushed
+// The local version moves on its own, ex: watchers bump it on every save, so it
+// is only compared when the config has no record of the file
+func checkRemoteNewer(result PushResult, options PushOptions) error {
+	if options.Synced.Uid == result.Uid && options.Synced.Version != 0 {
+		if result.RemoteVersion != options.Synced.Version {
+			return fmt.Errorf("%w: synced=%d, remote=%d", ErrRemoteNewer, options.Synced.Version, result.RemoteVersion)
+		}
+		return nil
+	}
+	if result.RemoteVersion > result.LocalVersion {
+		return fmt.Errorf("%w: local=%d, remote=%d", ErrRemoteNewer, result.LocalVersion, result.RemoteVersion)
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+package gclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/alex067/gsync/internal/pkg/gapi"
+)
+
+// Schema of a dashboard file or resource
+type DashboardFormat string
+
+const (
+	// Classic dashboard JSON model
+	DashboardV1 DashboardFormat = "v1"
+	// Kubernetes style resource holding the v2 schema in its spec
+	DashboardV2 DashboardFormat = "v2"
+)
+
+const dashboardApiGroup = "dashboard.grafana.app"
+
+// Resource annotations read and written by gsync
+const (
+	folderAnnotation  = "grafana.app/folder"
+	messageAnnotation = "grafana.app/message"
+	// v2 specs are validated strictly, watcher metadata is kept in an annotation
+	watcherAnnotation = "gsync.watcher"
+)
+
+// Served versions of the dashboard API, most stable first
+var dashboardApiVersions = map[DashboardFormat][]string{
+	DashboardV1: {"v1", "v1beta1", "v1alpha1", "v0alpha1"},
+	DashboardV2: {"v2", "v2beta1", "v2alpha1"},
+}
+
+// Kubernetes style dashboard API of newer Grafana versions
+type DashboardResourceApi struct {
+	Namespace string
+	// Served API version per schema, ex: v1beta1 and v2beta1
+	Versions map[DashboardFormat]string
+}
+
+type DashboardResource struct {
+	ApiVersion string                    `json:"apiVersion"`
+	Kind       string                    `json:"kind"`
+	Metadata   DashboardResourceMetadata `json:"metadata"`
+	Spec       map[string]interface{}    `json:"spec"`
+}
+
+type DashboardResourceMetadata struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Generation      int               `json:"generation,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+var errResourceApiNotServed = fmt.Errorf("dashboard resource API not served")
+
+// Detects the dashboard API once per client
+// Returns nil when Grafana only serves the legacy API or it is disabled, failed
+// detections are not kept and run again on the next call
+func (gc *GrafanaClient) resourceApi(ctx context.Context) *DashboardResourceApi {
+	gc.resourceApiMutex.Lock()
+	defer gc.resourceApiMutex.Unlock()
+	if gc.LegacyDashboardApi || gc.isResourceApiChecked {
+		return gc.dashboardResourceApi
+	}
+
+	api, err := gc.detectResourceApi(ctx)
+	if err != nil && !errors.Is(err, errResourceApiNotServed) {
+		gc.Logger.Warn("error detecting the dashboard resource API, using the legacy API", slog.String("error", err.Error()))
+		return nil
+	}
+	gc.isResourceApiChecked = true
+	if err != nil {
+		gc.Logger.Debug("dashboard resource API not available", slog.String("error", err.Error()))
+		return nil
+	}
+	gc.Logger.Info(
+		"Using the dashboard resource API",
+		slog.String("namespace", api.Namespace),
+		slog.String("v1", api.Versions[DashboardV1]),
+		slog.String("v2", api.Versions[DashboardV2]))
+	gc.dashboardResourceApi = api
+	return api
+}
+
+func (gc *GrafanaClient) detectResourceApi(ctx context.Context) (*DashboardResourceApi, error) {
+	var group struct {
+		Versions []struct {
+			Version string `json:"version"`
+		} `json:"versions"`
+	}
+	err := gc.api().Do(ctx, "GET", gapi.Path("apis", dashboardApiGroup), nil, nil, &group)
+	if gapi.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %w", errResourceApiNotServed, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	served := make(map[string]bool)
+	for _, version := range group.Versions {
+		served[version.Version] = true
+	}
+	api := &DashboardResourceApi{Versions: make(map[DashboardFormat]string)}
+	for format, versions := range dashboardApiVersions {
+		for _, version := range versions {
+			if served[version] {
+				api.Versions[format] = version
+				break
+			}
+		}
+	}
+	if api.Versions[DashboardV1] == "" {
+		return nil, fmt.Errorf("%w: no supported %s version", errResourceApiNotServed, dashboardApiGroup)
+	}
+
+	// Namespaces are named after the org, or the stack on Grafana Cloud
+	var settings struct {
+		Namespace string `json:"namespace"`
+	}
+	err = gc.api().Do(ctx, "GET", gapi.Path("api", "frontend", "settings"), nil, nil, &settings)
+	if err == nil && settings.Namespace != "" {
+		api.Namespace = settings.Namespace
+	} else if gc.TenantId == "" || gc.TenantId == "1" {
+		api.Namespace = "default"
+	} else {
+		api.Namespace = fmt.Sprintf("org-%s", gc.TenantId)
+	}
+	return api, nil
+}
+
+// Path of the dashboard collection, or of the named dashboard when given
+func (api *DashboardResourceApi) path(format DashboardFormat, name string) string {
+	segments := []string{"apis", dashboardApiGroup, api.Versions[format], "namespaces", api.Namespace, "dashboards"}
+	if name != "" {
+		segments = append(segments, name)
+	}
+	return gapi.Path(segments...)
+}
+
+func (gc *GrafanaClient) getDashboardResource(ctx context.Context, format DashboardFormat, name string) (*DashboardResource, error) {
+	var resource DashboardResource
+	err := gc.api().Do(ctx, "GET", gc.resourceApi(ctx).path(format, name), nil, nil, &resource)
+	if gapi.IsNotFound(err) {
+		return nil, ErrDashboardNotFound
+	}
+	if err != nil {
+		gc.Logger.Error(
+			"error fetching dashboard resource",
+			slog.String("name", name),
+			slog.String("error", err.Error()),
+		)
+		return nil, apiError(err)
+	}
+	return &resource, nil
+}
+
+// Creates the dashboard resource, or replaces it when isUpdate is set
+// Updates without a resourceVersion overwrite the stored dashboard
+func (gc *GrafanaClient) saveDashboardResource(ctx context.Context, format DashboardFormat, resource *DashboardResource, isUpdate bool) (*DashboardResource, error) {
+	api := gc.resourceApi(ctx)
+	resource.ApiVersion = fmt.Sprintf("%s/%s", dashboardApiGroup, api.Versions[format])
+	resource.Kind = "Dashboard"
+	resource.Metadata.Namespace = api.Namespace
+
+	path := api.path(format, "")
+	method := "POST"
+	if isUpdate {
+		path = api.path(format, resource.Metadata.Name)
+		method = "PUT"
+	}
+
+	var saved DashboardResource
+	err := gc.api().Do(ctx, method, path, nil, resource, &saved)
+	if gapi.IsStatus(err, http.StatusConflict) {
+		return nil, fmt.Errorf("%w: %w", ErrVersionMismatch, err)
+	}
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return &saved, nil
+}
+
+func (gc *GrafanaClient) deleteDashboardResource(ctx context.Context, name string) error {
+	err := gc.api().Do(ctx, "DELETE", gc.resourceApi(ctx).path(DashboardV1, name), nil, nil, nil)
+	if gapi.IsNotFound(err) {
+		return ErrDashboardNotFound
+	}
+	return apiError(err)
+}
+
+// Converts a dashboard resource into the model the watchers work with
+// The uid, version and watcher metadata are moved from the resource metadata
+// into the model, the resourceVersion is kept to detect changes
+func resourceToDashboard(resource *DashboardResource) *GrafanaDashboard {
+	dashboard := copyDashboard(resource.Spec)
+	dashboard["uid"] = resource.Metadata.Name
+	dashboard["version"] = float64(resource.Metadata.Generation)
+	if value, ok := resource.Metadata.Annotations[watcherAnnotation]; ok {
+		var metadata map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &metadata); err == nil {
+			dashboard[watcherMetadataKey] = metadata
+		}
+	}
+
+	return &GrafanaDashboard{
+		Meta: gapi.DashboardMeta{
+			Version:   resource.Metadata.Generation,
+			FolderUid: resource.Metadata.Annotations[folderAnnotation],
+		},
+		Dashboard:       dashboard,
+		ResourceVersion: resource.Metadata.ResourceVersion,
+	}
+}
+
+// Converts a watcher model into a dashboard resource of the given schema
+func dashboardToResource(format DashboardFormat, dashboard map[string]interface{}, folderUid string) *DashboardResource {
+	spec := copyDashboard(dashboard)
+	uid, _ := spec["uid"].(string)
+	for _, key := range []string{"uid", "version", "id"} {
+		delete(spec, key)
+	}
+
+	resource := &DashboardResource{
+		Metadata: DashboardResourceMetadata{Name: uid, Annotations: make(map[string]string)},
+		Spec:     spec,
+	}
+	if folderUid != "" {
+		resource.Metadata.Annotations[folderAnnotation] = folderUid
+	}
+	if format == DashboardV2 {
+		if metadata, ok := spec[watcherMetadataKey]; ok {
+			content, _ := json.Marshal(metadata)
+			resource.Metadata.Annotations[watcherAnnotation] = string(content)
+			delete(spec, watcherMetadataKey)
+		}
+	}
+	return resource
+}
+
+// Reads a dashboard file of either schema into the watcher model
+// v2 files are dashboard resources, their name and generation become the
+// uid and version of the model
+func parseDashboardFile(content []byte) (map[string]interface{}, DashboardFormat, error) {
+	file, err := unmarshalDashboard(content)
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiVersion, _ := file["apiVersion"].(string)
+	spec, isResource := file["spec"].(map[string]interface{})
+	if !isResource || !strings.HasPrefix(apiVersion, dashboardApiGroup+"/v2") {
+		return file, DashboardV1, nil
+	}
+
+	dashboard := copyDashboard(spec)
+	metadata, _ := file["metadata"].(map[string]interface{})
+	dashboard["uid"], _ = metadata["name"].(string)
+	generation, _ := metadata["generation"].(float64)
+	dashboard["version"] = generation
+	return dashboard, DashboardV2, nil
+}
+
+// Reads a local dashboard file of either schema into the v1 dashboard model
+// Commands reading the uid go through here so v2 files work the same
+func ReadDashboardFile(filePath string) (map[string]interface{}, error) {
+	dashboard, _, err := ReadDashboardFileFormat(filePath)
+	return dashboard, err
+}
+
+// Reads a local dashboard file like ReadDashboardFile, along with its schema
+// to fetch the Grafana side in, see GetDashboardInFormat
+func ReadDashboardFileFormat(filePath string) (map[string]interface{}, DashboardFormat, error) {
+	dashboardFileData, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	return parseDashboardFile(dashboardFileData)
+}
+
+// Renders the watcher model in the schema of the file
+// v2 files keep the rest of their resource metadata from the current content
+func marshalDashboardFile(dashboard map[string]interface{}, format DashboardFormat, current []byte) ([]byte, error) {
+	if format != DashboardV2 {
+		return json.MarshalIndent(dashboard, "", "\t")
+	}
+
+	file, err := unmarshalDashboard(current)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _ := file["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	metadata["name"] = dashboard["uid"]
+	metadata["generation"] = dashboard["version"]
+	file["metadata"] = metadata
+
+	spec := copyDashboard(dashboard)
+	for _, key := range []string{"uid", "version", "id"} {
+		delete(spec, key)
+	}
+	file["spec"] = spec
+	return json.MarshalIndent(file, "", "\t")
+}
+
+// Fetches the watcher dashboard, through the resource API when Grafana serves it
+func (gc *GrafanaClient) getWatcherDashboard(ctx context.Context, dbClient *GrafanaDashboardClient) (*GrafanaDashboard, error) {
+	if gc.resourceApi(ctx) == nil {
+		return gc.GetDashboard(ctx, dbClient.Uid)
+	}
+	resource, err := gc.getDashboardResource(ctx, dbClient.format(), dbClient.Uid)
+	if err != nil {
+		return nil, err
+	}
+	return resourceToDashboard(resource), nil
+}
+
+// Creates or updates the watcher dashboard from the watcher model
+// Without overwrite, updates are refused with ErrVersionMismatch when the
+// watcher changed since the model version, or since resourceVersion for the
+// resource API. Returns the saved watcher, meta holds the new version
+func (gc *GrafanaClient) saveWatcherDashboard(
+	ctx context.Context,
+	format DashboardFormat,
+	dashboard map[string]interface{},
+	folderUid string,
+	message string,
+	isUpdate bool,
+	overwrite bool,
+	resourceVersion string,
+) (*GrafanaDashboard, error) {
+	if gc.resourceApi(ctx) == nil {
+		if err := gc.checkFormatServed(ctx, format); err != nil {
+			return nil, err
+		}
+		result, err := gc.saveDashboard(ctx, dashboard, folderUid, message, overwrite)
+		if err != nil {
+			return nil, err
+		}
+		return &GrafanaDashboard{
+			Meta:      gapi.DashboardMeta{Version: result.Version, FolderUid: folderUid},
+			Dashboard: dashboard,
+		}, nil
+	}
+
+	if err := gc.checkFormatServed(ctx, format); err != nil {
+		return nil, err
+	}
+	resource := dashboardToResource(format, dashboard, folderUid)
+	resource.Metadata.ResourceVersion = resourceVersion
+	saved, err := gc.saveDashboardResource(ctx, format, resource, isUpdate)
+	if err != nil {
+		return nil, err
+	}
+	return resourceToDashboard(saved), nil
+}
+
+// Reports an error when Grafana cannot serve dashboards of the schema
+// The legacy API only serves v1 models
+func (gc *GrafanaClient) checkFormatServed(ctx context.Context, format DashboardFormat) error {
+	if format != DashboardV2 {
+		return nil
+	}
+	api := gc.resourceApi(ctx)
+	if api == nil {
+		return fmt.Errorf("v2 dashboard files need the %s API, not served by this Grafana", dashboardApiGroup)
+	}
+	if api.Versions[DashboardV2] == "" {
+		return fmt.Errorf("v2 dashboard files need a v2 version of the %s API", dashboardApiGroup)
+	}
+	return nil
+}
+
+// Fetches a dashboard in the schema of its local file
+// v2 files go through the v2 resource API, v1 files through the legacy API
+func (gc *GrafanaClient) GetDashboardInFormat(ctx context.Context, uid string, format DashboardFormat) (*GrafanaDashboard, error) {
+	if format != DashboardV2 {
+		return gc.GetDashboard(ctx, uid)
+	}
+	if err := gc.checkFormatServed(ctx, format); err != nil {
+		return nil, err
+	}
+	resource, err := gc.getDashboardResource(ctx, format, uid)
+	if err != nil {
+		return nil, err
+	}
+	return resourceToDashboard(resource), nil
+}
+
+// Creates or updates a dashboard in the schema of its local file, returns the
+// saved version. Updates without overwrite are refused with ErrVersionMismatch
+// when the dashboard changed since remote was fetched, remote is nil for new dashboards
+func (gc *GrafanaClient) saveDashboardInFormat(
+	ctx context.Context,
+	format DashboardFormat,
+	dashboard map[string]interface{},
+	folderUid string,
+	message string,
+	overwrite bool,
+	remote *GrafanaDashboard,
+) (int, error) {
+	if format != DashboardV2 {
+		result, err := gc.saveDashboard(ctx, dashboard, folderUid, message, overwrite)
+		if err != nil {
+			return 0, err
+		}
+		return result.Version, nil
+	}
+
+	if err := gc.checkFormatServed(ctx, format); err != nil {
+		return 0, err
+	}
+	resource := dashboardToResource(format, dashboard, folderUid)
+	if message != "" {
+		resource.Metadata.Annotations[messageAnnotation] = message
+	}
+	if remote != nil && !overwrite {
+		resource.Metadata.ResourceVersion = remote.ResourceVersion
+	}
+	saved, err := gc.saveDashboardResource(ctx, format, resource, remote != nil)
+	if err != nil {
+		return 0, err
+	}
+	return saved.Metadata.Generation, nil
+}
+
+func (gc *GrafanaClient) isWatcherExist(ctx context.Context, dbClient *GrafanaDashboardClient, uid string) (bool, error) {
+	if gc.resourceApi(ctx) == nil {
+		return gc.isDashboardExist(ctx, uid)
+	}
+	_, err := gc.getDashboardResource(ctx, dbClient.format(), uid)
+	if err == ErrDashboardNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Schema of the watcher, files without a known schema are v1
+func (gcd *GrafanaDashboardClient) format() DashboardFormat {
+	if gcd.Format == "" {
+		return DashboardV1
+	}
+	return gcd.Format
+}
@@ -0,0 +1,280 @@
+package gclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/alex067/gsync/internal/pkg/gapi"
+)
+
+// Failures worth trying again later, ex: Grafana restarting or rate limiting
+var ErrRetryable = fmt.Errorf("retryable request failure")
+
+// Failures retrying cannot fix, ex: an invalid token or a malformed request
+var ErrFatal = fmt.Errorf("fatal request failure")
+
+// Returned once every watcher of a session stopped
+var ErrWatchersStopped = fmt.Errorf("every watcher stopped")
+
+func retryable(err error) error {
+	return fmt.Errorf("%w: %w", ErrRetryable, err)
+}
+
+func fatal(err error) error {
+	return fmt.Errorf("%w: %w", ErrFatal, err)
+}
+
+// Reports whether the failure may go away on its own
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRetryable)
+}
+
+// Reports whether the failure needs a fix before trying again
+func IsFatal(err error) bool {
+	return errors.Is(err, ErrFatal)
+}
+
+// Classifies an unexpected response status
+func statusError(status int, body []byte) error {
+	return classifyStatus(status, fmt.Errorf("status=%d, body=%s", status, string(body)))
+}
+
+func classifyStatus(status int, err error) error {
+	if isRetryableStatus(status) || status >= http.StatusInternalServerError {
+		return retryable(err)
+	}
+	return fatal(err)
+}
+
+// Classifies errors of the typed API client
+// Request failures come classified from the retrying doer, anything else,
+// ex: an undecodable response, does not go away by retrying
+func apiError(err error) error {
+	var apiErr *gapi.APIError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &apiErr):
+		return classifyStatus(apiErr.StatusCode, err)
+	case IsRetryable(err) || IsFatal(err):
+		return err
+	}
+	return fatal(err)
+}
+
+// How requests are retried before the failure reaches the caller
+type RetryPolicy struct {
+	// Attempts per request, including the first one
+	MaxAttempts int
+	// Delay before the first retry, doubled on every attempt
+	BaseDelay time.Duration
+	// Upper bound of any delay, Retry-After included
+	MaxDelay time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+func (gc *GrafanaClient) retryPolicy() RetryPolicy {
+	if gc.Retry.MaxAttempts == 0 {
+		return DefaultRetryPolicy
+	}
+	return gc.Retry
+}
+
+// Exponential backoff with full jitter, spreads the retries of many watchers
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << attempt
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// Statuses returned before Grafana handled the request, safe to retry for any method
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// Requests Grafana may apply twice without harm
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// Network failures that may go away on their own
+func isRetryableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// Delay asked for through the Retry-After header, in seconds or as a date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// Sends the request, retrying failures the policy allows
+// The response body is read up front so truncated bodies are retried too
+func (gc *GrafanaClient) doWithRetry(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	policy := gc.retryPolicy()
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, fatal(err)
+		}
+		isLastAttempt := attempt+1 >= policy.MaxAttempts
+
+		resp, err := gc.HttpClient.Do(req)
+		if err == nil {
+			var body []byte
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if err != nil {
+			// Grafana may have applied the request before the connection dropped
+			// Cancelled requests are never retried, even when they timed out
+			if ctx.Err() != nil || !isRetryableError(err) || !isIdempotent(req.Method) {
+				return nil, fatal(err)
+			}
+			if isLastAttempt {
+				return nil, retryable(err)
+			}
+			if err := gc.waitForRetry(ctx, req, attempt, policy.backoff(attempt), slog.String("error", err.Error())); err != nil {
+				return nil, fatal(err)
+			}
+			continue
+		}
+
+		canRetry := isRetryableStatus(resp.StatusCode) ||
+			(resp.StatusCode >= http.StatusInternalServerError && isIdempotent(req.Method))
+		if !canRetry || isLastAttempt {
+			return resp, nil
+		}
+
+		delay := policy.backoff(attempt)
+		if wait, ok := retryAfter(resp); ok {
+			delay = min(wait, policy.MaxDelay)
+		}
+		if err := gc.waitForRetry(ctx, req, attempt, delay, slog.Int("status", resp.StatusCode)); err != nil {
+			return nil, fatal(err)
+		}
+	}
+}
+
+// Sends the requests of the typed API client through doWithRetry
+type retryingDoer struct {
+	gc *GrafanaClient
+}
+
+func (d retryingDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.gc.doWithRetry(req.Context(), func() (*http.Request, error) {
+		attempt := req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+		return attempt, nil
+	})
+	if err != nil {
+		d.gc.Logger.Error(
+			"error making request",
+			slog.String("error", err.Error()),
+		)
+	}
+	return resp, err
+}
+
+// Sleeps before the next attempt, returns early once the context ends
+func (gc *GrafanaClient) waitForRetry(ctx context.Context, req *http.Request, attempt int, delay time.Duration, reason slog.Attr) error {
+	gc.Logger.Debug(
+		"retrying request",
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+		slog.Int("attempt", attempt+1),
+		slog.Duration("delay", delay),
+		reason)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Consecutive failed polls a watcher tolerates, every successful poll resets it
+type retryBudget struct {
+	failures int
+}
+
+// Records a failed poll, false once the budget is spent
+func (b *retryBudget) fail() bool {
+	b.failures += 1
+	return b.failures <= maxWatcherRetry
+}
+
+func (b *retryBudget) reset() {
+	b.failures = 0
+}
+
+// Spends the budget on a failed poll
+// Returns the error that stops the watcher, nil while retries remain
+func (gc *GrafanaClient) pollFailed(budget *retryBudget, filePath string, err error) error {
+	// Polls cut short by a shutdown are not failures, the loop stops on its own
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	if IsFatal(err) {
+		gc.Logger.Error("unrecoverable error, stopping watcher", slog.String("path", filePath), slog.String("error", err.Error()))
+		return err
+	}
+	if !budget.fail() {
+		gc.Logger.Error("max retries reached", slog.String("path", filePath))
+		return fmt.Errorf("max retries reached: %w", err)
+	}
+	gc.Logger.Info(
+		"error detected, attempting retry...",
+		slog.String("path", filePath),
+		slog.Int("retry", budget.failures))
+	return nil
+}
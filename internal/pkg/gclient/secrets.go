@@ -0,0 +1,92 @@
+package gclient
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Value Grafana returns in place of secure settings
+const redactedValue = "[REDACTED]"
+
+var ErrMissingSecret = fmt.Errorf("missing secret environment variables")
+
+// Whole value placeholders such as ${GSYNC_SLACK_URL}
+var secretPlaceholder = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+var envNamePattern = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// Builds the environment variable name holding a secret setting
+// ex: GSYNC_SECRET_ON_CALL_SLACK_URL
+func secretEnvName(parts ...string) string {
+	name := strings.ToUpper(strings.Join(append([]string{"gsync", "secret"}, parts...), "_"))
+	return strings.Trim(envNamePattern.ReplaceAllString(name, "_"), "_")
+}
+
+// Replaces redacted settings with environment placeholders for local files
+// Placeholders already in the local settings are kept so renamed variables stick
+func redactSettings(remote, local map[string]interface{}, envParts ...string) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(remote))
+	for key, value := range remote {
+		localValue := local[key]
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			localMap, _ := localValue.(map[string]interface{})
+			redacted[key] = redactSettings(typedValue, localMap, append(envParts, key)...)
+		case string:
+			if typedValue != redactedValue {
+				redacted[key] = typedValue
+				continue
+			}
+			if localString, ok := localValue.(string); ok && secretPlaceholder.MatchString(localString) {
+				redacted[key] = localString
+				continue
+			}
+			redacted[key] = fmt.Sprintf("${%s}", secretEnvName(append(envParts, key)...))
+		default:
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
+
+// Fills environment placeholders in with their values
+// Missing variables fail unless keepRedacted is set, then Grafana keeps the
+// stored secret for the redacted value
+func expandSettings(settings map[string]interface{}, keepRedacted bool) (map[string]interface{}, error) {
+	var missing []string
+	expanded := expandSettingValues(settings, keepRedacted, &missing)
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: %s", ErrMissingSecret, strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+func expandSettingValues(settings map[string]interface{}, keepRedacted bool, missing *[]string) map[string]interface{} {
+	expanded := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			expanded[key] = expandSettingValues(typedValue, keepRedacted, missing)
+		case string:
+			match := secretPlaceholder.FindStringSubmatch(typedValue)
+			if match == nil {
+				expanded[key] = typedValue
+				continue
+			}
+			if secret, ok := os.LookupEnv(match[1]); ok {
+				expanded[key] = secret
+			} else if keepRedacted {
+				expanded[key] = redactedValue
+			} else {
+				*missing = append(*missing, match[1])
+			}
+		default:
+			expanded[key] = value
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,78 @@
+package gclient
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alex067/gsync/internal/pkg/gcontext"
+	"github.com/alex067/gsync/internal/pkg/gdiff"
+)
+
+type WatcherStatus struct {
+	Context string `json:"context"`
+	Path    string `json:"path"`
+	Uid     string `json:"uid"`
+	Url     string `json:"url"`
+	// Watcher dashboard still exists in Grafana
+	Exists bool `json:"exists"`
+	// Grafana version of the watcher and the version in the local file
+	Version      int `json:"version"`
+	LocalVersion int `json:"localVersion"`
+	// Changes between the local file and the watcher dashboard
+	Drift []gdiff.Change `json:"drift"`
+	// Sides changed since the last recorded sync, both are unknown without one
+	SyncRecorded  bool `json:"syncRecorded"`
+	LocalChanged  bool `json:"localChanged"`
+	RemoteChanged bool `json:"remoteChanged"`
+	// gsync process recorded as owner, ex: 1234@laptop
+	Owner      string `json:"owner"`
+	OwnerAlive bool   `json:"ownerAlive"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Checks a recorded watcher against Grafana, the local file and its owner process
+func (gc *GrafanaClient) GetWatcherStatus(ctx context.Context, resource gcontext.GContextGrafanaResource) WatcherStatus {
+	status := WatcherStatus{
+		Path:       resource.Path,
+		Uid:        resource.Uid,
+		Url:        fmt.Sprintf("%s/d/%s", gc.Url, resource.Uid),
+		OwnerAlive: resource.IsOwnerAlive(),
+	}
+	if resource.Pid != 0 {
+		status.Owner = fmt.Sprintf("%d@%s", resource.Pid, resource.Host)
+	}
+
+	// Watchers of v2 files are v2 dashboards, read the file first for its schema
+	dashboardFileData, err := os.ReadFile(resource.Path)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	localDashboard, format, err := parseDashboardFile(dashboardFileData)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+
+	watcherDashboard, err := gc.GetDashboardInFormat(ctx, resource.Uid, format)
+	if err == ErrDashboardNotFound {
+		return status
+	} else if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Exists = true
+	status.Version = watcherDashboard.Meta.Version
+	localVersion, _ := localDashboard["version"].(float64)
+	status.LocalVersion = int(localVersion)
+
+	status.Drift = gdiff.Compare(localDashboard, RestoreLocalAttributes(localDashboard, watcherDashboard.Dashboard))
+
+	if resource.Hash != "" && resource.Version != 0 {
+		status.SyncRecorded = true
+		status.LocalChanged = hashContent(dashboardFileData) != resource.Hash
+		status.RemoteChanged = status.Version != resource.Version
+	}
+	return status
+}
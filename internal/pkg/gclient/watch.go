@@ -0,0 +1,60 @@
+package gclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Retries of a watcher before it is dropped
+const maxWatcherRetry = 3
+
+// Runs every poll on a shared ticker until the context ends
+// Each poll returns the error that stopped its watcher, stopped watchers are
+// skipped and the loop ends once none remain
+func (gc *GrafanaClient) runWatchLoop(ctx context.Context, polls []func() error) error {
+	// Start polling timer
+	ticker := time.NewTicker(gc.Interval)
+	defer ticker.Stop()
+
+	errs := make([]error, len(polls))
+	for {
+		select {
+		case <-ticker.C:
+			var wg sync.WaitGroup
+			for i, poll := range polls {
+				if errs[i] != nil {
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = poll()
+				}()
+			}
+			wg.Wait()
+
+			if err := watchersStopped(errs); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			gc.Logger.Info("Context cancelled")
+			return ctx.Err()
+		}
+	}
+}
+
+// Returns the error ending a session once every watcher stopped, nil while
+// any watcher remains
+// Single watcher sessions end with the watcher error
+func watchersStopped(errs []error) error {
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return ErrWatchersStopped
+}
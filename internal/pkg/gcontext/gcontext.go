@@ -13,6 +13,15 @@ import (
 type GContextGrafanaResource struct {
 	Uid  string `yaml:"uid"`
 	Path string `yaml:"path"`
+	// gsync process currently watching the resource
+	Pid  int    `yaml:"pid,omitempty"`
+	Host string `yaml:"host,omitempty"`
+	// Copy of the Grafana state replaced by resources edited in place
+	Backup string `yaml:"backup,omitempty"`
+	// Grafana version and file content hash of the last sync, watchers record
+	// them on every save so local edits can be told apart from gsync writes
+	Version int    `yaml:"version,omitempty"`
+	Hash    string `yaml:"hash,omitempty"`
 }
 
 type GContext struct {
@@ -27,15 +36,51 @@ type GContext struct {
 		Dashboards struct {
 			Path          string `yaml:"path"`
 			GrafanaTenant string `yaml:"tenant"`
+			// Pulled dashboard file names relative to the path, ex: {folder}/{slug}.json
+			NamingScheme string `yaml:"namingScheme,omitempty"`
+			// Contact points and notification policies, defaults to alerting under the path
+			AlertingPath string `yaml:"alertingPath,omitempty"`
+			// Datasource provisioning file, defaults to datasources.yaml under the path
+			DatasourcesFile string `yaml:"datasourcesFile,omitempty"`
+			// How saved dashboards keep library panels: reference (default), inline or files
+			LibraryPanels string `yaml:"libraryPanels,omitempty"`
+			// Keeps watchers on the legacy dashboard API even when Grafana serves
+			// the dashboard.grafana.app resource API
+			LegacyApi bool `yaml:"legacyApi,omitempty"`
+			// Real dashboards the files were last pulled from or pushed to, push
+			// refuses to overwrite versions saved in Grafana since then
+			Synced []GContextGrafanaResource `yaml:"synced,omitempty"`
+			// Mirrors directories under the path to Grafana folders on push and pull
+			Folders struct {
+				Mirror bool `yaml:"mirror"`
+				// Relative directory to folder uid, other directories map to folders by title
+				Mapping map[string]string `yaml:"mapping,omitempty"`
+			} `yaml:"folders,omitempty"`
 			// Stores the temp generated resources to watch over
 			GrafanResources struct {
 				FolderUid string                    `yaml:"folderUid"`
 				Resources []GContextGrafanaResource `yaml:"resources"`
+				// Temp alert rule copies, the local rule files live under the path as well
+				AlertRules    []GContextGrafanaResource `yaml:"alertRules,omitempty"`
+				ContactPoints []GContextGrafanaResource `yaml:"contactPoints,omitempty"`
+				// The notification policy tree is watched in place, see Backup
+				Policies []GContextGrafanaResource `yaml:"policies,omitempty"`
 			} `yaml:"watching"`
 		} `yaml:"dashboards"`
 	} `yaml:"context"`
 }
 
+// Kind of watched resource recorded in a context
+type ResourceKind int
+
+const (
+	DashboardResource ResourceKind = iota
+	AlertRuleResource
+	ContactPointResource
+	PolicyResource
+	SyncedDashboardResource
+)
+
 type GConfigContext struct {
 	Contexts       []GContext `yaml:"contexts"`
 	CurrentContext string     `yaml:"currentContext"`
@@ -73,9 +118,36 @@ func (c *GContext) TrimInputs() {
 	c.Context.Dashboards.Path = strings.TrimSpace(c.Context.Dashboards.Path)
 	c.Context.Dashboards.GrafanaTenant = strings.TrimSpace(c.Context.Dashboards.GrafanaTenant)
 	c.Context.Dashboards.GrafanResources.FolderUid = strings.TrimSpace(c.Context.Dashboards.GrafanResources.FolderUid)
+	c.Context.Dashboards.NamingScheme = strings.TrimSpace(c.Context.Dashboards.NamingScheme)
+	c.Context.Dashboards.AlertingPath = strings.TrimSpace(c.Context.Dashboards.AlertingPath)
+	c.Context.Dashboards.LibraryPanels = strings.TrimSpace(c.Context.Dashboards.LibraryPanels)
+	c.Context.Dashboards.DatasourcesFile = strings.TrimSpace(c.Context.Dashboards.DatasourcesFile)
+}
+
+// Directory holding contact point and notification policy files
+func (c *GContext) GetAlertingPath() string {
+	if c.Context.Dashboards.AlertingPath != "" {
+		return c.Context.Dashboards.AlertingPath
+	}
+	return filepath.Join(c.Context.Dashboards.Path, "alerting")
+}
+
+// Datasource provisioning file of the context
+func (c *GContext) GetDatasourcesFile() string {
+	if c.Context.Dashboards.DatasourcesFile != "" {
+		return c.Context.Dashboards.DatasourcesFile
+	}
+	return filepath.Join(c.Context.Dashboards.Path, "datasources.yaml")
 }
 
-func (c *GConfigContext) writeChangesToDisk() error {
+// Returned by resource changes that leave the config as is, nothing is written
+var errConfigUnchanged = fmt.Errorf("config unchanged")
+
+// Applies the change to the user config file
+// The file is read again under a lock so entries other gsync processes wrote
+// since this one read it are kept, only the changed entry is merged in. The
+// runtime context override of this process is never written
+func (c *GConfigContext) updateConfigFile(change func(config *GConfigContext) error) error {
 	dirname, err := os.UserHomeDir()
 	if err != nil {
 		return err
@@ -86,17 +158,73 @@ func (c *GConfigContext) writeChangesToDisk() error {
 		return err
 	}
 
-	file, err := os.OpenFile(absConfigPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	unlock, err := lockConfigFile(absConfigPath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	content, err := os.ReadFile(absConfigPath)
+	if err != nil {
+		return err
+	}
+	var config GConfigContext
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return err
+	}
+
+	if err := change(&config); err == errConfigUnchanged {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return writeConfigFile(absConfigPath, &config)
+}
+
+// Writes the config to a temp file renamed over the config file, readers
+// never see a partly written config
+func writeConfigFile(absConfigFilePath string, config *GConfigContext) error {
+	file, err := os.CreateTemp(filepath.Dir(absConfigFilePath), filepath.Base(absConfigFilePath)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer os.Remove(file.Name())
 
 	encoder := yaml.NewEncoder(file)
-	if err := encoder.Encode(c); err != nil {
+	if err := encoder.Encode(config); err != nil {
+		file.Close()
 		return err
 	}
-	return nil
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), absConfigFilePath)
+}
+
+// Applies the change to the resource list of the given kind in the current
+// context, in memory and in the config file, see updateConfigFile
+func (c *GConfigContext) updateResources(kind ResourceKind, change func(resources *[]GContextGrafanaResource) error) error {
+	resources := c.currentResources(kind)
+	if resources == nil {
+		return fmt.Errorf("current context not found in config")
+	}
+	if err := change(resources); err == errConfigUnchanged {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	contextName := c.CurrentContext
+	return c.updateConfigFile(func(config *GConfigContext) error {
+		resources := config.contextResources(contextName, kind)
+		if resources == nil {
+			return fmt.Errorf("context %s not found in config", contextName)
+		}
+		return change(resources)
+	})
 }
 
 func (c *GConfigContext) ReadConfigFile(gcf GConfigFile) error {
@@ -137,44 +265,158 @@ func (c *GConfigContext) SetCurrentContext(name string, isTemp bool) error {
 		return fmt.Errorf("provided context not found")
 	}
 
-	// Context can be set at runtime through a flag
-	if !isTemp {
-		err := c.writeChangesToDisk()
-		return err
+	// Context can be set at runtime through a flag, the file keeps its own
+	if isTemp {
+		return nil
 	}
-	return nil
+	return c.updateConfigFile(func(config *GConfigContext) error {
+		config.CurrentContext = name
+		return nil
+	})
 }
 
 func (c *GConfigContext) SetNewResource(uid, jsonPath string) error {
-	updateResourceFlag := false
-	for i, context := range c.Contexts {
-		if context.Name == c.CurrentContext {
-			for j, resource := range context.Context.Dashboards.GrafanResources.Resources {
-				// Skip process if UID is already recorded
-				if resource.Uid == uid {
-					return nil
-				}
-				// Replace path with new uid
-				if resource.Path == jsonPath {
-					c.Contexts[i].Context.Dashboards.GrafanResources.Resources[j].Uid = uid
-					updateResourceFlag = true
-					break
+	return c.SetNewKindResource(DashboardResource, uid, jsonPath)
+}
+
+// Records the watcher uid of a local resource file in the current context
+func (c *GConfigContext) SetNewKindResource(kind ResourceKind, uid, filePath string) error {
+	return c.updateResources(kind, func(resources *[]GContextGrafanaResource) error {
+		for i, resource := range *resources {
+			// Skip process if UID is already recorded
+			if resource.Uid == uid {
+				return errConfigUnchanged
+			}
+			// Replace path with new uid, the last sync belonged to the old one
+			if resource.Path == filePath {
+				(*resources)[i].Uid = uid
+				(*resources)[i].Hash = ""
+				(*resources)[i].Version = 0
+				return nil
+			}
+		}
+		*resources = append(*resources, GContextGrafanaResource{
+			Uid:  uid,
+			Path: filePath,
+		})
+		return nil
+	})
+}
+
+// Records the current gsync process as the owner of the resource
+func (c *GConfigContext) ClaimResource(jsonPath string) error {
+	return c.ClaimKindResource(DashboardResource, jsonPath)
+}
+
+// Records the current gsync process as the owner of the resource of the given kind
+func (c *GConfigContext) ClaimKindResource(kind ResourceKind, filePath string) error {
+	host, _ := os.Hostname()
+	return c.updateResource(kind, filePath, func(resource *GContextGrafanaResource) {
+		resource.Pid = os.Getpid()
+		resource.Host = host
+	})
+}
+
+// Records the backup of the Grafana state replaced by the resource
+func (c *GConfigContext) SetKindResourceBackup(kind ResourceKind, filePath, backup string) error {
+	return c.updateResource(kind, filePath, func(resource *GContextGrafanaResource) {
+		resource.Backup = backup
+	})
+}
+
+// Records the last sync of a watcher, see GContextGrafanaResource.Hash
+func (c *GConfigContext) SetResourceSynced(filePath, hash string, version int) error {
+	return c.SetKindResourceSynced(DashboardResource, filePath, hash, version)
+}
+
+// Records the last sync of the resource of the given kind
+func (c *GConfigContext) SetKindResourceSynced(kind ResourceKind, filePath, hash string, version int) error {
+	return c.updateResource(kind, filePath, func(resource *GContextGrafanaResource) {
+		resource.Hash = hash
+		resource.Version = version
+	})
+}
+
+// Changes the resource of the given kind recorded for the local file
+func (c *GConfigContext) updateResource(kind ResourceKind, filePath string, change func(resource *GContextGrafanaResource)) error {
+	return c.updateResources(kind, func(resources *[]GContextGrafanaResource) error {
+		for i, resource := range *resources {
+			if resource.Path == filePath {
+				change(&(*resources)[i])
+				return nil
+			}
+		}
+		return fmt.Errorf("resource not found for path %s", filePath)
+	})
+}
+
+// Records the dashboard and Grafana version the local file was last synced with
+func (c *GConfigContext) SetSyncedDashboard(uid, filePath string, version int) error {
+	synced := GContextGrafanaResource{Uid: uid, Path: filePath, Version: version}
+	return c.updateResources(SyncedDashboardResource, func(resources *[]GContextGrafanaResource) error {
+		for i, resource := range *resources {
+			if resource.Path == filePath {
+				if resource == synced {
+					return errConfigUnchanged
 				}
+				(*resources)[i] = synced
+				return nil
 			}
-			if !updateResourceFlag {
-				c.Contexts[i].Context.Dashboards.GrafanResources.Resources = append(
-					context.Context.Dashboards.GrafanResources.Resources, GContextGrafanaResource{
-						Uid:  uid,
-						Path: jsonPath,
-					},
-				)
+		}
+		*resources = append(*resources, synced)
+		return nil
+	})
+}
+
+// Finds the resource of the given kind recorded for the local file
+func (c *GConfigContext) GetKindResource(kind ResourceKind, filePath string) (GContextGrafanaResource, bool) {
+	resources := c.currentResources(kind)
+	if resources != nil {
+		for _, resource := range *resources {
+			if resource.Path == filePath {
+				return resource, true
 			}
-			break
 		}
 	}
-	// Write changes to disk
-	err := c.writeChangesToDisk()
-	return err
+	return GContextGrafanaResource{}, false
+}
+
+// Finds the resource list of the given kind in the current context
+func (c *GConfigContext) currentResources(kind ResourceKind) *[]GContextGrafanaResource {
+	return c.contextResources(c.CurrentContext, kind)
+}
+
+// Finds the resource list of the given kind in the named context
+func (c *GConfigContext) contextResources(name string, kind ResourceKind) *[]GContextGrafanaResource {
+	for i, context := range c.Contexts {
+		if context.Name == name {
+			resources := &c.Contexts[i].Context.Dashboards.GrafanResources
+			switch kind {
+			case SyncedDashboardResource:
+				return &c.Contexts[i].Context.Dashboards.Synced
+			case AlertRuleResource:
+				return &resources.AlertRules
+			case ContactPointResource:
+				return &resources.ContactPoints
+			case PolicyResource:
+				return &resources.Policies
+			}
+			return &resources.Resources
+		}
+	}
+	return nil
+}
+
+// Reports whether the process recorded as owner is still running
+// Owners on other hosts cannot be checked and are reported as alive
+func (r *GContextGrafanaResource) IsOwnerAlive() bool {
+	if r.Pid == 0 {
+		return false
+	}
+	if host, _ := os.Hostname(); r.Host != "" && r.Host != host {
+		return true
+	}
+	return isProcessAlive(r.Pid)
 }
 
 // Appends a new context to the user config file
@@ -231,18 +473,7 @@ func (c *GConfigContext) CreateNewContext(
 		return err
 	}
 
-	file, err := os.OpenFile(absConfigFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	encoder := yaml.NewEncoder(file)
-	if err := encoder.Encode(c); err != nil {
-		return err
-	}
-
-	return nil
+	return writeConfigFile(absConfigFilePath, c)
 }
 
 func (c *GConfigContext) SearchContext(name string) (GContext, error) {
@@ -281,68 +512,81 @@ func (c *GConfigContext) GetContext(contextName string) (GContext, error) {
 	return GContext{}, fmt.Errorf("current context not found in config")
 }
 
-func (c *GConfigContext) GetResourceByPath(filePath string) string {
-	for _, context := range c.Contexts {
-		if context.Name == c.CurrentContext {
-			for _, resource := range context.Context.Dashboards.GrafanResources.Resources {
-				if resource.Path == filePath {
-					return resource.Uid
-				}
+// Resolves dashboard file names and glob patterns relative to the dashboards path
+func (c *GContext) ResolveDashboardFiles(patterns []string) ([]string, error) {
+	var dashboardFilePaths []string
+	seen := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(c.Context.Dashboards.Path, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid dashboard pattern %s: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no dashboard files found for %s", pattern)
+		}
+
+		for _, match := range matches {
+			if seen[match] {
+				continue
 			}
+			if _, err := os.ReadFile(match); err != nil {
+				return nil, err
+			}
+			seen[match] = true
+			dashboardFilePaths = append(dashboardFilePaths, match)
+		}
+	}
+	return dashboardFilePaths, nil
+}
+
+func (c *GConfigContext) GetResourceByPath(filePath string) string {
+	return c.GetKindResourceByPath(DashboardResource, filePath)
+}
+
+// Finds the watcher uid recorded for a local resource file
+func (c *GConfigContext) GetKindResourceByPath(kind ResourceKind, filePath string) string {
+	resources := c.currentResources(kind)
+	if resources == nil {
+		return ""
+	}
+	for _, resource := range *resources {
+		if resource.Path == filePath {
+			return resource.Uid
 		}
 	}
 	return ""
 }
 
 func (c *GConfigContext) GetWatchedDashboards() []GContextGrafanaResource {
+	return c.GetWatchedKindResources(DashboardResource)
+}
+
+// Lists the resources of the given kind watched in the current context
+func (c *GConfigContext) GetWatchedKindResources(kind ResourceKind) []GContextGrafanaResource {
 	var watchedPaths []GContextGrafanaResource
-	for _, context := range c.Contexts {
-		if context.Name == c.CurrentContext {
-			for _, resource := range context.Context.Dashboards.GrafanResources.Resources {
-				watchedPaths = append(watchedPaths, GContextGrafanaResource{
-					Path: resource.Path,
-					Uid:  resource.Uid,
-				})
-			}
-		}
+	if resources := c.currentResources(kind); resources != nil {
+		watchedPaths = append(watchedPaths, *resources...)
 	}
 	return watchedPaths
 }
 
 func (c *GConfigContext) ClearResourceDashboardByPath(filePath string) error {
-	var resourceLength int
-
-	for _, context := range c.Contexts {
-		if context.Name == c.CurrentContext {
-			resourceLength = len(context.Context.Dashboards.GrafanResources.Resources)
-			break
-		}
-	}
-
-	newResources := make([]GContextGrafanaResource, resourceLength-1)
+	return c.ClearKindResourceByPath(DashboardResource, filePath)
+}
 
-	for _, context := range c.Contexts {
-		if context.Name == c.CurrentContext {
-			for i, resource := range context.Context.Dashboards.GrafanResources.Resources {
-				if resource.Path == filePath {
-					copy(newResources, context.Context.Dashboards.GrafanResources.Resources[0:i])
-					if i < len(context.Context.Dashboards.GrafanResources.Resources)-1 {
-						copy(newResources[i:], context.Context.Dashboards.GrafanResources.Resources[i+1:])
-					}
-					break
-				}
+// Removes the resource of the given kind recorded for the local file
+func (c *GConfigContext) ClearKindResourceByPath(kind ResourceKind, filePath string) error {
+	return c.updateResources(kind, func(resources *[]GContextGrafanaResource) error {
+		for i, resource := range *resources {
+			if resource.Path == filePath {
+				newResources := make([]GContextGrafanaResource, 0, len(*resources)-1)
+				newResources = append(newResources, (*resources)[:i]...)
+				newResources = append(newResources, (*resources)[i+1:]...)
+				*resources = newResources
+				break
 			}
-			break
 		}
-	}
-
-	for i, context := range c.Contexts {
-		if context.Name == c.CurrentContext {
-			c.Contexts[i].Context.Dashboards.GrafanResources.Resources = newResources
-			break
-		}
-	}
-
-	err := c.writeChangesToDisk()
-	return err
+		return nil
+	})
 }
@@ -1,10 +1,14 @@
 package gcontext
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -61,3 +65,91 @@ func TestContextFiles(t *testing.T) {
 		})
 	})
 }
+
+// Writes a config with the given contexts under a temporary home directory
+func newHomeConfig(t *testing.T, currentContext string, names ...string) GConfigFile {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ConfigDirectory), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := GConfigContext{CurrentContext: currentContext}
+	for _, name := range names {
+		context := GContext{Name: name}
+		context.Context.Dashboards.Path = home
+		config.Contexts = append(config.Contexts, context)
+	}
+	content, err := yaml.Marshal(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ConfigDirectory, ConfigFileName), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return GConfigFile{Base: home, Directory: ConfigDirectory, Name: ConfigFileName}
+}
+
+func TestContextOverride(t *testing.T) {
+	gcf := newHomeConfig(t, "dev", "dev", "prod")
+	var config GConfigContext
+	if err := config.ReadConfigFile(gcf); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.SetCurrentContext("prod", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.SetSyncedDashboard("abc", "cpu.json", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved GConfigContext
+	if err := saved.ReadConfigFile(gcf); err != nil {
+		t.Fatal(err)
+	}
+	if saved.CurrentContext != "dev" {
+		t.Fatalf("expected the override not persisted, got %s", saved.CurrentContext)
+	}
+	prod, _ := saved.GetContext("prod")
+	if synced := prod.Context.Dashboards.Synced; len(synced) != 1 || synced[0].Uid != "abc" {
+		t.Fatalf("expected the sync recorded in the overriding context, got %v", synced)
+	}
+}
+
+func TestConcurrentConfigWrites(t *testing.T) {
+	gcf := newHomeConfig(t, "dev", "dev")
+	paths := []string{"cpu.json", "memory.json", "disk.json", "network.json"}
+
+	// Each session reads the config once at startup, as separate processes would
+	var wg sync.WaitGroup
+	errs := make([]error, len(paths))
+	for i, path := range paths {
+		var config GConfigContext
+		if err := config.ReadConfigFile(gcf); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = config.SetNewResource(fmt.Sprintf("w-%d", i), path); errs[i] == nil {
+				errs[i] = config.SetResourceSynced(path, "hash", i+1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var saved GConfigContext
+	if err := saved.ReadConfigFile(gcf); err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range paths {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		resource, ok := saved.GetKindResource(DashboardResource, path)
+		if !ok || resource.Version != i+1 {
+			t.Fatalf("expected the sync of %s kept, got %+v", path, saved.GetWatchedDashboards())
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !unix
+
+package gcontext
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Locks left by a crashed process are taken over after this long
+const staleLockAge = 30 * time.Second
+
+// Creates the lock file exclusively, waiting while another process holds it
+// The lock is released by the returned function
+func lockConfigFile(lockPath string) (func(), error) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("config file locked by another gsync process, remove %s if none is running", lockPath)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package gcontext
+
+import (
+	"os"
+	"syscall"
+)
+
+// Takes an exclusive lock on the lock file, the lock is released by the
+// returned function or when the process exits
+func lockConfigFile(lockPath string) (func(), error) {
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
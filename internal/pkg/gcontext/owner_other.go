@@ -0,0 +1,16 @@
+//go:build !unix
+
+package gcontext
+
+import "os"
+
+// Finding a process fails on Windows once it exited, other platforms always
+// find it so the owner is reported as alive
+func isProcessAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package gcontext
+
+import "syscall"
+
+// Signal 0 only checks the process exists, EPERM means it runs as another user
+func isProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
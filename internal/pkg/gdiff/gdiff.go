@@ -0,0 +1,317 @@
+package gdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alex067/gsync/internal/pkg/gmerge"
+)
+
+type ChangeType string
+
+const (
+	Added   ChangeType = "added"
+	Removed ChangeType = "removed"
+	Changed ChangeType = "changed"
+	Moved   ChangeType = "moved"
+)
+
+type Change struct {
+	Type ChangeType `json:"type"`
+	// JSON pointer of the changed value, in the new dashboard for added and
+	// changed values and in the old dashboard for removed values
+	Path string `json:"path"`
+	// Human readable description, ex: panel 'CPU usage' query A expr changed
+	Summary string      `json:"summary"`
+	Old     interface{} `json:"old,omitempty"`
+	New     interface{} `json:"new,omitempty"`
+}
+
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Values such as false or null are kept, only remove operations drop the value
+func (p PatchOperation) MarshalJSON() ([]byte, error) {
+	if p.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{p.Op, p.Path})
+	}
+	type patchOperation PatchOperation
+	return json.Marshal(patchOperation(p))
+}
+
+// Describes where the walk is in the dashboard
+type location struct {
+	fieldPath string
+	pointer   string
+	// Dashboard element being described, ex: panel 'CPU usage'
+	subject string
+	// Attribute path within the subject, ex: fieldConfig.defaults.unit
+	attribute string
+}
+
+// Compares two dashboard models and lists the panel aware changes
+// Panels are matched by id or gridPos, variables and annotations by name,
+// links by title and panel queries by refId
+func Compare(old, new map[string]interface{}) []Change {
+	var changes []Change
+	diffValue(location{}, old, new, &changes)
+	return changes
+}
+
+func diffValue(loc location, old, new interface{}, changes *[]Change) {
+	if reflect.DeepEqual(old, new) {
+		return
+	}
+
+	// Grid position changes are reported as a single move
+	if strings.HasSuffix(loc.fieldPath, "panels.gridPos") {
+		*changes = append(*changes, Change{
+			Type:    Moved,
+			Path:    loc.pointer,
+			Summary: fmt.Sprintf("%s moved", subjectOrDashboard(loc.subject)),
+			Old:     old,
+			New:     new,
+		})
+		return
+	}
+
+	oldMap, isOldMap := old.(map[string]interface{})
+	newMap, isNewMap := new.(map[string]interface{})
+	if isOldMap && isNewMap {
+		for _, key := range unionKeys(oldMap, newMap) {
+			child := location{
+				fieldPath: joinField(loc.fieldPath, key),
+				pointer:   loc.pointer + "/" + escapePointer(key),
+				subject:   loc.subject,
+				attribute: joinField(loc.attribute, key),
+			}
+			oldValue, inOld := oldMap[key]
+			newValue, inNew := newMap[key]
+			switch {
+			case !inOld:
+				*changes = append(*changes, newChange(Added, child, nil, newValue))
+			case !inNew:
+				*changes = append(*changes, newChange(Removed, child, oldValue, nil))
+			default:
+				diffValue(child, oldValue, newValue, changes)
+			}
+		}
+		return
+	}
+
+	oldList, isOldList := old.([]interface{})
+	newList, isNewList := new.([]interface{})
+	if isOldList && isNewList && diffKeyedList(loc, oldList, newList, changes) {
+		return
+	}
+
+	*changes = append(*changes, newChange(Changed, loc, old, new))
+}
+
+// Compares lists whose items can be matched by a key
+// Returns false for lists that are compared as a single value
+func diffKeyedList(loc location, old, new []interface{}, changes *[]Change) bool {
+	keyFunc := gmerge.ListKeyFunc(loc.fieldPath)
+	if keyFunc == nil {
+		return false
+	}
+	oldItems, ok := gmerge.IndexList(old, keyFunc)
+	if !ok {
+		return false
+	}
+	newItems, ok := gmerge.IndexList(new, keyFunc)
+	if !ok {
+		return false
+	}
+
+	// Kept items must stay in the same order for the pointers of nested
+	// changes to hold once removals and additions are applied
+	var oldOrder, newOrder []string
+	for _, item := range old {
+		if _, ok := newItems[keyFunc(item)]; ok {
+			oldOrder = append(oldOrder, keyFunc(item))
+		}
+	}
+	for _, item := range new {
+		if _, ok := oldItems[keyFunc(item)]; ok {
+			newOrder = append(newOrder, keyFunc(item))
+		}
+	}
+	if !reflect.DeepEqual(oldOrder, newOrder) {
+		return false
+	}
+
+	// Removals run from the end so earlier indexes stay valid
+	for i := len(old) - 1; i >= 0; i-- {
+		if _, ok := newItems[keyFunc(old[i])]; !ok {
+			*changes = append(*changes, newChange(Removed, itemLocation(loc, i, old[i]), old[i], nil))
+		}
+	}
+	for i, item := range new {
+		if _, ok := oldItems[keyFunc(item)]; !ok {
+			*changes = append(*changes, newChange(Added, itemLocation(loc, i, item), nil, item))
+		}
+	}
+	for i, item := range new {
+		if oldItem, ok := oldItems[keyFunc(item)]; ok {
+			diffValue(itemLocation(loc, i, item), oldItem, item, changes)
+		}
+	}
+	return true
+}
+
+// Names a list item after the dashboard element it represents
+func itemLocation(loc location, index int, item interface{}) location {
+	itemLoc := location{
+		fieldPath: loc.fieldPath,
+		pointer:   loc.pointer + "/" + strconv.Itoa(index),
+	}
+
+	object, _ := item.(map[string]interface{})
+	name := func(attributes ...string) string {
+		for _, attribute := range attributes {
+			if value, ok := object[attribute].(string); ok && value != "" {
+				return value
+			}
+		}
+		if id, ok := object["id"]; ok {
+			return fmt.Sprintf("%v", id)
+		}
+		return strconv.Itoa(index)
+	}
+
+	switch {
+	case loc.fieldPath == "panels" || strings.HasSuffix(loc.fieldPath, ".panels"):
+		// Panels nested in rows are named on their own
+		itemLoc.subject = fmt.Sprintf("panel '%s'", name("title"))
+	case loc.fieldPath == "templating.list":
+		itemLoc.subject = fmt.Sprintf("variable `%s`", name("name"))
+	case loc.fieldPath == "annotations.list":
+		itemLoc.subject = fmt.Sprintf("annotation '%s'", name("name"))
+	case loc.fieldPath == "links":
+		itemLoc.subject = fmt.Sprintf("link '%s'", name("title", "url"))
+	case strings.HasSuffix(loc.fieldPath, "panels.targets"):
+		itemLoc.subject = strings.TrimSpace(fmt.Sprintf("%s query %s", loc.subject, name("refId")))
+	case loc.fieldPath == "integrations":
+		itemLoc.subject = fmt.Sprintf("integration '%s'", name("type", "uid"))
+	default:
+		itemLoc.subject = loc.subject
+		itemLoc.attribute = fmt.Sprintf("%s[%d]", loc.attribute, index)
+	}
+	return itemLoc
+}
+
+func newChange(changeType ChangeType, loc location, old, new interface{}) Change {
+	var summary string
+	if loc.attribute == "" {
+		summary = fmt.Sprintf("%s %s", subjectOrDashboard(loc.subject), changeType)
+	} else {
+		summary = fmt.Sprintf("%s %s %s", subjectOrDashboard(loc.subject), loc.attribute, changeType)
+	}
+	return Change{
+		Type:    changeType,
+		Path:    loc.pointer,
+		Summary: summary,
+		Old:     old,
+		New:     new,
+	}
+}
+
+func subjectOrDashboard(subject string) string {
+	if subject == "" {
+		return "dashboard"
+	}
+	return subject
+}
+
+// Renders changes as one line each, with the values of scalar changes
+func FormatText(changes []Change) string {
+	var output strings.Builder
+	for _, change := range changes {
+		symbol := "~"
+		switch change.Type {
+		case Added:
+			symbol = "+"
+		case Removed:
+			symbol = "-"
+		}
+		fmt.Fprintf(&output, "%s %s", symbol, change.Summary)
+		if change.Type == Changed && isScalar(change.Old) && isScalar(change.New) {
+			fmt.Fprintf(&output, ": %s -> %s", formatValue(change.Old), formatValue(change.New))
+		}
+		output.WriteString("\n")
+	}
+	return output.String()
+}
+
+// Converts changes into RFC 6902 JSON patch operations turning old into new
+func JSONPatch(changes []Change) []PatchOperation {
+	operations := make([]PatchOperation, 0, len(changes))
+	for _, change := range changes {
+		switch change.Type {
+		case Added:
+			operations = append(operations, PatchOperation{Op: "add", Path: change.Path, Value: change.New})
+		case Removed:
+			operations = append(operations, PatchOperation{Op: "remove", Path: change.Path})
+		default:
+			operations = append(operations, PatchOperation{Op: "replace", Path: change.Path, Value: change.New})
+		}
+	}
+	return operations
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+func formatValue(value interface{}) string {
+	valueJson, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	const maxLength = 80
+	if len(valueJson) > maxLength {
+		return string(valueJson[:maxLength]) + "..."
+	}
+	return string(valueJson)
+}
+
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+func joinField(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func unionKeys(objects ...map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, object := range objects {
+		for key := range object {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
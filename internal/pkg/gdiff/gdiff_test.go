@@ -0,0 +1,63 @@
+package gdiff
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func parseDashboard(t *testing.T, content string) map[string]interface{} {
+	t.Helper()
+
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &dashboard); err != nil {
+		t.Fatalf("error parsing dashboard: %v", err)
+	}
+	return dashboard
+}
+
+func TestCompare(t *testing.T) {
+	old := parseDashboard(t, `{
+		"title": "Pods",
+		"panels": [
+			{"id": 1, "title": "CPU usage", "gridPos": {"x": 0, "y": 0}, "targets": [{"refId": "A", "expr": "cpu"}]},
+			{"id": 2, "title": "Memory", "gridPos": {"x": 12, "y": 0}}
+		],
+		"templating": {"list": [{"name": "cluster"}]}
+	}`)
+	new := parseDashboard(t, `{
+		"title": "Pods",
+		"panels": [
+			{"id": 1, "title": "CPU usage", "gridPos": {"x": 0, "y": 8}, "targets": [{"refId": "A", "expr": "cpu_total"}]},
+			{"id": 3, "title": "Network", "gridPos": {"x": 12, "y": 0}}
+		],
+		"templating": {"list": [{"name": "cluster"}, {"name": "env"}]}
+	}`)
+
+	changes := Compare(old, new)
+
+	want := []string{
+		"panel 'Memory' removed",
+		"panel 'Network' added",
+		"panel 'CPU usage' moved",
+		"panel 'CPU usage' query A expr changed",
+		"variable `env` added",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("expected %d changes, got:\n%s", len(want), FormatText(changes))
+	}
+	for i, change := range changes {
+		if change.Summary != want[i] {
+			t.Errorf("got %s, want %s", change.Summary, want[i])
+		}
+	}
+
+	t.Run("test json patch", func(t *testing.T) {
+		operations := JSONPatch(changes)
+		if operations[0].Op != "remove" || operations[0].Path != "/panels/1" {
+			t.Errorf("got %s %s, want remove /panels/1", operations[0].Op, operations[0].Path)
+		}
+		if operations[3].Op != "replace" || operations[3].Path != "/panels/0/targets/0/expr" {
+			t.Errorf("got %s %s, want replace /panels/0/targets/0/expr", operations[3].Op, operations[3].Path)
+		}
+	})
+}
@@ -0,0 +1,318 @@
+package gmerge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Marks a value missing from one side of the merge
+type absent struct{}
+
+var absentValue = absent{}
+
+type Conflict struct {
+	Path   string
+	Base   interface{}
+	Ours   interface{}
+	Theirs interface{}
+	// Placeholder written into the merge tree where the conflict occurred
+	placeholder string
+}
+
+type Result struct {
+	// Merged dashboard with conflicting values taken from ours
+	Merged    map[string]interface{}
+	Conflicts []Conflict
+	// Merge tree holding conflict placeholders
+	tree map[string]interface{}
+}
+
+type merger struct {
+	conflicts []Conflict
+}
+
+var placeholderPattern = regexp.MustCompile(`"__gsync_conflict_(\d+)__"`)
+
+// Three-way merge of dashboard models
+// Panels are matched by id or gridPos, templating variables and annotations by
+// name, links by title and panel queries by refId. Edits made on a single side
+// are merged automatically, everything else is reported as a conflict
+func Merge(base, ours, theirs map[string]interface{}) *Result {
+	m := &merger{}
+	tree := m.mergeValue("", "", base, ours, theirs)
+
+	result := &Result{Conflicts: m.conflicts}
+	if treeMap, ok := tree.(map[string]interface{}); ok {
+		result.tree = treeMap
+	} else {
+		result.tree = make(map[string]interface{})
+	}
+
+	resolved := result.resolve(result.tree)
+	result.Merged, _ = resolved.(map[string]interface{})
+	return result
+}
+
+// Reports whether a side has the value
+func isPresent(value interface{}) bool {
+	_, ok := value.(absent)
+	return !ok
+}
+
+func lookup(object map[string]interface{}, key string) interface{} {
+	if object == nil {
+		return absentValue
+	}
+	if value, ok := object[key]; ok {
+		return value
+	}
+	return absentValue
+}
+
+// fieldPath holds the object keys leading to a value without list positions,
+// path holds the human readable location used in conflict reports
+func (m *merger) mergeValue(fieldPath, path string, base, ours, theirs interface{}) interface{} {
+	switch {
+	case reflect.DeepEqual(ours, theirs):
+		return ours
+	case reflect.DeepEqual(base, ours):
+		return theirs
+	case reflect.DeepEqual(base, theirs):
+		return ours
+	}
+
+	baseMap, isBaseMap := base.(map[string]interface{})
+	oursMap, isOursMap := ours.(map[string]interface{})
+	theirsMap, isTheirsMap := theirs.(map[string]interface{})
+	if isOursMap && isTheirsMap && (isBaseMap || !isPresent(base)) {
+		return m.mergeObject(fieldPath, path, baseMap, oursMap, theirsMap)
+	}
+
+	baseList, isBaseList := base.([]interface{})
+	oursList, isOursList := ours.([]interface{})
+	theirsList, isTheirsList := theirs.([]interface{})
+	if isOursList && isTheirsList && (isBaseList || !isPresent(base)) {
+		if merged, ok := m.mergeKeyedList(fieldPath, path, baseList, oursList, theirsList); ok {
+			return merged
+		}
+	}
+
+	return m.addConflict(path, base, ours, theirs)
+}
+
+func (m *merger) mergeObject(fieldPath, path string, base, ours, theirs map[string]interface{}) interface{} {
+	merged := make(map[string]interface{})
+	for _, key := range unionKeys(base, ours, theirs) {
+		value := m.mergeValue(
+			joinField(fieldPath, key),
+			joinField(path, key),
+			lookup(base, key),
+			lookup(ours, key),
+			lookup(theirs, key),
+		)
+		if isPresent(value) {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// Merges lists whose items can be matched by a key
+// Lists without a known key or with unkeyed items are not merged
+func (m *merger) mergeKeyedList(fieldPath, path string, base, ours, theirs []interface{}) ([]interface{}, bool) {
+	keyFunc := ListKeyFunc(fieldPath)
+	if keyFunc == nil {
+		return nil, false
+	}
+
+	baseItems, ok := IndexList(base, keyFunc)
+	if !ok {
+		return nil, false
+	}
+	oursItems, ok := IndexList(ours, keyFunc)
+	if !ok {
+		return nil, false
+	}
+	theirsItems, ok := IndexList(theirs, keyFunc)
+	if !ok {
+		return nil, false
+	}
+
+	// Keep the order of ours, items only found in theirs are appended
+	var keys []string
+	seen := make(map[string]bool)
+	for _, list := range [][]interface{}{ours, theirs, base} {
+		for _, item := range list {
+			key := keyFunc(item)
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	merged := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		value := m.mergeValue(
+			fieldPath,
+			fmt.Sprintf("%s[%s]", path, key),
+			lookup(baseItems, key),
+			lookup(oursItems, key),
+			lookup(theirsItems, key),
+		)
+		if isPresent(value) {
+			merged = append(merged, value)
+		}
+	}
+	return merged, true
+}
+
+func (m *merger) addConflict(path string, base, ours, theirs interface{}) string {
+	placeholder := fmt.Sprintf("__gsync_conflict_%d__", len(m.conflicts))
+	m.conflicts = append(m.conflicts, Conflict{
+		Path:        path,
+		Base:        base,
+		Ours:        ours,
+		Theirs:      theirs,
+		placeholder: placeholder,
+	})
+	return placeholder
+}
+
+// Replaces conflict placeholders with ours, dropping values ours removed
+func (r *Result) resolve(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			if item = r.resolve(item); isPresent(item) {
+				resolved[key] = item
+			}
+		}
+		return resolved
+	case []interface{}:
+		resolved := make([]interface{}, 0, len(typed))
+		for _, item := range typed {
+			if item = r.resolve(item); isPresent(item) {
+				resolved = append(resolved, item)
+			}
+		}
+		return resolved
+	case string:
+		for _, conflict := range r.Conflicts {
+			if conflict.placeholder == typed {
+				return conflict.Ours
+			}
+		}
+	}
+	return value
+}
+
+// Renders the merged dashboard with Git style conflict regions around every
+// conflicting value. The output is not valid JSON while conflicts remain
+func (r *Result) MarshalWithConflicts(oursLabel, theirsLabel string) ([]byte, error) {
+	if len(r.Conflicts) == 0 {
+		return json.MarshalIndent(r.Merged, "", "\t")
+	}
+
+	content, err := json.MarshalIndent(r.tree, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	for _, line := range strings.Split(string(content), "\n") {
+		match := placeholderPattern.FindStringSubmatchIndex(line)
+		if match == nil {
+			output.WriteString(line + "\n")
+			continue
+		}
+
+		var index int
+		fmt.Sscanf(line[match[2]:match[3]], "%d", &index)
+		conflict := r.Conflicts[index]
+
+		indent := line[:len(line)-len(strings.TrimLeft(line, "\t"))]
+		prefix, suffix := line[:match[0]], line[match[1]:]
+
+		output.WriteString("<<<<<<< " + oursLabel + "\n")
+		if err := writeConflictSide(&output, prefix, suffix, indent, conflict.Ours); err != nil {
+			return nil, err
+		}
+		output.WriteString("=======\n")
+		if err := writeConflictSide(&output, prefix, suffix, indent, conflict.Theirs); err != nil {
+			return nil, err
+		}
+		output.WriteString(">>>>>>> " + theirsLabel + "\n")
+	}
+	return bytes.TrimRight(output.Bytes(), "\n"), nil
+}
+
+func writeConflictSide(output *bytes.Buffer, prefix, suffix, indent string, value interface{}) error {
+	// Removed values leave an empty side
+	if !isPresent(value) {
+		return nil
+	}
+	valueJson, err := json.MarshalIndent(value, indent, "\t")
+	if err != nil {
+		return err
+	}
+	output.WriteString(prefix + string(valueJson) + suffix + "\n")
+	return nil
+}
+
+// Lists every conflict with the value of each side
+func (r *Result) Report() string {
+	var report strings.Builder
+	for _, conflict := range r.Conflicts {
+		fmt.Fprintf(
+			&report,
+			"CONFLICT %s\n  base:   %s\n  ours:   %s\n  theirs: %s\n",
+			conflict.Path,
+			formatValue(conflict.Base),
+			formatValue(conflict.Ours),
+			formatValue(conflict.Theirs),
+		)
+	}
+	return report.String()
+}
+
+func formatValue(value interface{}) string {
+	if !isPresent(value) {
+		return "<removed>"
+	}
+	valueJson, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	const maxLength = 120
+	if len(valueJson) > maxLength {
+		return string(valueJson[:maxLength]) + "..."
+	}
+	return string(valueJson)
+}
+
+func joinField(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func unionKeys(objects ...map[string]interface{}) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, object := range objects {
+		for _, key := range sortedKeys(object) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
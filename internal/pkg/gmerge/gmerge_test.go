@@ -0,0 +1,125 @@
+package gmerge
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func parseDashboard(t *testing.T, content string) map[string]interface{} {
+	t.Helper()
+
+	var dashboard map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &dashboard); err != nil {
+		t.Fatalf("error parsing dashboard: %v", err)
+	}
+	return dashboard
+}
+
+const baseDashboard = `{
+	"title": "Pods",
+	"panels": [
+		{"id": 1, "title": "CPU", "targets": [{"refId": "A", "expr": "cpu"}]},
+		{"id": 2, "title": "Memory", "targets": [{"refId": "A", "expr": "mem"}]}
+	],
+	"templating": {"list": [{"name": "env", "query": "prod"}]}
+}`
+
+func TestMerge(t *testing.T) {
+	t.Run("test merge non conflicting edits", func(t *testing.T) {
+		base := parseDashboard(t, baseDashboard)
+		ours := parseDashboard(t, `{
+			"title": "Pods",
+			"panels": [
+				{"id": 1, "title": "CPU usage", "targets": [{"refId": "A", "expr": "cpu"}]},
+				{"id": 2, "title": "Memory", "targets": [{"refId": "A", "expr": "mem"}]}
+			],
+			"templating": {"list": [{"name": "env", "query": "prod"}, {"name": "region", "query": "eu"}]}
+		}`)
+		theirs := parseDashboard(t, `{
+			"title": "Pods",
+			"panels": [
+				{"id": 1, "title": "CPU", "targets": [{"refId": "A", "expr": "cpu"}]},
+				{"id": 2, "title": "Memory", "targets": [{"refId": "A", "expr": "mem_bytes"}]},
+				{"id": 3, "title": "Network"}
+			],
+			"templating": {"list": [{"name": "env", "query": "staging"}]}
+		}`)
+
+		result := Merge(base, ours, theirs)
+		if len(result.Conflicts) != 0 {
+			t.Fatalf("expected no conflicts, got: %s", result.Report())
+		}
+
+		want := parseDashboard(t, `{
+			"title": "Pods",
+			"panels": [
+				{"id": 1, "title": "CPU usage", "targets": [{"refId": "A", "expr": "cpu"}]},
+				{"id": 2, "title": "Memory", "targets": [{"refId": "A", "expr": "mem_bytes"}]},
+				{"id": 3, "title": "Network"}
+			],
+			"templating": {"list": [{"name": "env", "query": "staging"}, {"name": "region", "query": "eu"}]}
+		}`)
+
+		got, _ := json.Marshal(result.Merged)
+		wantJson, _ := json.Marshal(want)
+		if string(got) != string(wantJson) {
+			t.Errorf("got %s, want %s", got, wantJson)
+		}
+	})
+
+	t.Run("test merge conflicting edits", func(t *testing.T) {
+		base := parseDashboard(t, baseDashboard)
+		ours := parseDashboard(t, strings.Replace(baseDashboard, `"expr": "cpu"`, `"expr": "cpu_ours"`, 1))
+		theirs := parseDashboard(t, strings.Replace(baseDashboard, `"expr": "cpu"`, `"expr": "cpu_theirs"`, 1))
+
+		result := Merge(base, ours, theirs)
+		if len(result.Conflicts) != 1 {
+			t.Fatalf("expected 1 conflict, got: %d", len(result.Conflicts))
+		}
+
+		if result.Conflicts[0].Path != "panels[id=1].targets[refId=A].expr" {
+			t.Errorf("got conflict path %s", result.Conflicts[0].Path)
+		}
+
+		// Conflicting values are taken from ours
+		if !strings.Contains(mustMarshal(t, result.Merged), "cpu_ours") {
+			t.Errorf("expected merged dashboard to keep ours")
+		}
+
+		content, err := result.MarshalWithConflicts("local", "grafana")
+		if err != nil {
+			t.Fatalf("should render conflicts: %v", err)
+		}
+		for _, marker := range []string{"<<<<<<< local", `"expr": "cpu_ours"`, "=======", `"expr": "cpu_theirs"`, ">>>>>>> grafana"} {
+			if !strings.Contains(string(content), marker) {
+				t.Errorf("expected conflict output to contain %s, got:\n%s", marker, content)
+			}
+		}
+	})
+
+	t.Run("test merge removed and edited panel", func(t *testing.T) {
+		base := parseDashboard(t, baseDashboard)
+		ours := parseDashboard(t, `{
+			"title": "Pods",
+			"panels": [{"id": 1, "title": "CPU", "targets": [{"refId": "A", "expr": "cpu"}]}],
+			"templating": {"list": [{"name": "env", "query": "prod"}]}
+		}`)
+		theirs := parseDashboard(t, strings.Replace(baseDashboard, `"Memory"`, `"Memory usage"`, 1))
+
+		result := Merge(base, ours, theirs)
+		if len(result.Conflicts) != 1 || result.Conflicts[0].Path != "panels[id=2]" {
+			t.Fatalf("expected conflict on removed panel, got: %s", result.Report())
+		}
+	})
+}
+
+func mustMarshal(t *testing.T, value interface{}) string {
+	t.Helper()
+
+	content, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("error marshalling value: %v", err)
+	}
+	return string(content)
+}
@@ -0,0 +1,84 @@
+package gmerge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type KeyFunc func(item interface{}) string
+
+// Picks how items of a dashboard list are matched between versions
+// fieldPath is the dotted path of object keys leading to the list
+// Returns nil for lists that are merged as a single value
+func ListKeyFunc(fieldPath string) KeyFunc {
+	switch {
+	case fieldPath == "panels" || strings.HasSuffix(fieldPath, ".panels"):
+		return panelKey
+	case fieldPath == "templating.list" || fieldPath == "annotations.list":
+		return attributeKey("name")
+	case fieldPath == "links":
+		return attributeKey("title")
+	case strings.HasSuffix(fieldPath, "panels.targets"):
+		return attributeKey("refId")
+	case fieldPath == "integrations":
+		// Contact point integrations
+		return attributeKey("uid")
+	}
+	return nil
+}
+
+// Panels are matched by id, panels without an id by their grid position
+func panelKey(item interface{}) string {
+	panel, ok := item.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if id, ok := panel["id"]; ok && id != nil {
+		return fmt.Sprintf("id=%v", id)
+	}
+	if gridPos, ok := panel["gridPos"].(map[string]interface{}); ok {
+		return fmt.Sprintf("gridPos=%v,%v", gridPos["x"], gridPos["y"])
+	}
+	return ""
+}
+
+func attributeKey(attribute string) KeyFunc {
+	return func(item interface{}) string {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value, ok := object[attribute].(string)
+		if !ok || value == "" {
+			return ""
+		}
+		return fmt.Sprintf("%s=%s", attribute, value)
+	}
+}
+
+// Indexes list items by key
+// Fails when an item has no key or two items share a key
+func IndexList(list []interface{}, key KeyFunc) (map[string]interface{}, bool) {
+	items := make(map[string]interface{}, len(list))
+	for _, item := range list {
+		itemKey := key(item)
+		if itemKey == "" {
+			return nil, false
+		}
+		if _, ok := items[itemKey]; ok {
+			return nil, false
+		}
+		items[itemKey] = item
+	}
+	return items, true
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
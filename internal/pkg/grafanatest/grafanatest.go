@@ -0,0 +1,511 @@
+// Package grafanatest runs an in-process fake of the Grafana HTTP API
+// Only the endpoints gsync relies on are served, so gclient and the commands
+// can be tested without Docker or network access
+package grafanatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Token accepted by servers created without an explicit token
+const DefaultToken = "gsync-test-token"
+
+// Fake Grafana instance backed by in-memory dashboards, folders and alerting resources
+type Server struct {
+	*httptest.Server
+	Token string
+
+	mutex      sync.Mutex
+	dashboards map[string]*dashboard
+	folders    map[string]Folder
+	faults     []*Fault
+	nextId     int
+	// Notification policy tree and contact point integrations by uid
+	policy        map[string]interface{}
+	contactPoints map[string]map[string]interface{}
+	// Requests served, ex: "GET /api/dashboards/uid/abc"
+	requests []string
+}
+
+type dashboard struct {
+	id        int
+	folderUid string
+	model     map[string]interface{}
+}
+
+type Folder struct {
+	Uid       string `json:"uid"`
+	Title     string `json:"title"`
+	ParentUid string `json:"parentUid,omitempty"`
+}
+
+// Makes matching requests fail with the given status
+// Times limits how many requests fail, zero fails every request
+type Fault struct {
+	Method string
+	// Matches request paths with this prefix, ex: /api/dashboards/uid/
+	PathPrefix string
+	Status     int
+	Times      int
+	hits       int
+}
+
+// Starts a fake Grafana accepting DefaultToken, closed when the test ends
+func NewServer(t interface{ Cleanup(func()) }) *Server {
+	s := &Server{
+		Token:      DefaultToken,
+		dashboards: make(map[string]*dashboard),
+		folders:    make(map[string]Folder),
+		// Same as a fresh Grafana instance
+		policy:        map[string]interface{}{"receiver": "grafana-default-email"},
+		contactPoints: make(map[string]map[string]interface{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/dashboards/db", s.saveDashboard)
+	mux.HandleFunc("GET /api/dashboards/uid/{uid}", s.getDashboard)
+	mux.HandleFunc("DELETE /api/dashboards/uid/{uid}", s.deleteDashboard)
+	mux.HandleFunc("GET /api/search", s.search)
+	mux.HandleFunc("GET /api/folders", s.listFolders)
+	mux.HandleFunc("POST /api/folders", s.createFolder)
+	mux.HandleFunc("GET /api/folders/{uid}", s.getFolder)
+	mux.HandleFunc("GET /api/v1/provisioning/policies", s.getPolicy)
+	mux.HandleFunc("PUT /api/v1/provisioning/policies", s.putPolicy)
+	mux.HandleFunc("GET /api/v1/provisioning/contact-points", s.listContactPoints)
+	mux.HandleFunc("POST /api/v1/provisioning/contact-points", s.saveContactPoint)
+	mux.HandleFunc("PUT /api/v1/provisioning/contact-points/{uid}", s.saveContactPoint)
+	mux.HandleFunc("DELETE /api/v1/provisioning/contact-points/{uid}", s.deleteContactPoint)
+
+	s.Server = httptest.NewServer(s.handle(mux))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Checks the token and injected faults before serving the request
+func (s *Server) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests = append(s.requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+		fault := s.matchFault(r)
+		s.mutex.Unlock()
+
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer %s", s.Token) {
+			writeJson(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+			return
+		}
+		if fault != nil {
+			writeJson(w, fault.Status, map[string]string{"message": "injected fault"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) matchFault(r *http.Request) *Fault {
+	for _, fault := range s.faults {
+		if fault.Method != "" && fault.Method != r.Method {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, fault.PathPrefix) {
+			continue
+		}
+		if fault.Times > 0 && fault.hits >= fault.Times {
+			continue
+		}
+		fault.hits += 1
+		return fault
+	}
+	return nil
+}
+
+// Injects a fault into the matching requests
+func (s *Server) AddFault(fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// Removes every injected fault
+func (s *Server) ClearFaults() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = nil
+}
+
+// Requests served so far, ex: "POST /api/dashboards/db"
+func (s *Server) Requests() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// Adds a folder, as if created in the UI
+func (s *Server) AddFolder(folder Folder) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.folders[folder.Uid] = folder
+}
+
+// Creates or replaces a dashboard, as if saved in the UI, and bumps its version
+// Returns the new version
+func (s *Server) SaveDashboard(model map[string]interface{}, folderUid string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.storeDashboard(copyModel(model), folderUid)
+}
+
+// Returns a copy of the stored dashboard model, nil when missing
+func (s *Server) Dashboard(uid string) map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stored, ok := s.dashboards[uid]; ok {
+		return copyModel(stored.model)
+	}
+	return nil
+}
+
+// Uids of every stored dashboard, sorted
+func (s *Server) DashboardUids() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	uids := make([]string, 0, len(s.dashboards))
+	for uid := range s.dashboards {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	return uids
+}
+
+// Replaces the notification policy tree, as if edited in the UI
+func (s *Server) SetPolicy(tree map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.policy = copyModel(tree)
+}
+
+// Returns a copy of the notification policy tree
+func (s *Server) Policy() map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return copyModel(s.policy)
+}
+
+// Returns copies of the contact point integrations with the name, sorted by uid
+func (s *Server) ContactPoints(name string) []map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.matchContactPoints(name)
+}
+
+// Stores the model with the next version, callers hold the mutex
+func (s *Server) storeDashboard(model map[string]interface{}, folderUid string) int {
+	uid, _ := model["uid"].(string)
+	stored, ok := s.dashboards[uid]
+	if !ok {
+		s.nextId += 1
+		stored = &dashboard{id: s.nextId}
+		s.dashboards[uid] = stored
+		model["version"] = float64(1)
+	} else {
+		model["version"] = stored.model["version"].(float64) + 1
+	}
+	model["id"] = float64(stored.id)
+	stored.model = model
+	stored.folderUid = folderUid
+	return int(model["version"].(float64))
+}
+
+func (s *Server) saveDashboard(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Dashboard map[string]interface{} `json:"dashboard"`
+		FolderUid string                 `json:"folderUid"`
+		Overwrite bool                   `json:"overwrite"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Dashboard == nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"message": "bad request data"})
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	model := request.Dashboard
+	uid, _ := model["uid"].(string)
+	if uid == "" {
+		s.nextId += 1
+		uid = fmt.Sprintf("generated-%d", s.nextId)
+		model["uid"] = uid
+	}
+	if request.FolderUid != "" {
+		if _, ok := s.folders[request.FolderUid]; !ok {
+			writeJson(w, http.StatusBadRequest, map[string]string{"message": "folder not found"})
+			return
+		}
+	}
+
+	// Same as Grafana, outdated versions are rejected unless overwriting
+	if stored, ok := s.dashboards[uid]; ok && !request.Overwrite {
+		version, _ := model["version"].(float64)
+		if version != stored.model["version"] {
+			writeJson(w, http.StatusPreconditionFailed, map[string]string{
+				"status":  "version-mismatch",
+				"message": "The dashboard has been changed by someone else",
+			})
+			return
+		}
+	}
+
+	version := s.storeDashboard(model, request.FolderUid)
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"id":      s.dashboards[uid].id,
+		"uid":     uid,
+		"url":     fmt.Sprintf("/d/%s", uid),
+		"status":  "success",
+		"version": version,
+	})
+}
+
+func (s *Server) getDashboard(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.dashboards[r.PathValue("uid")]
+	if !ok {
+		writeJson(w, http.StatusNotFound, map[string]string{"message": "Dashboard not found"})
+		return
+	}
+
+	meta := map[string]interface{}{
+		"version":   stored.model["version"],
+		"folderUid": stored.folderUid,
+		"url":       fmt.Sprintf("/d/%s", r.PathValue("uid")),
+	}
+	if folder, ok := s.folders[stored.folderUid]; ok {
+		meta["folderTitle"] = folder.Title
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"meta":      meta,
+		"dashboard": stored.model,
+	})
+}
+
+func (s *Server) deleteDashboard(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	uid := r.PathValue("uid")
+	if _, ok := s.dashboards[uid]; !ok {
+		writeJson(w, http.StatusNotFound, map[string]string{"message": "Dashboard not found"})
+		return
+	}
+	delete(s.dashboards, uid)
+	writeJson(w, http.StatusOK, map[string]string{"uid": uid, "message": "Dashboard deleted"})
+}
+
+// Supports the query, tag, folderUIDs and dashboardUIDs filters
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	params := r.URL.Query()
+	results := []map[string]interface{}{}
+	for _, uid := range sortedKeys(s.dashboards) {
+		stored := s.dashboards[uid]
+		title, _ := stored.model["title"].(string)
+		tags := modelTags(stored.model)
+
+		if query := params.Get("query"); query != "" && !strings.Contains(strings.ToLower(title), strings.ToLower(query)) {
+			continue
+		}
+		if folderUids := params["folderUIDs"]; len(folderUids) > 0 && !slices.Contains(folderUids, stored.folderUid) {
+			continue
+		}
+		if uids := params["dashboardUIDs"]; len(uids) > 0 && !slices.Contains(uids, uid) {
+			continue
+		}
+		hasTags := true
+		for _, tag := range params["tag"] {
+			hasTags = hasTags && slices.Contains(tags, tag)
+		}
+		if !hasTags {
+			continue
+		}
+
+		results = append(results, map[string]interface{}{
+			"uid":         uid,
+			"title":       title,
+			"type":        "dash-db",
+			"url":         fmt.Sprintf("/d/%s", uid),
+			"tags":        tags,
+			"folderUid":   stored.folderUid,
+			"folderTitle": s.folders[stored.folderUid].Title,
+		})
+	}
+	writeJson(w, http.StatusOK, results)
+}
+
+func (s *Server) listFolders(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	parentUid := r.URL.Query().Get("parentUid")
+	folders := []Folder{}
+	for _, uid := range sortedKeys(s.folders) {
+		if s.folders[uid].ParentUid == parentUid {
+			folders = append(folders, s.folders[uid])
+		}
+	}
+	writeJson(w, http.StatusOK, folders)
+}
+
+func (s *Server) createFolder(w http.ResponseWriter, r *http.Request) {
+	var folder Folder
+	if err := json.NewDecoder(r.Body).Decode(&folder); err != nil || folder.Title == "" {
+		writeJson(w, http.StatusBadRequest, map[string]string{"message": "bad request data"})
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if folder.Uid == "" {
+		s.nextId += 1
+		folder.Uid = fmt.Sprintf("folder-%d", s.nextId)
+	}
+	if _, ok := s.folders[folder.Uid]; ok {
+		writeJson(w, http.StatusConflict, map[string]string{"message": "a folder with the same uid already exists"})
+		return
+	}
+	s.folders[folder.Uid] = folder
+	writeJson(w, http.StatusOK, folder)
+}
+
+func (s *Server) getFolder(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	folder, ok := s.folders[r.PathValue("uid")]
+	if !ok {
+		writeJson(w, http.StatusNotFound, map[string]string{"message": "folder not found"})
+		return
+	}
+	writeJson(w, http.StatusOK, folder)
+}
+
+func (s *Server) getPolicy(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tree := copyModel(s.policy)
+	tree["provenance"] = "api"
+	writeJson(w, http.StatusOK, tree)
+}
+
+func (s *Server) putPolicy(w http.ResponseWriter, r *http.Request) {
+	var tree map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&tree); err != nil || tree["receiver"] == nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"message": "invalid policy tree"})
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.policy = tree
+	writeJson(w, http.StatusAccepted, map[string]string{"message": "policies updated"})
+}
+
+// Supports the name filter, callers hold the mutex
+func (s *Server) matchContactPoints(name string) []map[string]interface{} {
+	contactPoints := []map[string]interface{}{}
+	for _, uid := range sortedKeys(s.contactPoints) {
+		if name != "" && s.contactPoints[uid]["name"] != name {
+			continue
+		}
+		contactPoints = append(contactPoints, copyModel(s.contactPoints[uid]))
+	}
+	return contactPoints
+}
+
+func (s *Server) listContactPoints(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJson(w, http.StatusOK, s.matchContactPoints(r.URL.Query().Get("name")))
+}
+
+// Creates on POST and replaces the integration of the path uid on PUT
+func (s *Server) saveContactPoint(w http.ResponseWriter, r *http.Request) {
+	var contactPoint map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&contactPoint); err != nil || contactPoint["name"] == nil || contactPoint["type"] == nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"message": "invalid contact point"})
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	uid := r.PathValue("uid")
+	if uid != "" {
+		if _, ok := s.contactPoints[uid]; !ok {
+			writeJson(w, http.StatusNotFound, map[string]string{"message": "contact point not found"})
+			return
+		}
+	} else if uid, _ = contactPoint["uid"].(string); uid == "" {
+		s.nextId += 1
+		uid = fmt.Sprintf("contact-point-%d", s.nextId)
+	}
+	contactPoint["uid"] = uid
+	s.contactPoints[uid] = contactPoint
+
+	if r.Method == http.MethodPut {
+		writeJson(w, http.StatusAccepted, map[string]string{"message": "contactpoint updated"})
+		return
+	}
+	writeJson(w, http.StatusAccepted, contactPoint)
+}
+
+func (s *Server) deleteContactPoint(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.contactPoints, r.PathValue("uid"))
+	writeJson(w, http.StatusAccepted, map[string]string{"message": "contactpoint deleted"})
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func modelTags(model map[string]interface{}) []string {
+	tags := []string{}
+	values, _ := model["tags"].([]interface{})
+	for _, value := range values {
+		if tag, ok := value.(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Deep copies through JSON so callers never share maps with the server
+func copyModel(model map[string]interface{}) map[string]interface{} {
+	content, _ := json.Marshal(model)
+	var modelCopy map[string]interface{}
+	json.Unmarshal(content, &modelCopy)
+	return modelCopy
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
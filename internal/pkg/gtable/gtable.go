@@ -0,0 +1,27 @@
+package gtable
+
+import (
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// Spaces between the widest cell of a column and the next column
+const padding = 3
+
+// Writes the rows as aligned columns under the headers
+func Write(w io.Writer, headers []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, padding, ' ', 0)
+	for _, row := range append([][]string{headers}, rows...) {
+		if _, err := io.WriteString(tw, strings.Join(row, "\t")+"\n"); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// Prints the rows as aligned columns to stdout
+func Print(headers []string, rows [][]string) {
+	Write(os.Stdout, headers, rows)
+}
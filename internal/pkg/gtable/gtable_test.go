@@ -0,0 +1,24 @@
+package gtable
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	var output strings.Builder
+	err := Write(&output, []string{"UID", "PATH"}, [][]string{
+		{"abc", "cpu.json"},
+		{"watcher", "infra/memory.json"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "UID       PATH\n" +
+		"abc       cpu.json\n" +
+		"watcher   infra/memory.json\n"
+	if output.String() != expected {
+		t.Fatalf("got\n%s\nwant\n%s", output.String(), expected)
+	}
+}
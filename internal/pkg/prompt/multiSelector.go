@@ -26,6 +26,7 @@ type DashboardSelectItem struct {
 	Watching   string
 	Path       string
 	StripPath  string
+	Checked    string
 }
 
 type MultiSelector struct{}
@@ -108,7 +109,8 @@ func (c *MultiSelector) RunContextSelectMenu(currentContext string, configContex
 	return selectItems[index].Name, nil
 }
 
-func (c *MultiSelector) RunDashboardSelectMenu(dashboardPath string, watchedDashboards []gcontext.GContextGrafanaResource) (string, error) {
+// Reads all dashboard files in the dashboard directory into select items
+func collectDashboardSelectItems(dashboardPath string, watchedDashboards []gcontext.GContextGrafanaResource) ([]DashboardSelectItem, int, error) {
 	var selectItems []DashboardSelectItem
 
 	var maxWidth int
@@ -119,6 +121,11 @@ func (c *MultiSelector) RunDashboardSelectMenu(dashboardPath string, watchedDash
 			return err
 		}
 
+		// Library panel files saved next to dashboards are not dashboards
+		if info.IsDir() && info.Name() == "library-panels" {
+			return filepath.SkipDir
+		}
+
 		// Only parse through json files
 		if !info.IsDir() && filepath.Ext(path) == ".json" {
 			var dashboardSelectItem DashboardSelectItem
@@ -142,13 +149,22 @@ func (c *MultiSelector) RunDashboardSelectMenu(dashboardPath string, watchedDash
 		}
 		return nil
 	}); err != nil {
-		return "", err
+		return nil, 0, err
 	}
 
 	for i := range selectItems {
 		selectItems[i].PaddedName = selectItems[i].Name + strings.Repeat(" ", maxWidth-len(selectItems[i].Name))
 	}
 
+	return selectItems, maxWidth, nil
+}
+
+func (c *MultiSelector) RunDashboardSelectMenu(dashboardPath string, watchedDashboards []gcontext.GContextGrafanaResource) (string, error) {
+	selectItems, maxWidth, err := collectDashboardSelectItems(dashboardPath, watchedDashboards)
+	if err != nil {
+		return "", err
+	}
+
 	templates := &promptui.SelectTemplates{
 		Label:    "{{ . }}",
 		Active:   "{{.Watching}}{{.PaddedName}}{{.StripPath}}",
@@ -178,6 +194,111 @@ func (c *MultiSelector) RunDashboardSelectMenu(dashboardPath string, watchedDash
 	return selectItems[index].Path, nil
 }
 
+// Checkbox style select menu, each selection toggles a dashboard
+// Selecting the done item returns the checked dashboard paths
+func (c *MultiSelector) RunDashboardMultiSelectMenu(dashboardPath string, watchedDashboards []gcontext.GContextGrafanaResource) ([]string, error) {
+	dashboardItems, maxWidth, err := collectDashboardSelectItems(dashboardPath, watchedDashboards)
+	if err != nil {
+		return nil, err
+	}
+	return runCheckboxMenu(dashboardItems, maxWidth, "RELATIVE PATH")
+}
+
+// Checkbox style select menu over the watched dashboards only
+// Returns the checked dashboard paths
+func (c *MultiSelector) RunWatcherMultiSelectMenu(dashboardPath string, watchedDashboards []gcontext.GContextGrafanaResource) ([]string, error) {
+	var dashboardItems []DashboardSelectItem
+	var maxWidth int
+
+	for _, resource := range watchedDashboards {
+		var dashboardSelectItem DashboardSelectItem
+		dashboardSelectItem.Name = filepath.Base(resource.Path)
+		dashboardSelectItem.Path = resource.Path
+		dashboardSelectItem.StripPath = strings.TrimPrefix(resource.Path, dashboardPath) + strings.Repeat(" ", 3) + resource.Uid
+		dashboardSelectItem.Watching = "*" + strings.Repeat(" ", 2)
+
+		if len(dashboardSelectItem.Name) > maxWidth {
+			maxWidth = len(dashboardSelectItem.Name) + 15
+		}
+		dashboardItems = append(dashboardItems, dashboardSelectItem)
+	}
+
+	for i := range dashboardItems {
+		dashboardItems[i].PaddedName = dashboardItems[i].Name + strings.Repeat(" ", maxWidth-len(dashboardItems[i].Name))
+	}
+	return runCheckboxMenu(dashboardItems, maxWidth, "RELATIVE PATH   WATCHER UID")
+}
+
+// Toggles dashboards until the done item is selected
+func runCheckboxMenu(dashboardItems []DashboardSelectItem, maxWidth int, pathHeader string) ([]string, error) {
+	for i := range dashboardItems {
+		dashboardItems[i].Checked = "[ ] "
+	}
+
+	// First item finishes the selection
+	doneItem := DashboardSelectItem{
+		Name:      "Done",
+		Watching:  " " + strings.Repeat(" ", 2),
+		Checked:   "    ",
+		StripPath: "",
+	}
+	doneItem.PaddedName = doneItem.Name + strings.Repeat(" ", max(maxWidth-len(doneItem.Name), 0))
+
+	templates := &promptui.SelectTemplates{
+		Label:    "{{ . }}",
+		Active:   "{{.Watching}}{{.Checked}}{{.PaddedName}}{{.StripPath}}",
+		Inactive: "{{.Watching}}{{.Checked}}{{.PaddedName | faint}}{{.StripPath | faint}}",
+		Selected: "✔ {{.Checked}}{{.Name }}",
+	}
+
+	// Create header
+	header := "  " + strings.Repeat(" ", 7) + fmt.Sprintf("%s%s", "NAME"+strings.Repeat(" ", max(maxWidth-len("NAME"), 0)), pathHeader)
+
+	cursorPos := 0
+	for {
+		selectItems := append([]DashboardSelectItem{doneItem}, dashboardItems...)
+
+		prompt := promptui.Select{
+			Label:        header,
+			Items:        selectItems,
+			Size:         len(selectItems),
+			CursorPos:    cursorPos,
+			Templates:    templates,
+			HideSelected: true,
+			HideHelp:     false,
+		}
+
+		index, _, err := prompt.Run()
+
+		if err == promptui.ErrInterrupt || err == promptui.ErrAbort {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if index == 0 {
+			break
+		}
+
+		// Toggle selected dashboard
+		if dashboardItems[index-1].Checked == "[x] " {
+			dashboardItems[index-1].Checked = "[ ] "
+		} else {
+			dashboardItems[index-1].Checked = "[x] "
+		}
+		cursorPos = index
+	}
+
+	var selectedPaths []string
+	for _, item := range dashboardItems {
+		if item.Checked == "[x] " {
+			fmt.Println("✔ Selected dashboard:", item.Name)
+			selectedPaths = append(selectedPaths, item.Path)
+		}
+	}
+	return selectedPaths, nil
+}
+
 func (c *MultiSelector) RunGetContextDisplay(currentContext string, configContexts []gcontext.GContext) error {
 	maxWidths := struct {
 		name   int
@@ -245,3 +366,66 @@ func (c *MultiSelector) RunGetContextDisplay(currentContext string, configContex
 	fmt.Println(contextSelectString)
 	return nil
 }
+
+type ConflictSelectItem struct {
+	Name        string
+	Description string
+}
+
+// Asks how to settle a dashboard changed both locally and in Grafana
+// Returns local, remote or merge
+func (c *MultiSelector) RunConflictSelectMenu(filePath string) (string, error) {
+	selectItems := []ConflictSelectItem{
+		{Name: "local", Description: "Keep the local file and overwrite the watcher dashboard"},
+		{Name: "remote", Description: "Keep the Grafana changes and overwrite the local file"},
+		{Name: "merge", Description: "Three-way merge both changes into the local file"},
+	}
+
+	templates := &promptui.SelectTemplates{
+		Label:    "{{ . }}",
+		Active:   "{{.Name}}" + strings.Repeat(" ", 4) + "{{.Description}}",
+		Inactive: "{{.Name | faint}}" + strings.Repeat(" ", 4) + "{{.Description | faint}}",
+		Selected: "✔ Selected resolution: {{.Name}}",
+	}
+
+	prompt := promptui.Select{
+		Label:        fmt.Sprintf("Conflicting changes in %s", filePath),
+		Items:        selectItems,
+		Size:         len(selectItems),
+		Templates:    templates,
+		HideSelected: false,
+		HideHelp:     false,
+	}
+
+	index, _, err := prompt.Run()
+	if err != nil {
+		return "", err
+	}
+	return selectItems[index].Name, nil
+}
+
+// Asks what to do with a leftover watcher out of sync with its local file
+// Interrupting returns an error, never a choice, so nothing is changed
+func (c *MultiSelector) RunResumeSelectMenu(filePath string, selectItems []ConflictSelectItem) (string, error) {
+	templates := &promptui.SelectTemplates{
+		Label:    "{{ . }}",
+		Active:   "{{.Name}}" + strings.Repeat(" ", 4) + "{{.Description}}",
+		Inactive: "{{.Name | faint}}" + strings.Repeat(" ", 4) + "{{.Description | faint}}",
+		Selected: "✔ Selected action: {{.Name}}",
+	}
+
+	prompt := promptui.Select{
+		Label:        fmt.Sprintf("Resume watcher of %s", filePath),
+		Items:        selectItems,
+		Size:         len(selectItems),
+		Templates:    templates,
+		HideSelected: false,
+		HideHelp:     false,
+	}
+
+	index, _, err := prompt.Run()
+	if err != nil {
+		return "", err
+	}
+	return selectItems[index].Name, nil
+}